@@ -25,7 +25,10 @@ package main
 import (
 	"fmt"
 	"net"
+	"net/http"
+	"os"
 	"strings"
+	"sync"
 
 	"github.com/rightscale/rsc/cm15"
 	"github.com/rightscale/rsc/cm16"
@@ -38,11 +41,20 @@ type Account struct {
 	RefreshToken string `mapstructure:"refresh_token" yaml:"refresh_token,omitempty"`
 	Username     string `mapstructure:"username" yaml:"username,omitempty"`
 	Password     string `mapstructure:"password" yaml:"password,omitempty"`
-	client15     *cm15.API
-	client16     *cm16.API
+
+	// RefreshTokenCommand and PasswordCommand are command lines which output the refresh token or password, such as
+	// `pass show rightscale`, so the secret does not have to be stored in the config file at all
+	RefreshTokenCommand string `mapstructure:"refresh_token_command" yaml:"refresh_token_command,omitempty"`
+	PasswordCommand     string `mapstructure:"password_command" yaml:"password_command,omitempty"`
+
+	client15 *cm15.API
+	client16 *cm16.API
 }
 
-const encryptedPrefix = "{ENCRYPTED}"
+const (
+	encryptedPrefix           = "{ENCRYPTED}"
+	passphraseEncryptedPrefix = "{PASSPHRASE}"
+)
 
 func (account *Account) Client15() (*cm15.API, error) {
 	if account.client15 == nil {
@@ -73,17 +85,108 @@ func (account *Account) Client16() (*cm16.API, error) {
 }
 
 func (account *Account) Auth() (rsapi.Authenticator, error) {
-	if account.RefreshToken != "" {
-		return rsapi.NewOAuthAuthenticator(account.RefreshToken, account.Id), nil
+	if account.RefreshToken != "" || account.RefreshTokenCommand != "" {
+		refreshToken, err := resolveSecret(account.RefreshToken, account.RefreshTokenCommand)
+		if err != nil {
+			return nil, fmt.Errorf("Could not get refresh token: %s", err.Error())
+		}
+		return &LockedAuthenticator{Authenticator: rsapi.NewOAuthAuthenticator(refreshToken, account.Id)}, nil
 	} else {
-		password, err := account.DecryptPassword()
+		password, err := resolveSecret(account.Password, account.PasswordCommand)
 		if err != nil {
-			return nil, err
+			return nil, fmt.Errorf("Could not get password: %s", err.Error())
+		}
+		return &LockedAuthenticator{Authenticator: rsapi.NewBasicAuthenticator(account.Username, password, account.Id)}, nil
+	}
+}
+
+// LockedAuthenticator signs requests with an rsapi.Authenticator one at a time. The OAuth and cookie authenticators
+// refresh their access token or session without a lock when signing a request after it expired, so they cannot be
+// used by uploads running at once otherwise. Only signing is serialized, the requests themselves still run at once.
+type LockedAuthenticator struct {
+	rsapi.Authenticator
+	lock sync.Mutex
+}
+
+// Sign signs a request, refreshing the access token or session first if needed.
+func (auth *LockedAuthenticator) Sign(req *http.Request) error {
+	auth.lock.Lock()
+	defer auth.lock.Unlock()
+	return auth.Authenticator.Sign(req)
+}
+
+// SetHost updates the host used to refresh the access token or session.
+func (auth *LockedAuthenticator) SetHost(host string) {
+	auth.lock.Lock()
+	defer auth.lock.Unlock()
+	auth.Authenticator.SetHost(host)
+}
+
+// CanAuthenticate checks that the credentials can sign requests.
+func (auth *LockedAuthenticator) CanAuthenticate(host string) error {
+	auth.lock.Lock()
+	defer auth.lock.Unlock()
+	return auth.Authenticator.CanAuthenticate(host)
+}
+
+// resolveSecret gets a secret by running its command if it has one or else by decrypting its stored value.
+func resolveSecret(value, command string) (string, error) {
+	if command != "" {
+		cmd := shellCommand(command)
+		cmd.Stdin = os.Stdin
+		cmd.Stderr = os.Stderr
+		output, err := cmd.Output()
+		if err != nil {
+			return "", fmt.Errorf("%s: %s", command, err.Error())
 		}
-		return rsapi.NewBasicAuthenticator(account.Username, password, account.Id), nil
+		secret := strings.TrimRight(string(output), "\r\n")
+		if secret == "" {
+			return "", fmt.Errorf("%s: no output", command)
+		}
+		return secret, nil
+	}
+	return decryptSecret(value)
+}
+
+// decryptSecret decrypts a passphrase encrypted or legacy encrypted value and returns any other value as is.
+func decryptSecret(value string) (string, error) {
+	switch {
+	case strings.HasPrefix(value, passphraseEncryptedPrefix):
+		passphrase, err := getPassphrase(false)
+		if err != nil {
+			return "", err
+		}
+		return DecryptWithPassphrase(strings.TrimPrefix(value, passphraseEncryptedPrefix), passphrase)
+	case strings.HasPrefix(value, encryptedPrefix):
+		return Decrypt(strings.TrimPrefix(value, encryptedPrefix))
+	default:
+		return value, nil
 	}
 }
 
+// EncryptWithPassphrase encrypts the refresh token or password of the account with the passphrase. Legacy encrypted
+// passwords are migrated and values which are already encrypted with a passphrase or come from a command are left
+// alone. It returns whether anything was encrypted.
+func (account *Account) EncryptWithPassphrase(passphrase string) (bool, error) {
+	encrypted := false
+	for _, value := range []*string{&account.RefreshToken, &account.Password} {
+		if *value == "" || strings.HasPrefix(*value, passphraseEncryptedPrefix) {
+			continue
+		}
+		secret, err := decryptSecret(*value)
+		if err != nil {
+			return false, err
+		}
+		ciphertext, err := EncryptWithPassphrase(secret, passphrase)
+		if err != nil {
+			return false, err
+		}
+		*value = passphraseEncryptedPrefix + ciphertext
+		encrypted = true
+	}
+	return encrypted, nil
+}
+
 func (account *Account) EncryptPassword(password string) error {
 	p, err := Encrypt(password)
 	if err != nil {
@@ -94,14 +197,20 @@ func (account *Account) EncryptPassword(password string) error {
 }
 
 func (account *Account) DecryptPassword() (string, error) {
-	if strings.HasPrefix(account.Password, encryptedPrefix) {
-		return Decrypt(strings.TrimPrefix(account.Password, encryptedPrefix))
-	} else {
-		return account.Password, nil
-	}
+	return decryptSecret(account.Password)
+}
+
+// passphraseEncrypted returns whether the refresh token or password of the account is encrypted with a passphrase.
+func (account *Account) passphraseEncrypted() bool {
+	return strings.HasPrefix(account.RefreshToken, passphraseEncryptedPrefix) ||
+		strings.HasPrefix(account.Password, passphraseEncryptedPrefix)
 }
 
 func (account *Account) MaskPassword() (string, error) {
+	// do not ask for the passphrase just to count the characters
+	if strings.HasPrefix(account.Password, passphraseEncryptedPrefix) {
+		return passphraseEncryptedPrefix, nil
+	}
 	password, err := account.DecryptPassword()
 	if err != nil {
 		return "", err
@@ -23,6 +23,11 @@
 package main_test
 
 import (
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
 	. "github.com/rightscale/right_st"
 
 	. "github.com/onsi/ginkgo"
@@ -146,4 +151,105 @@ var _ = Describe("Account", func() {
 			})
 		})
 	})
+
+	Context("With secret commands", func() {
+		It("Gets the refresh token from a command", func() {
+			account := Account{Id: 54321, Host: "localhost", RefreshTokenCommand: "echo def1234567890abcdef1234567890abcdef12345"}
+			auth, err := account.Auth()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(auth).To(BeAssignableToTypeOf(&LockedAuthenticator{}))
+		})
+
+		It("Returns an error when the command fails", func() {
+			account := Account{Id: 54321, Host: "localhost", Username: "cool.dude@rightscale.com", PasswordCommand: "exit 1"}
+			_, err := account.Auth()
+			Expect(err).To(MatchError(HavePrefix("Could not get password: exit 1:")))
+		})
+
+		It("Returns an error when the command has no output", func() {
+			account := Account{Id: 54321, Host: "localhost", RefreshTokenCommand: "echo"}
+			_, err := account.Auth()
+			Expect(err).To(MatchError("Could not get refresh token: echo: no output"))
+		})
+	})
+
+	Context("With a passphrase", func() {
+		BeforeEach(func() {
+			if err := os.Setenv(PassphraseEnv, "correct horse battery staple"); err != nil {
+				panic(err)
+			}
+		})
+
+		AfterEach(func() {
+			if err := os.Unsetenv(PassphraseEnv); err != nil {
+				panic(err)
+			}
+		})
+
+		It("Encrypts and migrates credentials", func() {
+			account := Account{
+				RefreshToken: "def1234567890abcdef1234567890abcdef12345",
+				Password:     "{ENCRYPTED}qJkMPWpP+Op9cSeIK9+XHqaSo7axur2shBCdKA==",
+			}
+			encrypted, err := account.EncryptWithPassphrase("correct horse battery staple")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(encrypted).To(BeTrue())
+			Expect(account.RefreshToken).To(HavePrefix("{PASSPHRASE}"))
+			Expect(account.Password).To(HavePrefix("{PASSPHRASE}"))
+
+			password, err := account.DecryptPassword()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(password).To(Equal("hunter2"))
+			Expect(account.MaskPassword()).To(Equal("{PASSPHRASE}"))
+
+			encrypted, err = account.EncryptWithPassphrase("correct horse battery staple")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(encrypted).To(BeFalse())
+		})
+	})
+})
+
+// signingAuthenticator records how many requests it signs at once.
+type signingAuthenticator struct {
+	lock    sync.Mutex
+	signing int
+	most    int
+}
+
+func (auth *signingAuthenticator) Sign(req *http.Request) error {
+	auth.lock.Lock()
+	auth.signing++
+	if auth.signing > auth.most {
+		auth.most = auth.signing
+	}
+	auth.lock.Unlock()
+	time.Sleep(time.Millisecond)
+	auth.lock.Lock()
+	auth.signing--
+	auth.lock.Unlock()
+	return nil
+}
+
+func (auth *signingAuthenticator) SetHost(host string) {}
+
+func (auth *signingAuthenticator) CanAuthenticate(host string) error { return nil }
+
+var _ = Describe("Locked authenticator", func() {
+	It("signs one request at a time", func() {
+		signing := &signingAuthenticator{}
+		auth := &LockedAuthenticator{Authenticator: signing}
+		var wg sync.WaitGroup
+		for i := 0; i < 10; i++ {
+			wg.Add(1)
+			go func() {
+				defer GinkgoRecover()
+				defer wg.Done()
+				req, err := http.NewRequest("GET", "https://localhost/api/sessions", nil)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(auth.Sign(req)).To(Succeed())
+			}()
+		}
+		wg.Wait()
+		Expect(signing.most).To(Equal(1))
+	})
 })
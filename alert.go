@@ -3,26 +3,50 @@ package main
 import (
 	"fmt"
 	"io/ioutil"
+	"os"
 	"path/filepath"
+	"regexp"
 	"strconv"
 	"strings"
+	"unicode"
 
 	"github.com/rightscale/rsc/cm15"
 	"github.com/rightscale/rsc/rsapi"
 	"gopkg.in/yaml.v2"
 )
 
+// Alert is an alert spec on disk. The condition of the alert is either given by a Clause in the form of a sentence or
+// by the structured fields Metric, Value Type, Condition, Threshold, Duration and either Escalation or Vote Type and
+// Vote Tag, which map directly onto the API fields.
 type Alert struct {
 	Name        string `yaml:"Name"`
 	Description string `yaml:"Description,omitempty"`
-	Clause      string `yaml:"Clause"`
+	Clause      string `yaml:"Clause,omitempty"`
+	Metric      string `yaml:"Metric,omitempty"`
+	ValueType   string `yaml:"Value Type,omitempty"`
+	Condition   string `yaml:"Condition,omitempty"`
+	Threshold   string `yaml:"Threshold,omitempty"`
+	Duration    int    `yaml:"Duration,omitempty"`
+	Escalation  string `yaml:"Escalation,omitempty"`
+	VoteType    string `yaml:"Vote Type,omitempty"`
+	VoteTag     string `yaml:"Vote Tag,omitempty"`
+	Override    bool   `yaml:"Override,omitempty"`
 	File        string `yaml:"-"`
+
+	source string // the Alert file this alert was read from, empty if inline
 }
 
 type Alerts struct {
 	Alerts []*Alert `yaml:"Alerts"`
 }
 
+const (
+	ClauseAlertFormat     = "clause"
+	StructuredAlertFormat = "structured"
+)
+
+var alertComparisonOperators = []string{">", ">=", "<", "<=", "==", "!="}
+
 func (alert *Alert) UnmarshalYAML(unmarshal func(interface{}) error) error {
 	err := unmarshal(&alert.File)
 	if err == nil {
@@ -33,7 +57,16 @@ func (alert *Alert) UnmarshalYAML(unmarshal func(interface{}) error) error {
 	var mapAlert struct {
 		Name        string `yaml:"Name"`
 		Description string `yaml:"Description,omitempty"`
-		Clause      string `yaml:"Clause"`
+		Clause      string `yaml:"Clause,omitempty"`
+		Metric      string `yaml:"Metric,omitempty"`
+		ValueType   string `yaml:"Value Type,omitempty"`
+		Condition   string `yaml:"Condition,omitempty"`
+		Threshold   string `yaml:"Threshold,omitempty"`
+		Duration    int    `yaml:"Duration,omitempty"`
+		Escalation  string `yaml:"Escalation,omitempty"`
+		VoteType    string `yaml:"Vote Type,omitempty"`
+		VoteTag     string `yaml:"Vote Tag,omitempty"`
+		Override    bool   `yaml:"Override,omitempty"`
 	}
 	err = unmarshal(&mapAlert)
 	if err != nil {
@@ -42,10 +75,99 @@ func (alert *Alert) UnmarshalYAML(unmarshal func(interface{}) error) error {
 	alert.Name = mapAlert.Name
 	alert.Description = mapAlert.Description
 	alert.Clause = mapAlert.Clause
+	alert.Metric = mapAlert.Metric
+	alert.ValueType = mapAlert.ValueType
+	alert.Condition = mapAlert.Condition
+	alert.Threshold = mapAlert.Threshold
+	alert.Duration = mapAlert.Duration
+	alert.Escalation = mapAlert.Escalation
+	alert.VoteType = mapAlert.VoteType
+	alert.VoteTag = mapAlert.VoteTag
+	alert.Override = mapAlert.Override
 	alert.File = ""
 	return nil
 }
 
+// isStructured returns whether any of the structured alert fields are set.
+func (alert *Alert) isStructured() bool {
+	return alert.Metric != "" || alert.ValueType != "" || alert.Condition != "" || alert.Threshold != "" ||
+		alert.Duration != 0 || alert.Escalation != "" || alert.VoteType != "" || alert.VoteTag != ""
+}
+
+// Spec returns the API alert spec described by either the Clause or the structured fields of the alert.
+func (alert *Alert) Spec() (*cm15.AlertSpec, error) {
+	if alert.Clause != "" {
+		if alert.isStructured() {
+			return nil, fmt.Errorf("Alert must have either a Clause or Metric, Value Type, Condition, Threshold, Duration and Escalation or Vote Type/Vote Tag fields, not both")
+		}
+		return parseAlertClause(alert.Clause)
+	}
+	if !alert.isStructured() {
+		return nil, fmt.Errorf("Alert must have either a Clause or Metric, Value Type, Condition, Threshold, Duration and Escalation or Vote Type/Vote Tag fields")
+	}
+
+	alertSpec := &cm15.AlertSpec{
+		File:      alert.Metric,
+		Variable:  alert.ValueType,
+		Condition: alert.Condition,
+		Threshold: alert.Threshold,
+		Duration:  alert.Duration,
+	}
+	if alert.Metric == "" {
+		return nil, fmt.Errorf("Alert Metric must be set, should be like 'cpu-0/cpu-idle'")
+	}
+	if alert.ValueType == "" {
+		return nil, fmt.Errorf("Alert Value Type must be set, should be like 'value'")
+	}
+	if err := validateAlertCondition(alert.Condition); err != nil {
+		return nil, err
+	}
+	if alert.Threshold == "" {
+		return nil, fmt.Errorf("Alert Threshold must be set")
+	}
+	if alert.Duration < 1 {
+		return nil, fmt.Errorf("Alert Duration must be a positive integer > 0")
+	}
+	if alert.Escalation != "" {
+		if alert.VoteType != "" || alert.VoteTag != "" {
+			return nil, fmt.Errorf("Alert must have either an Escalation or a Vote Type and Vote Tag, not both")
+		}
+		alertSpec.EscalationName = alert.Escalation
+	} else {
+		voteType := strings.ToLower(alert.VoteType)
+		if voteType != "grow" && voteType != "shrink" {
+			return nil, fmt.Errorf("Alert must have an Escalation or a Vote Type of grow or shrink")
+		}
+		if alert.VoteTag == "" {
+			return nil, fmt.Errorf("Alert Vote Tag must be set when Vote Type is set")
+		}
+		alertSpec.VoteType = voteType
+		alertSpec.VoteTag = alert.VoteTag
+	}
+	return alertSpec, nil
+}
+
+// AlertFromSpec creates an Alert from an API alert spec using either the clause or structured format.
+func AlertFromSpec(alertSpec *cm15.AlertSpec, format string) *Alert {
+	alert := &Alert{
+		Name:        alertSpec.Name,
+		Description: removeCarriageReturns(alertSpec.Description),
+	}
+	if format == StructuredAlertFormat {
+		alert.Metric = alertSpec.File
+		alert.ValueType = alertSpec.Variable
+		alert.Condition = alertSpec.Condition
+		alert.Threshold = alertSpec.Threshold
+		alert.Duration = alertSpec.Duration
+		alert.Escalation = alertSpec.EscalationName
+		alert.VoteType = alertSpec.VoteType
+		alert.VoteTag = alertSpec.VoteTag
+	} else {
+		alert.Clause = printAlertClause(*alertSpec)
+	}
+	return alert
+}
+
 // ExpandAlerts goes through a slice of Alert structs and for any that have a File reference, it reads in
 // Alert structs from the file and recurses through those to see if there are more File references and returns the new
 // slice. It keeps track of which files it opens so it does not read the same file twice.
@@ -82,21 +204,120 @@ func expandAlerts(dir string, files map[string]bool, alerts []*Alert) ([]*Alert,
 		if err != nil {
 			return nil, err
 		}
+		for _, a := range alerts {
+			if a.source == "" {
+				a.source = alert.File
+			}
+		}
 		expandedAlerts = append(expandedAlerts, alerts...)
 	}
 	return expandedAlerts, nil
 }
 
+// ResolveAlerts checks expanded alerts for names that collide once normalized the way the API does. A collision is an
+// error unless exactly one of the alerts is marked Override, in which case it replaces the others, which is how a
+// ServerTemplate replaces an alert from a shared Alert file. It also returns warnings for Override alerts which do not
+// replace anything and for alerts with identical conditions under different names.
+func ResolveAlerts(alerts []*Alert) ([]*Alert, []string, []error) {
+	var (
+		warnings []string
+		errors   []error
+		names    []string
+	)
+	groups := make(map[string][]*Alert)
+	for _, alert := range alerts {
+		name := normalizeAlertName(alert.Name)
+		if _, ok := groups[name]; !ok {
+			names = append(names, name)
+		}
+		groups[name] = append(groups[name], alert)
+	}
+
+	chosen := make(map[*Alert]bool)
+	for _, name := range names {
+		group := groups[name]
+		var overrides []*Alert
+		for _, alert := range group {
+			if alert.Override {
+				overrides = append(overrides, alert)
+			}
+		}
+		switch {
+		case len(group) == 1:
+			if group[0].Override {
+				warnings = append(warnings, fmt.Sprintf("Alert '%s' (%s) is marked Override but there is no other Alert with that name to replace",
+					group[0].Name, group[0].describeSource()))
+			}
+			chosen[group[0]] = true
+		case len(overrides) == 1:
+			chosen[overrides[0]] = true
+		case len(overrides) == 0:
+			errors = append(errors, fmt.Errorf("Alert name '%s' is used by more than one Alert (%s), mark one of them Override: true to replace the others",
+				group[0].Name, describeAlertSources(group)))
+		default:
+			errors = append(errors, fmt.Errorf("Alert name '%s' is marked Override by more than one Alert (%s)",
+				group[0].Name, describeAlertSources(overrides)))
+		}
+	}
+
+	resolved := make([]*Alert, 0, len(chosen))
+	for _, alert := range alerts {
+		if chosen[alert] {
+			resolved = append(resolved, alert)
+		}
+	}
+
+	conditions := make(map[string]*Alert)
+	for _, alert := range resolved {
+		spec, err := alert.Spec()
+		if err != nil {
+			continue
+		}
+		condition := printAlertClause(*spec)
+		if other, ok := conditions[condition]; ok {
+			warnings = append(warnings, fmt.Sprintf("Alerts '%s' (%s) and '%s' (%s) have identical conditions: %s",
+				other.Name, other.describeSource(), alert.Name, alert.describeSource(), condition))
+		} else {
+			conditions[condition] = alert
+		}
+	}
+
+	return resolved, warnings, errors
+}
+
+func (alert *Alert) describeSource() string {
+	if alert.source == "" {
+		return "inline"
+	}
+	return alert.source
+}
+
+func describeAlertSources(alerts []*Alert) string {
+	sources := make([]string, len(alerts))
+	for i, alert := range alerts {
+		sources[i] = alert.describeSource()
+	}
+	return strings.Join(sources, ", ")
+}
+
 // Expected Format with array index offsets into tokens array below:
 // If <Metric>.<ValueType> <ComparisonOperator> <Threshold> for <Duration> minutes Then <Escalate|Grow|Shrink> <ActionValue>
 // 0  1                       2                    3           4   5          6       7    8                      9
+// Tokens are separated by any amount of whitespace and may be quoted with single or double quotes to include
+// whitespace. Any unquoted tokens after the ActionValue are joined to it with single spaces.
 func parseAlertClause(alert string) (*cm15.AlertSpec, error) {
 	alertSpec := new(cm15.AlertSpec)
-	tokens := strings.SplitN(alert, " ", 10)
 	alertFmt := `If <Metric>.<ValueType> <ComparisonOperator> <Threshold> for <Duration> minutes Then <Action> <ActionValue>`
-	if len(tokens) != 10 {
+	tokens, err := tokenizeAlertClause(alert)
+	if err != nil {
+		return nil, fmt.Errorf("Alert clause misformatted: %s. Must be of format: '%s'", err.Error(), alertFmt)
+	}
+	if len(tokens) < 10 {
 		return nil, fmt.Errorf("Alert clause misformatted: not long enough. Must be of format: '%s'", alertFmt)
 	}
+	if len(tokens) > 10 {
+		tokens = append(tokens[:9], strings.Join(tokens[9:], " "))
+	}
 	if strings.ToLower(tokens[0]) != "if" {
 		return nil, fmt.Errorf("Alert clause misformatted: missing If. Must be of format: '%s'", alertFmt)
 	}
@@ -108,24 +329,13 @@ func parseAlertClause(alert string) (*cm15.AlertSpec, error) {
 	if period == -1 {
 		return nil, fmt.Errorf("Alert <Metric>.<ValueType> misformatted, should be like 'cpu-0/cpu-idle.value'.")
 	}
-	// Check metricTokens[0] should contain a slash.
-	// Check metricTokens[1] can be numerous types: count, cumulative_requests, current_session, free
-	//   midterm, percent, processes, read, running, rx, tx, shortterm, state, status, threads,
-	//   used, users, value, write
 	alertSpec.File = metric[:period]
 	alertSpec.Variable = metric[period+1:]
 	if len(alertSpec.File) == 0 || len(alertSpec.Variable) == 0 {
 		return nil, fmt.Errorf("Alert <Metric>.<ValueType> misformatted, should be like 'cpu-0/cpu-idle.value'.")
 	}
-	comparisonValues := []string{">", ">=", "<", "<=", "==", "!="}
-	foundValue := false
-	for _, val := range comparisonValues {
-		if tokens[2] == val {
-			foundValue = true
-		}
-	}
-	if !foundValue {
-		return nil, fmt.Errorf("Alert <ComparisonOperator> must be one of the following comparison operators: %s", strings.Join(comparisonValues, ", "))
+	if err := validateAlertCondition(tokens[2]); err != nil {
+		return nil, err
 	}
 	alertSpec.Condition = tokens[2]
 	// Threshold must be one of NaN, numeric OR booting, decommission, operational, pending, stranded, terminated
@@ -158,6 +368,72 @@ func parseAlertClause(alert string) (*cm15.AlertSpec, error) {
 	return alertSpec, nil
 }
 
+// tokenizeAlertClause splits an alert clause into whitespace separated tokens. Parts of a token may be quoted with
+// single or double quotes in which case whitespace is kept. Within double quotes a backslash escapes the next character.
+func tokenizeAlertClause(clause string) ([]string, error) {
+	var (
+		tokens  []string
+		token   strings.Builder
+		inToken bool
+		quote   rune
+		escaped bool
+	)
+	for _, r := range clause {
+		switch {
+		case escaped:
+			token.WriteRune(r)
+			escaped = false
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			} else if r == '\\' && quote == '"' {
+				escaped = true
+			} else {
+				token.WriteRune(r)
+			}
+		case r == '"' || r == '\'':
+			quote = r
+			inToken = true
+		case unicode.IsSpace(r):
+			if inToken {
+				tokens = append(tokens, token.String())
+				token.Reset()
+				inToken = false
+			}
+		default:
+			token.WriteRune(r)
+			inToken = true
+		}
+	}
+	if quote != 0 || escaped {
+		return nil, fmt.Errorf("unterminated %c quote", quote)
+	}
+	if inToken {
+		tokens = append(tokens, token.String())
+	}
+	return tokens, nil
+}
+
+// quoteAlertClauseToken quotes a token if it would otherwise not be read back as a single token.
+func quoteAlertClauseToken(token string) string {
+	if token != "" && !strings.ContainsAny(token, "\"' \t\r\n\v\f") {
+		return token
+	}
+	if !strings.ContainsAny(token, "'") {
+		return "'" + token + "'"
+	}
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(token) + `"`
+}
+
+func validateAlertCondition(condition string) error {
+	for _, val := range alertComparisonOperators {
+		if condition == val {
+			return nil
+		}
+	}
+	return fmt.Errorf("Alert <ComparisonOperator> must be one of the following comparison operators: %s", strings.Join(alertComparisonOperators, ", "))
+}
+
 // Complement to parseAlertClause
 // If <Metric>.<ValueType> <ComparisonOperator> <Threshold> for <Duration> minutes Then <Escalate|Grow|Shrink> <ActionValue>
 // 0  1                       2                    3           4   5          6       7    8                      9
@@ -170,64 +446,86 @@ func printAlertClause(as cm15.AlertSpec) string {
 		asAction = as.VoteType
 		asActionValue = as.VoteTag
 	}
-	alertStr := fmt.Sprintf("If %s.%s %s %s for %d minutes Then %s %s",
-		as.File, as.Variable, as.Condition, as.Threshold, as.Duration, asAction, asActionValue)
+	alertStr := fmt.Sprintf("If %s %s %s for %d minutes Then %s %s",
+		quoteAlertClauseToken(as.File+"."+as.Variable), quoteAlertClauseToken(as.Condition),
+		quoteAlertClauseToken(as.Threshold), as.Duration, quoteAlertClauseToken(asAction),
+		quoteAlertClauseToken(asActionValue))
 	return alertStr
 }
 
-// Make sure an alert as described in yaml file on disk are correctly structured.
-func validateAlert(alert *Alert) error {
+// alertSpecsEqual returns whether two alert specs have the same condition and action.
+func alertSpecsEqual(a, b *cm15.AlertSpec) bool {
+	return a.File == b.File && a.Variable == b.Variable && a.Condition == b.Condition &&
+		a.Threshold == b.Threshold && a.Duration == b.Duration && a.EscalationName == b.EscalationName &&
+		strings.ToLower(a.VoteType) == strings.ToLower(b.VoteType) && a.VoteTag == b.VoteTag
+}
+
+// Make sure an alert as described in yaml file on disk are correctly structured and uses one of the given metrics.
+func validateAlert(alert *Alert, metrics []*AlertMetric) error {
 	if alert.Name == "" {
 		return fmt.Errorf("Name field must be present")
 	}
-	_, err := parseAlertClause(alert.Clause)
+	spec, err := alert.Spec()
 	if err != nil {
 		return err
 	}
-	return nil
+	return ValidateAlertMetric(spec, metrics)
 }
 
 // Synchronizes alerts from the API to yaml file on disk
-func downloadAlerts(st *cm15.ServerTemplate) ([]*Alert, error) {
+func downloadAlerts(st *cm15.ServerTemplate, format string) ([]*Alert, error) {
+	return indexAlerts(getLink(st.Links, "alert_specs"), format)
+}
+
+// indexAlerts gets the alert specs from an alert_specs href and converts them to Alerts in the given format.
+func indexAlerts(alertSpecsHref string, format string) ([]*Alert, error) {
 	client, _ := Config.Account.Client15()
 
-	alertsLocator := client.AlertSpecLocator(getLink(st.Links, "alert_specs"))
+	alertsLocator := client.AlertSpecLocator(alertSpecsHref)
 	alertSpecs, err := alertsLocator.Index(rsapi.APIParams{})
 	if err != nil {
 		return nil, fmt.Errorf("Could not find Alerts with href %s: %s", alertsLocator.Href, err.Error())
 	}
 	alerts := make([]*Alert, len(alertSpecs))
 	for i, alertSpec := range alertSpecs {
-		alerts[i] = &Alert{
-			Name:        alertSpec.Name,
-			Description: removeCarriageReturns(alertSpec.Description),
-			Clause:      printAlertClause(*alertSpec),
-		}
+		alerts[i] = AlertFromSpec(alertSpec, format)
 	}
 	return alerts, nil
 }
 
 // Synchronizes alerts from yaml file on disk up to the API
 func uploadAlerts(stDef *ServerTemplate) error {
+	return syncAlerts(stDef.href, stDef.Alerts)
+}
+
+// syncAlerts adds, updates and removes the alert specs of a subject (a ServerTemplate, Deployment, Server or
+// ServerArray) so they match the given alerts. Alert specs the subject only inherits are left alone.
+func syncAlerts(subjectHref string, alerts []*Alert) error {
 	client, _ := Config.Account.Client15()
 
-	alertsLocator := client.AlertSpecLocator(stDef.href + "/alert_specs")
-	existingAlerts, err := alertsLocator.Index(rsapi.APIParams{})
+	alertsLocator := client.AlertSpecLocator(subjectHref + "/alert_specs")
+	alertSpecs, err := alertsLocator.Index(rsapi.APIParams{})
 	if err != nil {
 		return fmt.Errorf("Could not find AlertSpecs with href %s: %s", alertsLocator.Href, err.Error())
 	}
+	existingAlerts := make([]*cm15.AlertSpec, 0, len(alertSpecs))
+	for _, alert := range alertSpecs {
+		if subject := getLink(alert.Links, "subject"); subject == "" || subject == subjectHref {
+			existingAlerts = append(existingAlerts, alert)
+		}
+	}
 	seenAlert := make(map[string]bool)
 	alertLookup := make(map[string]*cm15.AlertSpec)
 	for _, alert := range existingAlerts {
 		alertLookup[normalizeAlertName(alert.Name)] = alert
 	}
 	// Add/Update alerts
-	for _, alert := range stDef.Alerts {
-		parsedAlert, _ := parseAlertClause(alert.Clause)
+	for _, alert := range alerts {
+		parsedAlert, _ := alert.Spec()
 		seenAlert[normalizeAlertName(alert.Name)] = true
 		existingAlert, ok := alertLookup[normalizeAlertName(alert.Name)]
 		if ok { // update
-			if alert.Clause != printAlertClause(*existingAlert) || alert.Description != existingAlert.Description {
+			if !alertSpecsEqual(parsedAlert, existingAlert) || alert.Description != existingAlert.Description {
 				alertsUpdateLocator := client.AlertSpecLocator(getLink(existingAlert.Links, "self"))
 
 				fmt.Printf("  Updating Alert %s\n", alert.Name)
@@ -286,3 +584,160 @@ func uploadAlerts(stDef *ServerTemplate) error {
 func normalizeAlertName(alertName string) string {
 	return strings.ToLower(strings.TrimSpace(alertName))
 }
+
+// alertTargetHref matches the hrefs of the resources alerts can be managed on besides ServerTemplates.
+var alertTargetHref = regexp.MustCompile(`^/api/(deployments|servers|server_arrays)/\d+$`)
+
+// findAlertTarget distills a Deployment, Server or ServerArray href or name into the href and name of the resource.
+// A name has to match exactly one resource across all three types.
+func findAlertTarget(target string) (string, string, error) {
+	client, _ := Config.Account.Client15()
+
+	var hrefs []string
+	if alertTargetHref.MatchString(target) {
+		hrefs = []string{target}
+	} else {
+		for _, resourceType := range []string{"deployments", "servers", "server_arrays"} {
+			typeHrefs, err := paramToHrefs(resourceType, target, 0)
+			if err != nil {
+				return "", "", err
+			}
+			hrefs = append(hrefs, typeHrefs...)
+		}
+	}
+	if len(hrefs) > 1 {
+		return "", "", fmt.Errorf("Matched multiple Deployments, Servers or ServerArrays with the name '%s': %s. "+
+			"Don't know which one to use. Please specify an href to use.", target, strings.Join(hrefs, ", "))
+	} else if len(hrefs) == 0 {
+		return "", "", fmt.Errorf("Found no Deployment, Server or ServerArray matching '%s'", target)
+	}
+
+	href := hrefs[0]
+	var (
+		name string
+		err  error
+	)
+	switch alertTargetHref.FindStringSubmatch(href)[1] {
+	case "deployments":
+		var deployment *cm15.Deployment
+		if deployment, err = client.DeploymentLocator(href).Show(rsapi.APIParams{}); err == nil {
+			name = deployment.Name
+		}
+	case "servers":
+		var server *cm15.Server
+		if server, err = client.ServerLocator(href).Show(rsapi.APIParams{}); err == nil {
+			name = server.Name
+		}
+	case "server_arrays":
+		var serverArray *cm15.ServerArray
+		if serverArray, err = client.ServerArrayLocator(href).Show(rsapi.APIParams{}); err == nil {
+			name = serverArray.Name
+		}
+	}
+	if err != nil {
+		return "", "", fmt.Errorf("Could not find %s: %s", href, err.Error())
+	}
+	return href, name, nil
+}
+
+// LoadAlerts reads an Alerts YAML file and expands any Alert files it references.
+func LoadAlerts(file string) ([]*Alert, error) {
+	bytes, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var container Alerts
+	err = yaml.UnmarshalStrict(bytes, &container)
+	if err != nil {
+		return nil, fmt.Errorf("%v: %v", file, err)
+	}
+	return ExpandAlerts(filepath.Dir(file), container.Alerts)
+}
+
+func alertShow(target string) {
+	href, name, err := findAlertTarget(target)
+	if err != nil {
+		fatalError("%s", err.Error())
+	}
+	alerts, err := indexAlerts(href+"/alert_specs", ClauseAlertFormat)
+	if err != nil {
+		fatalError("%s", err.Error())
+	}
+
+	fmt.Printf("Name: %s\n", name)
+	fmt.Printf("HREF: %s\n", href)
+	fmt.Printf("Alerts:\n")
+	for _, alert := range alerts {
+		fmt.Printf("  - Name: %s\n", alert.Name)
+		fmt.Printf("    Description: %s\n", alert.Description)
+		fmt.Printf("    Value: %s\n", alert.Clause)
+	}
+}
+
+func alertDownload(target, downloadTo, format string) {
+	href, name, err := findAlertTarget(target)
+	if err != nil {
+		fatalError("%s", err.Error())
+	}
+
+	if downloadTo == "" {
+		downloadTo = cleanFileName(name) + "_alerts.yml"
+	} else if isDirectory(downloadTo) {
+		downloadTo = filepath.Join(downloadTo, cleanFileName(name)+"_alerts.yml")
+	}
+	fmt.Printf("Downloading Alerts of '%s' to '%s'\n", name, downloadTo)
+
+	alerts, err := indexAlerts(href+"/alert_specs", format)
+	if err != nil {
+		fatalError("Could not get Alerts from API: %s", err.Error())
+	}
+	bytes, err := yaml.Marshal(&Alerts{Alerts: alerts})
+	if err != nil {
+		fatalError("Creating yaml failed: %s", err.Error())
+	}
+	err = ioutil.WriteFile(downloadTo, bytes, 0644)
+	if err != nil {
+		fatalError("Could not create file: %s", err.Error())
+	}
+	fmt.Printf("Finished downloading %d Alerts of '%s' to '%s'\n", len(alerts), name, downloadTo)
+}
+
+func alertSync(file, target string) {
+	alerts, err := LoadAlerts(file)
+	if err != nil {
+		fatalError("%s", err.Error())
+	}
+	alerts, warnings, errors := ResolveAlerts(alerts)
+	for _, warning := range warnings {
+		fmt.Printf("WARNING: %s: %s\n", file, warning)
+	}
+	errEncountered := false
+	for _, err := range errors {
+		fmt.Fprintf(os.Stderr, "%s: %s\n", file, err.Error())
+		errEncountered = true
+	}
+	if metrics, err := AlertMetrics(); err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err.Error())
+		errEncountered = true
+	} else {
+		for i, alert := range alerts {
+			if err := validateAlert(alert, metrics); err != nil {
+				fmt.Fprintf(os.Stderr, "%s: Alert %d error: %s\n", file, i, err.Error())
+				errEncountered = true
+			}
+		}
+	}
+	if errEncountered {
+		exit(1)
+	}
+
+	href, name, err := findAlertTarget(target)
+	if err != nil {
+		fatalError("%s", err.Error())
+	}
+	fmt.Printf("Synchronizing Alerts of '%s' with '%s'\n", name, file)
+	if err := syncAlerts(href, alerts); err != nil {
+		fatalError("  Synchronize alerts failed: %s", err.Error())
+	}
+	fmt.Printf("Finished synchronizing %d Alerts of '%s'\n", len(alerts), name)
+}
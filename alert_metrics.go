@@ -0,0 +1,149 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/rightscale/rsc/cm15"
+)
+
+// AlertMetric describes a metric alerts may be set on and the value types it has. Metric is a glob pattern matched
+// against the <Metric> part of an alert (i.e. "cpu-*/cpu-idle" matches "cpu-0/cpu-idle") and value types may also be
+// glob patterns.
+type AlertMetric struct {
+	Metric     string   `mapstructure:"metric"`
+	ValueTypes []string `mapstructure:"value_types"`
+}
+
+// BuiltinAlertMetrics are the metrics collected by RightLink's built-in monitoring and the collectd plugins it
+// commonly runs. Teams with custom collectd plugins can add to these with alert_metrics in the configuration.
+var BuiltinAlertMetrics = []*AlertMetric{
+	{Metric: "cpu-*/cpu-idle", ValueTypes: []string{"value"}},
+	{Metric: "cpu-*/cpu-interrupt", ValueTypes: []string{"value"}},
+	{Metric: "cpu-*/cpu-nice", ValueTypes: []string{"value"}},
+	{Metric: "cpu-*/cpu-softirq", ValueTypes: []string{"value"}},
+	{Metric: "cpu-*/cpu-steal", ValueTypes: []string{"value"}},
+	{Metric: "cpu-*/cpu-system", ValueTypes: []string{"value"}},
+	{Metric: "cpu-*/cpu-user", ValueTypes: []string{"value"}},
+	{Metric: "cpu-*/cpu-wait", ValueTypes: []string{"value"}},
+	{Metric: "df/df-*", ValueTypes: []string{"free", "used"}},
+	{Metric: "df-*/df_complex-free", ValueTypes: []string{"value"}},
+	{Metric: "df-*/df_complex-reserved", ValueTypes: []string{"value"}},
+	{Metric: "df-*/df_complex-used", ValueTypes: []string{"value"}},
+	{Metric: "df-*/percent_bytes-free", ValueTypes: []string{"value"}},
+	{Metric: "df-*/percent_bytes-used", ValueTypes: []string{"value"}},
+	{Metric: "disk-*/disk_merged", ValueTypes: []string{"read", "write"}},
+	{Metric: "disk-*/disk_octets", ValueTypes: []string{"read", "write"}},
+	{Metric: "disk-*/disk_ops", ValueTypes: []string{"read", "write"}},
+	{Metric: "disk-*/disk_time", ValueTypes: []string{"read", "write"}},
+	{Metric: "interface-*/if_errors", ValueTypes: []string{"rx", "tx"}},
+	{Metric: "interface-*/if_octets", ValueTypes: []string{"rx", "tx"}},
+	{Metric: "interface-*/if_packets", ValueTypes: []string{"rx", "tx"}},
+	{Metric: "load/load", ValueTypes: []string{"shortterm", "midterm", "longterm"}},
+	{Metric: "memory/memory-buffered", ValueTypes: []string{"value"}},
+	{Metric: "memory/memory-cached", ValueTypes: []string{"value"}},
+	{Metric: "memory/memory-free", ValueTypes: []string{"value"}},
+	{Metric: "memory/memory-used", ValueTypes: []string{"value"}},
+	{Metric: "processes/ps_state-*", ValueTypes: []string{"value"}},
+	{Metric: "processes-*/ps_count", ValueTypes: []string{"processes", "threads"}},
+	{Metric: "processes-*/ps_cputime", ValueTypes: []string{"user", "syst"}},
+	{Metric: "processes-*/ps_rss", ValueTypes: []string{"value"}},
+	{Metric: "swap/swap-cached", ValueTypes: []string{"value"}},
+	{Metric: "swap/swap-free", ValueTypes: []string{"value"}},
+	{Metric: "swap/swap-used", ValueTypes: []string{"value"}},
+	{Metric: "uptime/uptime", ValueTypes: []string{"value"}},
+	{Metric: "users/users", ValueTypes: []string{"users", "value"}},
+	{Metric: "apache/apache_bytes", ValueTypes: []string{"count"}},
+	{Metric: "apache/apache_connections", ValueTypes: []string{"count"}},
+	{Metric: "apache/apache_requests", ValueTypes: []string{"count"}},
+	{Metric: "apache/apache_scoreboard-*", ValueTypes: []string{"count"}},
+	{Metric: "haproxy-*/haproxy_sessions", ValueTypes: []string{"current_session", "cumulative_requests"}},
+	{Metric: "haproxy-*/haproxy_status", ValueTypes: []string{"status"}},
+	{Metric: "nginx/nginx_connections-*", ValueTypes: []string{"value"}},
+	{Metric: "nginx/nginx_requests", ValueTypes: []string{"value"}},
+	{Metric: "RS/server", ValueTypes: []string{"state"}},
+	{Metric: "RS/server-failure", ValueTypes: []string{"state"}},
+}
+
+// alertServerStates are the only valid thresholds for RS/* metrics.
+var alertServerStates = []string{"pending", "booting", "operational", "stranded", "decommission", "shutting-down", "terminated"}
+
+// AlertMetrics returns the built-in alert metrics along with any added by alert_metrics in the configuration. The
+// added ones are checked so a malformed pattern is reported instead of silently matching nothing.
+func AlertMetrics() ([]*AlertMetric, error) {
+	var configMetrics []*AlertMetric
+	if err := Config.UnmarshalKey("alert_metrics", &configMetrics); err != nil {
+		return nil, fmt.Errorf("alert_metrics in the configuration is invalid: %s", err.Error())
+	}
+	for _, m := range configMetrics {
+		if err := m.validate(); err != nil {
+			return nil, fmt.Errorf("alert_metrics in the configuration is invalid: %s", err.Error())
+		}
+	}
+	return append(append([]*AlertMetric{}, BuiltinAlertMetrics...), configMetrics...), nil
+}
+
+// validate checks that the metric and value type patterns of an AlertMetric are valid glob patterns.
+func (m *AlertMetric) validate() error {
+	if m.Metric == "" {
+		return fmt.Errorf("metric is missing")
+	}
+	if _, err := path.Match(m.Metric, ""); err != nil {
+		return fmt.Errorf("metric %s is not a valid pattern: %s", m.Metric, err.Error())
+	}
+	if len(m.ValueTypes) == 0 {
+		return fmt.Errorf("metric %s has no value_types", m.Metric)
+	}
+	for _, vt := range m.ValueTypes {
+		if _, err := path.Match(vt, ""); err != nil {
+			return fmt.Errorf("value type %s of metric %s is not a valid pattern: %s", vt, m.Metric, err.Error())
+		}
+	}
+	return nil
+}
+
+// ValidateAlertMetric checks that the metric and value type of an alert spec are in a catalog of metrics and that the
+// threshold makes sense for the metric: a server state for RS/* metrics or else a number or NaN.
+func ValidateAlertMetric(alertSpec *cm15.AlertSpec, metrics []*AlertMetric) error {
+	metric := alertSpec.File + "." + alertSpec.Variable
+	var valueTypes []string
+	found := false
+	for _, m := range metrics {
+		if matched, _ := path.Match(m.Metric, alertSpec.File); !matched {
+			continue
+		}
+		found = true
+		for _, vt := range m.ValueTypes {
+			if matched, _ := path.Match(vt, alertSpec.Variable); matched {
+				return validateAlertThreshold(alertSpec)
+			}
+			valueTypes = append(valueTypes, vt)
+		}
+	}
+	if !found {
+		return fmt.Errorf("Alert metric %s is not a known metric, add it to alert_metrics in the configuration if it comes from a custom plugin", metric)
+	}
+	return fmt.Errorf("Alert metric %s has an unknown value type %s, must be one of: %s",
+		metric, alertSpec.Variable, strings.Join(valueTypes, ", "))
+}
+
+func validateAlertThreshold(alertSpec *cm15.AlertSpec) error {
+	if strings.HasPrefix(alertSpec.File, "RS/") {
+		for _, state := range alertServerStates {
+			if alertSpec.Threshold == state {
+				return nil
+			}
+		}
+		return fmt.Errorf("Alert <Threshold> for %s must be one of the following server states: %s",
+			alertSpec.File, strings.Join(alertServerStates, ", "))
+	}
+	if alertSpec.Threshold == "NaN" {
+		return nil
+	}
+	if _, err := strconv.ParseFloat(alertSpec.Threshold, 64); err != nil {
+		return fmt.Errorf("Alert <Threshold> for %s must be a number or NaN", alertSpec.File)
+	}
+	return nil
+}
@@ -0,0 +1,350 @@
+package main_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	. "github.com/rightscale/right_st"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+	"github.com/rightscale/rsc/cm15"
+	"gopkg.in/yaml.v2"
+)
+
+var _ = Describe("Alert", func() {
+	DescribeTable("Spec from Clause",
+		func(clause string, expected cm15.AlertSpec) {
+			alert := Alert{Name: "Test", Clause: clause}
+			spec, err := alert.Spec()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(*spec).To(Equal(expected))
+		},
+		Entry("escalation", "If cpu-0/cpu-idle.value < 10 for 5 minutes Then escalate warning",
+			cm15.AlertSpec{File: "cpu-0/cpu-idle", Variable: "value", Condition: "<", Threshold: "10", Duration: 5, EscalationName: "warning"}),
+		Entry("vote", "If cpu-0/cpu-idle.value > 50 for 3 minutes Then shrink my_app_name",
+			cm15.AlertSpec{File: "cpu-0/cpu-idle", Variable: "value", Condition: ">", Threshold: "50", Duration: 3, VoteType: "shrink", VoteTag: "my_app_name"}),
+		Entry("extra whitespace", "  If  cpu-0/cpu-idle.value\t<   10 for 5 minutes,  Then escalate warning ",
+			cm15.AlertSpec{File: "cpu-0/cpu-idle", Variable: "value", Condition: "<", Threshold: "10", Duration: 5, EscalationName: "warning"}),
+		Entry("quoted threshold", "If cpu-0/cpu-idle.value > '50' for 3 minutes Then shrink my_app_name",
+			cm15.AlertSpec{File: "cpu-0/cpu-idle", Variable: "value", Condition: ">", Threshold: "50", Duration: 3, VoteType: "shrink", VoteTag: "my_app_name"}),
+		Entry("quoted escalation with spaces", `If RS/server.state == "decommission" for 1 minutes Then escalate "Critical  Page"`,
+			cm15.AlertSpec{File: "RS/server", Variable: "state", Condition: "==", Threshold: "decommission", Duration: 1, EscalationName: "Critical  Page"}),
+		Entry("unquoted escalation with spaces", "If RS/server.state == decommission for 1 minutes Then escalate Critical Page",
+			cm15.AlertSpec{File: "RS/server", Variable: "state", Condition: "==", Threshold: "decommission", Duration: 1, EscalationName: "Critical Page"}),
+		Entry("metric with periods", "If GenericJMX-logs-METRICSAPPENDER.error/gauge-OneMinuteRate.value > 0 for 1 minutes Then escalate warning",
+			cm15.AlertSpec{File: "GenericJMX-logs-METRICSAPPENDER.error/gauge-OneMinuteRate", Variable: "value", Condition: ">", Threshold: "0", Duration: 1, EscalationName: "warning"}),
+	)
+
+	DescribeTable("Spec from invalid Clause",
+		func(clause, message string) {
+			alert := Alert{Name: "Test", Clause: clause}
+			_, err := alert.Spec()
+			Expect(err).To(MatchError(ContainSubstring(message)))
+		},
+		Entry("too short", "If cpu-0/cpu-idle.value < 10 for 5 minutes Then escalate", "not long enough"),
+		Entry("unterminated quote", `If cpu-0/cpu-idle.value < 10 for 5 minutes Then escalate "warning`, "unterminated \" quote"),
+		Entry("bad operator", "If cpu-0/cpu-idle.value =< 10 for 5 minutes Then escalate warning", "comparison operators"),
+		Entry("bad duration", "If cpu-0/cpu-idle.value < 10 for five minutes Then escalate warning", "positive integer"),
+		Entry("bad action", "If cpu-0/cpu-idle.value < 10 for 5 minutes Then page warning", "escalate, grow, or shrink"),
+	)
+
+	Describe("Structured", func() {
+		It("parses from YAML", func() {
+			var alerts Alerts
+			err := yaml.UnmarshalStrict([]byte(`Alerts:
+- Name: Low memory warning
+  Metric: memory/memory-free
+  Value Type: value
+  Condition: <
+  Threshold: 100000000
+  Duration: 5
+  Escalation: warning page
+`), &alerts)
+			Expect(err).NotTo(HaveOccurred())
+			spec, err := alerts.Alerts[0].Spec()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(*spec).To(Equal(cm15.AlertSpec{
+				File: "memory/memory-free", Variable: "value", Condition: "<", Threshold: "100000000", Duration: 5,
+				EscalationName: "warning page",
+			}))
+		})
+
+		It("parses votes", func() {
+			alert := Alert{Name: "Scale", Metric: "cpu-0/cpu-idle", ValueType: "value", Condition: ">", Threshold: "50",
+				Duration: 3, VoteType: "Shrink", VoteTag: "my app"}
+			spec, err := alert.Spec()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(*spec).To(Equal(cm15.AlertSpec{
+				File: "cpu-0/cpu-idle", Variable: "value", Condition: ">", Threshold: "50", Duration: 3,
+				VoteType: "shrink", VoteTag: "my app",
+			}))
+		})
+
+		It("rejects both a Clause and structured fields", func() {
+			alert := Alert{Name: "Test", Clause: "If cpu-0/cpu-idle.value < 10 for 5 minutes Then escalate warning", Metric: "cpu-0/cpu-idle"}
+			_, err := alert.Spec()
+			Expect(err).To(MatchError(ContainSubstring("not both")))
+		})
+
+		It("rejects neither a Clause nor structured fields", func() {
+			alert := Alert{Name: "Test"}
+			_, err := alert.Spec()
+			Expect(err).To(HaveOccurred())
+		})
+
+		It("rejects both an Escalation and a vote", func() {
+			alert := Alert{Name: "Test", Metric: "cpu-0/cpu-idle", ValueType: "value", Condition: ">", Threshold: "50",
+				Duration: 3, Escalation: "warning", VoteType: "grow", VoteTag: "app"}
+			_, err := alert.Spec()
+			Expect(err).To(MatchError(ContainSubstring("not both")))
+		})
+	})
+
+	DescribeTable("Round trip through Clause",
+		func(spec cm15.AlertSpec) {
+			spec.Name = "Test"
+			for _, format := range []string{ClauseAlertFormat, StructuredAlertFormat} {
+				alert := AlertFromSpec(&spec, format)
+				if format == ClauseAlertFormat {
+					Expect(alert.Clause).NotTo(BeEmpty())
+				} else {
+					Expect(alert.Clause).To(BeEmpty())
+				}
+				parsed, err := alert.Spec()
+				Expect(err).NotTo(HaveOccurred())
+				parsed.Name = spec.Name
+				Expect(*parsed).To(Equal(spec))
+			}
+		},
+		Entry("simple", cm15.AlertSpec{File: "cpu-0/cpu-idle", Variable: "value", Condition: "<", Threshold: "10", Duration: 5, EscalationName: "warning"}),
+		Entry("spaces", cm15.AlertSpec{File: "cpu-0/cpu-idle", Variable: "value", Condition: "<", Threshold: "10", Duration: 5, EscalationName: "  critical  page "}),
+		Entry("single quote", cm15.AlertSpec{File: "cpu-0/cpu-idle", Variable: "value", Condition: "<", Threshold: "10", Duration: 5, VoteType: "grow", VoteTag: "bob's app"}),
+		Entry("both quotes", cm15.AlertSpec{File: "cpu-0/cpu-idle", Variable: "value", Condition: "<", Threshold: "10", Duration: 5, EscalationName: `say "it's \ down"`}),
+	)
+
+	It("prints simple clauses unquoted", func() {
+		alert := AlertFromSpec(&cm15.AlertSpec{Name: "Test", File: "cpu-0/cpu-idle", Variable: "value", Condition: "<",
+			Threshold: "10", Duration: 5, EscalationName: "warning"}, ClauseAlertFormat)
+		Expect(alert.Clause).To(Equal("If cpu-0/cpu-idle.value < 10 for 5 minutes Then escalate warning"))
+		Expect(strings.ContainsAny(alert.Clause, `'"`)).To(BeFalse())
+	})
+
+	Describe("Metric catalog", func() {
+		metrics := append(append([]*AlertMetric{}, BuiltinAlertMetrics...),
+			&AlertMetric{Metric: "GenericJMX-*/gauge-*", ValueTypes: []string{"value"}})
+
+		DescribeTable("valid metrics",
+			func(clause string) {
+				spec, err := (&Alert{Name: "Test", Clause: clause}).Spec()
+				Expect(err).NotTo(HaveOccurred())
+				Expect(ValidateAlertMetric(spec, metrics)).To(Succeed())
+			},
+			Entry("cpu", "If cpu-3/cpu-idle.value < 10 for 5 minutes Then escalate warning"),
+			Entry("NaN", "If memory/memory-free.value == NaN for 5 minutes Then escalate warning"),
+			Entry("decimal", "If load/load.shortterm > 0.5 for 5 minutes Then escalate warning"),
+			Entry("server state", "If RS/server.state == decommission for 1 minutes Then escalate warning"),
+			Entry("custom plugin", "If GenericJMX-logs-METRICSAPPENDER.error/gauge-OneMinuteRate.value > 0 for 1 minutes Then escalate warning"),
+		)
+
+		DescribeTable("invalid metrics",
+			func(clause, message string) {
+				spec, err := (&Alert{Name: "Test", Clause: clause}).Spec()
+				Expect(err).NotTo(HaveOccurred())
+				Expect(ValidateAlertMetric(spec, metrics)).To(MatchError(ContainSubstring(message)))
+			},
+			Entry("missing plugin separator", "If cpu0/cpu-idle.value < 10 for 5 minutes Then escalate warning",
+				"cpu0/cpu-idle.value is not a known metric"),
+			Entry("misspelled metric", "If cpu-0/cpu-idel.value < 10 for 5 minutes Then escalate warning",
+				"cpu-0/cpu-idel.value is not a known metric"),
+			Entry("wrong value type", "If load/load.value > 2 for 5 minutes Then escalate warning",
+				"unknown value type value, must be one of: shortterm, midterm, longterm"),
+			Entry("non-numeric threshold", "If cpu-0/cpu-idle.value < low for 5 minutes Then escalate warning",
+				"must be a number or NaN"),
+			Entry("numeric server state", "If RS/server.state == 1 for 5 minutes Then escalate warning",
+				"must be one of the following server states"),
+		)
+
+		Context("extended by the configuration", func() {
+			var tempDir string
+
+			BeforeEach(func() {
+				var err error
+				tempDir, err = ioutil.TempDir("", "alert_metrics")
+				Expect(err).NotTo(HaveOccurred())
+			})
+
+			AfterEach(func() {
+				os.RemoveAll(tempDir)
+			})
+
+			readConfig := func(alertMetrics string) {
+				configFile := filepath.Join(tempDir, ".right_st.yml")
+				Expect(ioutil.WriteFile(configFile, []byte(`---
+login:
+  default_account: production
+  accounts:
+    production:
+      host: us-3.rightscale.com
+      id: 12345
+      refresh_token: abcdef1234567890abcdef1234567890abcdef12
+alert_metrics:
+`+alertMetrics), 0600)).To(Succeed())
+				Expect(ReadConfig(configFile, "")).To(Succeed())
+			}
+
+			It("adds the metrics from alert_metrics", func() {
+				readConfig(`- metric: GenericJMX-*/gauge-*
+  value_types: [value]
+`)
+				configMetrics, err := AlertMetrics()
+				Expect(err).NotTo(HaveOccurred())
+				Expect(configMetrics).To(HaveLen(len(BuiltinAlertMetrics) + 1))
+				spec, err := (&Alert{Name: "Test", Clause: "If GenericJMX-logs/gauge-OneMinuteRate.value > 0 for 1 minutes Then escalate warning"}).Spec()
+				Expect(err).NotTo(HaveOccurred())
+				Expect(ValidateAlertMetric(spec, configMetrics)).To(Succeed())
+			})
+
+			It("reports malformed patterns", func() {
+				readConfig(`- metric: GenericJMX-[/gauge-*
+  value_types: [value]
+`)
+				_, err := AlertMetrics()
+				Expect(err).To(MatchError(ContainSubstring("metric GenericJMX-[/gauge-* is not a valid pattern")))
+			})
+
+			It("reports malformed value type patterns", func() {
+				readConfig(`- metric: GenericJMX-*/gauge-*
+  value_types: ["val\\"]
+`)
+				_, err := AlertMetrics()
+				Expect(err).To(MatchError(ContainSubstring("is not a valid pattern")))
+			})
+		})
+	})
+
+	Describe("LoadAlerts", func() {
+		var tempDir string
+
+		BeforeEach(func() {
+			var err error
+			tempDir, err = ioutil.TempDir("", "alerts")
+			if err != nil {
+				panic(err)
+			}
+			Expect(os.Mkdir(filepath.Join(tempDir, "shared"), 0755)).To(Succeed())
+			Expect(ioutil.WriteFile(filepath.Join(tempDir, "shared", "disk.yml"), []byte(`Alerts:
+- Name: Low disk space
+  Clause: If df-root/df_complex-free.value < 1000000000 for 5 minutes Then escalate disk warning
+`), 0644)).To(Succeed())
+		})
+
+		AfterEach(func() {
+			os.RemoveAll(tempDir)
+		})
+
+		It("expands referenced Alert files relative to the file", func() {
+			file := filepath.Join(tempDir, "deployment_alerts.yml")
+			Expect(ioutil.WriteFile(file, []byte(`Alerts:
+- Name: CPU Scale Down
+  Clause: If cpu-0/cpu-idle.value > 80 for 10 minutes Then shrink my_app_name
+- shared/disk.yml
+`), 0644)).To(Succeed())
+			alerts, err := LoadAlerts(file)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(alerts).To(HaveLen(2))
+			Expect(alerts[0].Name).To(Equal("CPU Scale Down"))
+			Expect(alerts[1].Name).To(Equal("Low disk space"))
+		})
+
+		It("returns an error for an invalid file", func() {
+			file := filepath.Join(tempDir, "deployment_alerts.yml")
+			Expect(ioutil.WriteFile(file, []byte("Alert:\n- Name: Typo\n"), 0644)).To(Succeed())
+			_, err := LoadAlerts(file)
+			Expect(err).To(MatchError(ContainSubstring("deployment_alerts.yml")))
+		})
+	})
+
+	Describe("ResolveAlerts", func() {
+		var tempDir string
+
+		BeforeEach(func() {
+			var err error
+			tempDir, err = ioutil.TempDir("", "alerts")
+			if err != nil {
+				panic(err)
+			}
+			Expect(ioutil.WriteFile(filepath.Join(tempDir, "shared.yml"), []byte(`Alerts:
+- Name: High CPU
+  Clause: If cpu-0/cpu-idle.value < 10 for 5 minutes Then escalate warning
+- Name: Low memory
+  Clause: If memory/memory-free.value < 100000000 for 5 minutes Then escalate warning
+`), 0644)).To(Succeed())
+		})
+
+		AfterEach(func() {
+			os.RemoveAll(tempDir)
+		})
+
+		expand := func(alerts ...*Alert) []*Alert {
+			expanded, err := ExpandAlerts(tempDir, alerts)
+			Expect(err).NotTo(HaveOccurred())
+			return expanded
+		}
+
+		It("reports names which collide after normalization", func() {
+			alerts := expand(
+				&Alert{Name: " high cpu", Clause: "If cpu-0/cpu-idle.value < 5 for 5 minutes Then escalate critical"},
+				&Alert{File: "shared.yml"},
+			)
+			_, _, errors := ResolveAlerts(alerts)
+			Expect(errors).To(HaveLen(1))
+			Expect(errors[0]).To(MatchError(ContainSubstring("Alert name ' high cpu' is used by more than one Alert (inline, shared.yml)")))
+		})
+
+		It("replaces an Alert from a shared file with an Override", func() {
+			override := &Alert{Name: "High CPU", Override: true, Clause: "If cpu-0/cpu-idle.value < 5 for 5 minutes Then escalate critical"}
+			alerts := expand(&Alert{File: "shared.yml"}, override)
+			resolved, warnings, errors := ResolveAlerts(alerts)
+			Expect(errors).To(BeEmpty())
+			Expect(warnings).To(BeEmpty())
+			Expect(resolved).To(HaveLen(2))
+			Expect(resolved[0].Name).To(Equal("Low memory"))
+			Expect(resolved[1]).To(Equal(override))
+		})
+
+		It("reports more than one Override for the same name", func() {
+			alerts := []*Alert{
+				{Name: "High CPU", Override: true, Clause: "If cpu-0/cpu-idle.value < 5 for 5 minutes Then escalate critical"},
+				{Name: "high cpu", Override: true, Clause: "If cpu-0/cpu-idle.value < 1 for 5 minutes Then escalate critical"},
+			}
+			_, _, errors := ResolveAlerts(alerts)
+			Expect(errors).To(HaveLen(1))
+			Expect(errors[0]).To(MatchError(ContainSubstring("is marked Override by more than one Alert")))
+		})
+
+		It("warns about an Override which does not replace anything", func() {
+			alerts := []*Alert{{Name: "High CPU", Override: true, Clause: "If cpu-0/cpu-idle.value < 5 for 5 minutes Then escalate critical"}}
+			resolved, warnings, errors := ResolveAlerts(alerts)
+			Expect(errors).To(BeEmpty())
+			Expect(resolved).To(Equal(alerts))
+			Expect(warnings).To(ConsistOf(ContainSubstring("no other Alert with that name to replace")))
+		})
+
+		It("warns about identical conditions under different names", func() {
+			alerts := expand(
+				&Alert{File: "shared.yml"},
+				&Alert{Name: "CPU busy", Metric: "cpu-0/cpu-idle", ValueType: "value", Condition: "<", Threshold: "10",
+					Duration: 5, Escalation: "warning"},
+			)
+			resolved, warnings, errors := ResolveAlerts(alerts)
+			Expect(errors).To(BeEmpty())
+			Expect(resolved).To(HaveLen(3))
+			Expect(warnings).To(ConsistOf("Alerts 'High CPU' (shared.yml) and 'CPU busy' (inline) have identical conditions: " +
+				"If cpu-0/cpu-idle.value < 10 for 5 minutes Then escalate warning"))
+		})
+	})
+})
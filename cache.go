@@ -0,0 +1,445 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/rightscale/rsc/rsapi"
+)
+
+// defaultCacheTTL is how long catalog entries are used before they are fetched again from the API if cache.ttl is not
+// set in the configuration.
+const defaultCacheTTL = 24 * time.Hour
+
+// Catalog is an on-disk cache of the clouds, instance types and images of a single account. Validating, uploading and
+// downloading MultiCloudImage settings only need to translate between names/resource_uids and HREFs of these, which
+// rarely change, so caching them saves many API calls. Entries older than the TTL are refetched from the API unless the
+// catalog is Offline, in which case whatever is cached is used and nothing is fetched.
+type Catalog struct {
+	Host            string                              `json:"host"`
+	Account         int                                 `json:"account"`
+	CloudsFetchedAt time.Time                           `json:"clouds_fetched_at"`
+	Clouds          []*CatalogCloud                     `json:"clouds"`
+	InstanceTypes   map[string]*CatalogInstanceTypes    `json:"instance_types"`
+	Images          map[string]map[string]*CatalogImage `json:"images"`
+	RightScripts    map[string]*CatalogRightScript      `json:"right_scripts,omitempty"`
+
+	// Offline disables all API calls, only cached entries are used regardless of their age.
+	Offline bool `json:"-"`
+	// Refresh ignores entries fetched before the catalog was loaded so everything is fetched again.
+	Refresh bool `json:"-"`
+
+	path     string
+	ttl      time.Duration
+	loadedAt time.Time
+	lock     sync.Mutex
+}
+
+type CatalogCloud struct {
+	Href        string `json:"href"`
+	Name        string `json:"name"`
+	DisplayName string `json:"display_name,omitempty"`
+}
+
+type CatalogInstanceTypes struct {
+	FetchedAt     time.Time              `json:"fetched_at"`
+	InstanceTypes []*CatalogInstanceType `json:"instance_types"`
+}
+
+type CatalogInstanceType struct {
+	Href        string `json:"href"`
+	Name        string `json:"name"`
+	ResourceUid string `json:"resource_uid"`
+}
+
+type CatalogImage struct {
+	Href        string    `json:"href"`
+	ResourceUid string    `json:"resource_uid"`
+	FetchedAt   time.Time `json:"fetched_at"`
+}
+
+// CatalogRightScript records the fingerprint of a RightScript right after it was pushed or found unchanged so pushing
+// the same contents again does not need to look at the RightScript in RightScale at all.
+type CatalogRightScript struct {
+	Href        string    `json:"href"`
+	Fingerprint string    `json:"fingerprint"`
+	PushedAt    time.Time `json:"pushed_at"`
+}
+
+var (
+	catalog     *Catalog
+	catalogOnce sync.Once
+)
+
+// getCatalog returns the Catalog for the current account, loading it from the cache directory the first time it is
+// used. Errors loading the cache are not fatal, an empty catalog is used instead and the cache is rewritten.
+func getCatalog() *Catalog {
+	catalogOnce.Do(func() {
+		var err error
+		catalog, err = LoadCatalog(catalogPath(Config.Account), cacheTTL())
+		if err != nil {
+			fmt.Printf("WARNING: Ignoring unreadable catalog cache: %s\n", err.Error())
+			catalog = NewCatalog(catalogPath(Config.Account), cacheTTL())
+		}
+		catalog.Host = Config.Account.Host
+		catalog.Account = Config.Account.Id
+		catalog.Offline = *stValidateOffline
+		catalog.Refresh = *refreshCache
+	})
+	return catalog
+}
+
+// resetCatalog forgets the loaded Catalog so the next getCatalog loads the one for the current account.
+func resetCatalog() {
+	catalog = nil
+	catalogOnce = sync.Once{}
+}
+
+// cacheDir returns the directory catalogs are cached in. It may be set with cache.dir in the configuration and
+// defaults to a right_st directory in the user's cache directory.
+func cacheDir() string {
+	if Config.IsSet("cache.dir") {
+		return Config.GetString("cache.dir")
+	}
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		dir = os.TempDir()
+	}
+	return filepath.Join(dir, "right_st")
+}
+
+func cacheTTL() time.Duration {
+	if Config.IsSet("cache.ttl") {
+		return Config.GetDuration("cache.ttl")
+	}
+	return defaultCacheTTL
+}
+
+// catalogPath returns the path of the cached catalog for an account. Accounts are identified by both host and ID since
+// the same ID could in theory exist on different shards.
+func catalogPath(account *Account) string {
+	return filepath.Join(cacheDir(), fmt.Sprintf("%s_%d.json", cleanFileName(account.Host), account.Id))
+}
+
+func NewCatalog(path string, ttl time.Duration) *Catalog {
+	return &Catalog{
+		InstanceTypes: make(map[string]*CatalogInstanceTypes),
+		Images:        make(map[string]map[string]*CatalogImage),
+		RightScripts:  make(map[string]*CatalogRightScript),
+		path:          path,
+		ttl:           ttl,
+		loadedAt:      time.Now(),
+	}
+}
+
+// LoadCatalog reads a cached catalog from a file. A nonexistent file results in an empty catalog.
+func LoadCatalog(path string, ttl time.Duration) (*Catalog, error) {
+	c := NewCatalog(path, ttl)
+	bytes, err := ioutil.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return c, nil
+		}
+		return nil, err
+	}
+	if err := json.Unmarshal(bytes, c); err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err.Error())
+	}
+	if c.InstanceTypes == nil {
+		c.InstanceTypes = make(map[string]*CatalogInstanceTypes)
+	}
+	if c.Images == nil {
+		c.Images = make(map[string]map[string]*CatalogImage)
+	}
+	if c.RightScripts == nil {
+		c.RightScripts = make(map[string]*CatalogRightScript)
+	}
+	return c, nil
+}
+
+// Save writes the catalog to its cache file which only the current user can read or write.
+func (c *Catalog) Save() error {
+	bytes, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(c.path), 0700); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(c.path, bytes, 0600)
+}
+
+// save writes the catalog but only warns on failure since the cache is just an optimization.
+func (c *Catalog) save() {
+	if err := c.Save(); err != nil {
+		fmt.Printf("WARNING: Could not write catalog cache %s: %s\n", c.path, err.Error())
+	}
+}
+
+// fresh returns whether an entry fetched at a given time may be used without fetching it again.
+func (c *Catalog) fresh(fetchedAt time.Time) bool {
+	if c.Offline {
+		return true
+	}
+	if c.Refresh {
+		return fetchedAt.After(c.loadedAt)
+	}
+	return time.Since(fetchedAt) < c.ttl
+}
+
+func (c *Catalog) offlineError(format string, v ...interface{}) error {
+	return fmt.Errorf("%s is not in the catalog cache %s, run without --offline to populate it",
+		fmt.Sprintf(format, v...), c.path)
+}
+
+func (c *Catalog) clouds() ([]*CatalogCloud, error) {
+	if c.Clouds != nil && c.fresh(c.CloudsFetchedAt) {
+		return c.Clouds, nil
+	}
+	if c.Offline {
+		return nil, c.offlineError("The list of clouds")
+	}
+	client, _ := Config.Account.Client15()
+	clouds, err := client.CloudLocator("/api/clouds").Index(rsapi.APIParams{})
+	if err != nil {
+		return nil, fmt.Errorf("Could not execute API call to get clouds: %s", err.Error())
+	}
+	c.Clouds = make([]*CatalogCloud, len(clouds))
+	for i, cloud := range clouds {
+		c.Clouds[i] = &CatalogCloud{Href: getLink(cloud.Links, "self"), Name: cloud.Name, DisplayName: cloud.DisplayName}
+	}
+	c.CloudsFetchedAt = time.Now()
+	c.save()
+	return c.Clouds, nil
+}
+
+// Cloud finds a cloud by HREF, display name or name. It returns nil if there is no such cloud registered in the
+// account.
+func (c *Catalog) Cloud(cloud string) (*CatalogCloud, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	clouds, err := c.clouds()
+	if err != nil {
+		return nil, err
+	}
+	var found *CatalogCloud
+	for _, cc := range clouds {
+		if cc.Href == cloud || cc.DisplayName == cloud || cc.Name == cloud {
+			found = cc
+		}
+	}
+	return found, nil
+}
+
+func (c *Catalog) instanceTypes(cloudHref string) ([]*CatalogInstanceType, error) {
+	if its, ok := c.InstanceTypes[cloudHref]; ok && c.fresh(its.FetchedAt) {
+		return its.InstanceTypes, nil
+	}
+	if c.Offline {
+		return nil, c.offlineError("The list of instance types for cloud %s", cloudHref)
+	}
+	client, _ := Config.Account.Client15()
+	its, err := client.InstanceTypeLocator(cloudHref + "/instance_types").Index(rsapi.APIParams{})
+	if err != nil {
+		return nil, fmt.Errorf("Could not complete API call: %s", err.Error())
+	}
+	cached := &CatalogInstanceTypes{FetchedAt: time.Now(), InstanceTypes: make([]*CatalogInstanceType, len(its))}
+	for i, it := range its {
+		cached.InstanceTypes[i] = &CatalogInstanceType{Href: getLink(it.Links, "self"), Name: it.Name, ResourceUid: it.ResourceUid}
+	}
+	c.InstanceTypes[cloudHref] = cached
+	c.save()
+	return cached.InstanceTypes, nil
+}
+
+// InstanceType finds an instance type in a cloud by HREF, name or resource_uid. It returns nil if there is no such
+// instance type in the cloud.
+func (c *Catalog) InstanceType(cloudHref, instanceType string) (*CatalogInstanceType, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	its, err := c.instanceTypes(cloudHref)
+	if err != nil {
+		return nil, err
+	}
+	var found *CatalogInstanceType
+	for _, it := range its {
+		if it.Href == instanceType || it.Name == instanceType || it.ResourceUid == instanceType {
+			found = it
+		}
+	}
+	return found, nil
+}
+
+// Image finds an image in a cloud by resource_uid. It returns nil if there is no such image in the cloud. Images are
+// cached individually as they are looked up since clouds can have far too many images to list them all.
+func (c *Catalog) Image(cloudHref, resourceUid string) (*CatalogImage, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if image, ok := c.Images[cloudHref][resourceUid]; ok && c.fresh(image.FetchedAt) {
+		return image, nil
+	}
+	if c.Offline {
+		return nil, c.offlineError("Image with resource_uid %s for cloud %s", resourceUid, cloudHref)
+	}
+	client, _ := Config.Account.Client15()
+	apiParams := rsapi.APIParams{"filter": []string{"resource_uid==" + resourceUid}}
+	images, err := client.ImageLocator(cloudHref + "/images").Index(apiParams)
+	if err != nil {
+		return nil, err
+	}
+	if len(images) < 1 {
+		return nil, nil
+	}
+	return c.addImage(cloudHref, getLink(images[0].Links, "self"), images[0].ResourceUid), nil
+}
+
+// ImageByHref finds an image in a cloud by HREF, fetching it from the API if it has not been cached yet.
+func (c *Catalog) ImageByHref(cloudHref, href string) (*CatalogImage, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	for _, image := range c.Images[cloudHref] {
+		if image.Href == href && c.fresh(image.FetchedAt) {
+			return image, nil
+		}
+	}
+	if c.Offline {
+		return nil, c.offlineError("Image %s", href)
+	}
+	client, _ := Config.Account.Client15()
+	image, err := client.ImageLocator(href).Show(rsapi.APIParams{})
+	if err != nil {
+		return nil, err
+	}
+	return c.addImage(cloudHref, href, image.ResourceUid), nil
+}
+
+func (c *Catalog) addImage(cloudHref, href, resourceUid string) *CatalogImage {
+	if _, ok := c.Images[cloudHref]; !ok {
+		c.Images[cloudHref] = make(map[string]*CatalogImage)
+	}
+	image := &CatalogImage{Href: href, ResourceUid: resourceUid, FetchedAt: time.Now()}
+	c.Images[cloudHref][resourceUid] = image
+	c.save()
+	return image
+}
+
+// PushedRightScript returns the HREF of a RightScript if it was pushed with the same fingerprint recently enough to
+// assume it did not change since.
+func (c *Catalog) PushedRightScript(name string, fingerprint Fingerprint) (string, bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	pushed, ok := c.RightScripts[name]
+	if !ok || !c.fresh(pushed.PushedAt) || pushed.Fingerprint != fingerprint.String() {
+		return "", false
+	}
+	return pushed.Href, true
+}
+
+// RecordPushedRightScript records the fingerprint of a RightScript right after it was pushed.
+func (c *Catalog) RecordPushedRightScript(name, href string, fingerprint Fingerprint) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.RightScripts[name] = &CatalogRightScript{Href: href, Fingerprint: fingerprint.String(), PushedAt: time.Now()}
+	c.save()
+}
+
+// Show prints a summary of what is in the catalog.
+func (c *Catalog) Show(output io.Writer) {
+	fmt.Fprintf(output, "Cache file: %s\n", c.path)
+	fmt.Fprintf(output, "Account: %d (%s)\n", c.Account, c.Host)
+	fmt.Fprintf(output, "TTL: %s\n", c.ttl)
+	if c.Clouds == nil {
+		fmt.Fprintln(output, "Clouds: not cached")
+		c.showRightScripts(output)
+		return
+	}
+	fmt.Fprintf(output, "Clouds: %d fetched %s%s\n", len(c.Clouds), c.CloudsFetchedAt.Format(time.RFC3339),
+		c.staleMarker(c.CloudsFetchedAt))
+	clouds := make([]*CatalogCloud, len(c.Clouds))
+	copy(clouds, c.Clouds)
+	sort.Slice(clouds, func(i, j int) bool { return clouds[i].Name < clouds[j].Name })
+	for _, cloud := range clouds {
+		fmt.Fprintf(output, "  %s %s\n", cloud.Href, cloud.Name)
+		if its, ok := c.InstanceTypes[cloud.Href]; ok {
+			fmt.Fprintf(output, "    Instance Types: %d fetched %s%s\n", len(its.InstanceTypes),
+				its.FetchedAt.Format(time.RFC3339), c.staleMarker(its.FetchedAt))
+		}
+		if images := c.Images[cloud.Href]; len(images) > 0 {
+			uids := make([]string, 0, len(images))
+			for uid := range images {
+				uids = append(uids, uid)
+			}
+			sort.Strings(uids)
+			fmt.Fprintf(output, "    Images: (resource_uid, href)\n")
+			for _, uid := range uids {
+				fmt.Fprintf(output, "      %s %s%s\n", uid, images[uid].Href, c.staleMarker(images[uid].FetchedAt))
+			}
+		}
+	}
+	c.showRightScripts(output)
+}
+
+func (c *Catalog) showRightScripts(output io.Writer) {
+	if len(c.RightScripts) == 0 {
+		return
+	}
+	names := make([]string, 0, len(c.RightScripts))
+	for name := range c.RightScripts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	fmt.Fprintf(output, "Pushed RightScripts: (name, href)\n")
+	for _, name := range names {
+		pushed := c.RightScripts[name]
+		fmt.Fprintf(output, "  %s %s pushed %s%s\n", name, pushed.Href, pushed.PushedAt.Format(time.RFC3339),
+			c.staleMarker(pushed.PushedAt))
+	}
+}
+
+func (c *Catalog) staleMarker(fetchedAt time.Time) string {
+	if time.Since(fetchedAt) < c.ttl {
+		return ""
+	}
+	return " (stale)"
+}
+
+func cacheShow() {
+	c, err := LoadCatalog(catalogPath(Config.Account), cacheTTL())
+	if err != nil {
+		fatalError("%s", err.Error())
+	}
+	if c.Account == 0 {
+		c.Account = Config.Account.Id
+		c.Host = Config.Account.Host
+	}
+	c.Show(os.Stdout)
+}
+
+func cacheClear(all bool) {
+	path := catalogPath(Config.Account)
+	if all {
+		path = cacheDir()
+		fmt.Printf("Removing all catalog caches in %s\n", path)
+		if err := os.RemoveAll(path); err != nil {
+			fatalError("Could not remove catalog caches: %s", err.Error())
+		}
+		return
+	}
+	fmt.Printf("Removing catalog cache %s\n", path)
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		fatalError("Could not remove catalog cache: %s", err.Error())
+	}
+}
@@ -0,0 +1,184 @@
+package main_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	. "github.com/rightscale/right_st"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/gbytes"
+)
+
+var _ = Describe("Catalog", func() {
+	var (
+		tempDir     string
+		catalogFile string
+	)
+
+	BeforeEach(func() {
+		var err error
+		tempDir, err = ioutil.TempDir("", "catalog")
+		if err != nil {
+			panic(err)
+		}
+		catalogFile = filepath.Join(tempDir, "us-3_rightscale_com_12345.json")
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(tempDir)
+	})
+
+	Context("With a nonexistent cache file", func() {
+		It("Loads an empty catalog", func() {
+			catalog, err := LoadCatalog(catalogFile, time.Hour)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(catalog.Clouds).To(BeNil())
+			Expect(catalog.InstanceTypes).To(BeEmpty())
+			Expect(catalog.Images).To(BeEmpty())
+		})
+
+		It("Returns an error for offline lookups", func() {
+			catalog, err := LoadCatalog(catalogFile, time.Hour)
+			Expect(err).NotTo(HaveOccurred())
+			catalog.Offline = true
+			cloud, err := catalog.Cloud("EC2 us-east-1")
+			Expect(err).To(MatchError(ContainSubstring("The list of clouds is not in the catalog cache")))
+			Expect(cloud).To(BeNil())
+		})
+	})
+
+	Context("With a corrupt cache file", func() {
+		It("Returns an error", func() {
+			Expect(ioutil.WriteFile(catalogFile, []byte("{"), 0600)).To(Succeed())
+			_, err := LoadCatalog(catalogFile, time.Hour)
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	Context("With a saved catalog", func() {
+		var fetchedAt = time.Now().Add(-48 * time.Hour)
+
+		BeforeEach(func() {
+			catalog := NewCatalog(catalogFile, time.Hour)
+			catalog.Host = "us-3.rightscale.com"
+			catalog.Account = 12345
+			catalog.CloudsFetchedAt = fetchedAt
+			catalog.Clouds = []*CatalogCloud{
+				{Href: "/api/clouds/1", Name: "EC2 us-east-1", DisplayName: "AWS US-East"},
+				{Href: "/api/clouds/6", Name: "EC2 us-west-2", DisplayName: "AWS US-Oregon"},
+			}
+			catalog.InstanceTypes["/api/clouds/1"] = &CatalogInstanceTypes{
+				FetchedAt: fetchedAt,
+				InstanceTypes: []*CatalogInstanceType{
+					{Href: "/api/clouds/1/instance_types/ABC", Name: "Medium", ResourceUid: "m3.medium"},
+				},
+			}
+			catalog.Images["/api/clouds/1"] = map[string]*CatalogImage{
+				"ami-11111111": {Href: "/api/clouds/1/images/DEF", ResourceUid: "ami-11111111", FetchedAt: fetchedAt},
+			}
+			Expect(catalog.Save()).To(Succeed())
+		})
+
+		It("Writes the cache file only the current user can read", func() {
+			info, err := os.Stat(catalogFile)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(info.Mode().Perm()).To(Equal(os.FileMode(0600)))
+		})
+
+		It("Looks up stale entries when offline", func() {
+			catalog, err := LoadCatalog(catalogFile, time.Hour)
+			Expect(err).NotTo(HaveOccurred())
+			catalog.Offline = true
+
+			cloud, err := catalog.Cloud("AWS US-East")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(cloud.Href).To(Equal("/api/clouds/1"))
+
+			cloud, err = catalog.Cloud("EC2 eu-west-1")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(cloud).To(BeNil())
+
+			instanceType, err := catalog.InstanceType("/api/clouds/1", "m3.medium")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(instanceType.Href).To(Equal("/api/clouds/1/instance_types/ABC"))
+
+			_, err = catalog.InstanceType("/api/clouds/6", "m3.medium")
+			Expect(err).To(MatchError(ContainSubstring("instance types for cloud /api/clouds/6 is not in the catalog cache")))
+
+			image, err := catalog.Image("/api/clouds/1", "ami-11111111")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(image.Href).To(Equal("/api/clouds/1/images/DEF"))
+
+			image, err = catalog.ImageByHref("/api/clouds/1", "/api/clouds/1/images/DEF")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(image.ResourceUid).To(Equal("ami-11111111"))
+
+			_, err = catalog.Image("/api/clouds/1", "ami-22222222")
+			Expect(err).To(MatchError(ContainSubstring("Image with resource_uid ami-22222222 for cloud /api/clouds/1 is not in the catalog cache")))
+		})
+
+		It("Shows the catalog and marks stale entries", func() {
+			catalog, err := LoadCatalog(catalogFile, time.Hour)
+			Expect(err).NotTo(HaveOccurred())
+			buffer := gbytes.NewBuffer()
+			catalog.Show(buffer)
+			Expect(buffer).To(gbytes.Say(`Account: 12345 \(us-3.rightscale.com\)`))
+			Expect(buffer).To(gbytes.Say(`Clouds: 2 fetched .+ \(stale\)`))
+			Expect(buffer).To(gbytes.Say(`/api/clouds/1 EC2 us-east-1`))
+			Expect(buffer).To(gbytes.Say(`Instance Types: 1 fetched .+ \(stale\)`))
+			Expect(buffer).To(gbytes.Say(`ami-11111111 /api/clouds/1/images/DEF \(stale\)`))
+		})
+	})
+
+	Context("With pushed RightScripts", func() {
+		fingerprint := Fingerprint{"metadata": "aaa", "source": "bbb", "attachments": "ccc"}
+
+		BeforeEach(func() {
+			catalog := NewCatalog(catalogFile, time.Hour)
+			catalog.RecordPushedRightScript("Install App", "/api/right_scripts/ABC", fingerprint)
+		})
+
+		It("Finds a RightScript pushed with the same fingerprint", func() {
+			catalog, err := LoadCatalog(catalogFile, time.Hour)
+			Expect(err).NotTo(HaveOccurred())
+			href, ok := catalog.PushedRightScript("Install App", Fingerprint{"attachments": "ccc", "metadata": "aaa", "source": "bbb"})
+			Expect(ok).To(BeTrue())
+			Expect(href).To(Equal("/api/right_scripts/ABC"))
+		})
+
+		It("Does not find a RightScript which changed or was not pushed", func() {
+			catalog, err := LoadCatalog(catalogFile, time.Hour)
+			Expect(err).NotTo(HaveOccurred())
+			_, ok := catalog.PushedRightScript("Install App", Fingerprint{"metadata": "aaa", "source": "ddd", "attachments": "ccc"})
+			Expect(ok).To(BeFalse())
+			_, ok = catalog.PushedRightScript("Configure App", fingerprint)
+			Expect(ok).To(BeFalse())
+		})
+
+		It("Does not find a RightScript pushed before the TTL or before refreshing", func() {
+			catalog, err := LoadCatalog(catalogFile, time.Nanosecond)
+			Expect(err).NotTo(HaveOccurred())
+			_, ok := catalog.PushedRightScript("Install App", fingerprint)
+			Expect(ok).To(BeFalse())
+
+			catalog, err = LoadCatalog(catalogFile, time.Hour)
+			Expect(err).NotTo(HaveOccurred())
+			catalog.Refresh = true
+			_, ok = catalog.PushedRightScript("Install App", fingerprint)
+			Expect(ok).To(BeFalse())
+		})
+
+		It("Shows the pushed RightScripts", func() {
+			catalog, err := LoadCatalog(catalogFile, time.Hour)
+			Expect(err).NotTo(HaveOccurred())
+			buffer := gbytes.NewBuffer()
+			catalog.Show(buffer)
+			Expect(buffer).To(gbytes.Say(`Clouds: not cached`))
+			Expect(buffer).To(gbytes.Say(`Install App /api/right_scripts/ABC pushed `))
+		})
+	})
+})
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rightscale/rsc/cm15"
+	"github.com/rightscale/rsc/rsapi"
+)
+
+// ParseAge parses an age such as "14d", "36h" or "90m". Days are not supported by time.ParseDuration so they are
+// handled here.
+func ParseAge(age string) (time.Duration, error) {
+	if strings.HasSuffix(age, "d") {
+		days, err := strconv.Atoi(strings.TrimSuffix(age, "d"))
+		if err != nil || days < 0 {
+			return 0, fmt.Errorf("invalid age %s, must be a number of days such as 14d or a duration such as 36h", age)
+		}
+		return time.Duration(days) * 24 * time.Hour, nil
+	}
+	duration, err := time.ParseDuration(age)
+	if err != nil || duration < 0 {
+		return 0, fmt.Errorf("invalid age %s, must be a number of days such as 14d or a duration such as 36h", age)
+	}
+	return duration, nil
+}
+
+// NamePrefix returns the prefix a name was uploaded with if it is one of prefixes or matches one of the glob
+// patterns. Since prefixes may contain underscores themselves, the shortest matching prefix is used. This only works
+// for names from the default naming template, anything uploaded since right_st started tagging what it uploads is
+// found by its right_st:prefix tag instead.
+func NamePrefix(name string, prefixes, patterns []string) (string, bool) {
+	for i, c := range name {
+		if c != '_' {
+			continue
+		}
+		if prefix := name[:i]; matchPrefix(prefix, prefixes, patterns) {
+			return prefix, true
+		}
+	}
+	return "", false
+}
+
+// UploadPrefix returns the prefix something was uploaded with if it is one of prefixes or matches one of the glob
+// patterns, from its right_st:prefix tag or else from its name. An empty right_st:prefix tag means it was uploaded
+// without a prefix, and so does having right_st ownership tags without a right_st:prefix tag, so the name is only
+// used for objects uploaded before right_st tagged what it uploads.
+func UploadPrefix(name string, tags []string, prefixes, patterns []string) (string, bool) {
+	if prefix, ok := tagValue(tags, prefixTag); ok {
+		return prefix, prefix != "" && matchPrefix(prefix, prefixes, patterns)
+	}
+	if _, owned := OwnerFromTags(tags); owned {
+		return "", false
+	}
+	return NamePrefix(name, prefixes, patterns)
+}
+
+func matchPrefix(prefix string, prefixes, patterns []string) bool {
+	for _, p := range prefixes {
+		if prefix == p {
+			return true
+		}
+	}
+	for _, pattern := range patterns {
+		if matched, _ := path.Match(pattern, prefix); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// findPrefixed finds the HEAD ServerTemplates, MultiCloudImages and RightScripts created in the account which were
+// uploaded with one of the prefixes from the current repository, or from anywhere with unowned. Objects which are still
+// attached to a ServerTemplate which is not being cleaned up are left alone. With olderThan, only prefixes whose newest
+// RightScript was modified at least that long ago are cleaned up since the API does not say when ServerTemplates and
+// MultiCloudImages were modified.
+func findPrefixed(prefixes, patterns []string, olderThan time.Duration, unowned bool) ([]*accountObject, error) {
+	client, _ := Config.Account.Client15()
+
+	var candidates []*accountObject
+
+	sts, err := client.ServerTemplateLocator("/api/server_templates").Index(rsapi.APIParams{})
+	if err != nil {
+		return nil, fmt.Errorf("Could not list ServerTemplates: %s", err.Error())
+	}
+	for _, st := range sts {
+		if st.Revision == 0 {
+			candidates = append(candidates, &accountObject{resourceType: "server_templates", name: st.Name, href: getLink(st.Links, "self")})
+		}
+	}
+
+	mcis, err := client.MultiCloudImageLocator("/api/multi_cloud_images").Index(rsapi.APIParams{})
+	if err != nil {
+		return nil, fmt.Errorf("Could not list MultiCloudImages: %s", err.Error())
+	}
+	for _, mci := range mcis {
+		if mci.Revision == 0 {
+			candidates = append(candidates, &accountObject{resourceType: "multi_cloud_images", name: mci.Name, href: getLink(mci.Links, "self")})
+		}
+	}
+
+	rss, err := client.RightScriptLocator("/api/right_scripts").Index(rsapi.APIParams{})
+	if err != nil {
+		return nil, fmt.Errorf("Could not list RightScripts: %s", err.Error())
+	}
+	for _, rs := range rss {
+		if rs.Revision != 0 {
+			continue
+		}
+		// Only ever consider our own RightScripts
+		accountId, err := rightScriptAccountId(rs)
+		if err != nil {
+			return nil, err
+		}
+		if accountId == Config.Account.Id {
+			object := &accountObject{resourceType: "right_scripts", name: rs.Name, href: getLink(rs.Links, "self")}
+			if rs.UpdatedAt != nil {
+				object.updatedAt = rs.UpdatedAt.Time
+			}
+			candidates = append(candidates, object)
+		}
+	}
+
+	var hrefs []string
+	for _, object := range candidates {
+		hrefs = append(hrefs, object.href)
+	}
+	tags, err := getTagsByHrefs(hrefs)
+	if err != nil {
+		return nil, fmt.Errorf("Could not get tags: %s", err.Error())
+	}
+	repo := currentRepository()
+	skipped := 0
+	var objects []*accountObject
+	prefixOf := make(map[*accountObject]string)
+	prefixed := make(map[string]bool)
+	for _, object := range candidates {
+		prefix, ok := UploadPrefix(object.name, tags[object.href], prefixes, patterns)
+		if !ok {
+			continue
+		}
+		if !unowned && !OwnedBy(tags[object.href], repo) {
+			skipped++
+			continue
+		}
+		objects = append(objects, object)
+		prefixOf[object] = prefix
+		prefixed[object.href] = true
+	}
+	skippedUnowned(skipped, repo)
+	var otherSts []*cm15.ServerTemplate
+	for _, st := range sts {
+		if !prefixed[getLink(st.Links, "self")] {
+			otherSts = append(otherSts, st)
+		}
+	}
+
+	referenced := make(map[string]bool)
+	if err := referencedBy(otherSts, referenced); err != nil {
+		return nil, err
+	}
+
+	lastModified := make(map[string]time.Time)
+	for _, object := range objects {
+		if prefix := prefixOf[object]; object.updatedAt.After(lastModified[prefix]) {
+			lastModified[prefix] = object.updatedAt
+		}
+	}
+	if olderThan > 0 {
+		var unknown []string
+		for prefix, modified := range lastModified {
+			if modified.IsZero() {
+				unknown = append(unknown, prefix)
+			}
+		}
+		sort.Strings(unknown)
+		for _, prefix := range unknown {
+			fmt.Printf("WARNING: Skipping prefix %s, it has no RightScripts to tell when it was last modified\n", prefix)
+		}
+	}
+
+	var found []*accountObject
+	for _, object := range objects {
+		if referenced[object.href] {
+			fmt.Printf("WARNING: Skipping %s '%s', it is attached to a ServerTemplate which is not being cleaned up\n",
+				resourceTypeNames[object.resourceType], object.name)
+			continue
+		}
+		if olderThan > 0 {
+			modified := lastModified[prefixOf[object]]
+			if modified.IsZero() || time.Since(modified) < olderThan {
+				continue
+			}
+		}
+		found = append(found, object)
+	}
+	return found, nil
+}
+
+func cleanup(prefixes, patterns []string, olderThan string, unowned, yes bool) {
+	if len(prefixes) == 0 && len(patterns) == 0 {
+		fatalError("--prefix or --prefix-pattern must be given")
+	}
+	for _, pattern := range patterns {
+		if _, err := path.Match(pattern, ""); err != nil {
+			fatalError("Invalid prefix pattern %s: %s", pattern, err.Error())
+		}
+	}
+	var age time.Duration
+	if olderThan != "" {
+		var err error
+		if age, err = ParseAge(olderThan); err != nil {
+			fatalError("%s", err.Error())
+		}
+	}
+
+	objects, err := findPrefixed(prefixes, patterns, age, unowned)
+	if err != nil {
+		fatalError("%s", err.Error())
+	}
+	if len(objects) == 0 {
+		fmt.Println("Nothing to delete")
+		return
+	}
+	showObjects(objects, os.Stdout)
+	if !yes && !confirm(fmt.Sprintf("Delete these %d objects?", len(objects)), os.Stdin, os.Stdout) {
+		fmt.Println("Not deleting anything")
+		return
+	}
+	if err := deleteObjects(objects); err != nil {
+		fatalError("%s", err.Error())
+	}
+}
@@ -0,0 +1,68 @@
+package main_test
+
+import (
+	"time"
+
+	. "github.com/rightscale/right_st"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Cleanup", func() {
+	DescribeTable("ages",
+		func(age string, duration time.Duration, valid bool) {
+			result, err := ParseAge(age)
+			if valid {
+				Expect(err).NotTo(HaveOccurred())
+				Expect(result).To(Equal(duration))
+			} else {
+				Expect(err).To(MatchError(ContainSubstring("invalid age " + age)))
+			}
+		},
+		Entry("days", "14d", 14*24*time.Hour, true),
+		Entry("hours", "36h", 36*time.Hour, true),
+		Entry("minutes", "90m", 90*time.Minute, true),
+		Entry("bad days", "twod", time.Duration(0), false),
+		Entry("negative", "-3h", time.Duration(0), false),
+		Entry("no unit", "14", time.Duration(0), false),
+	)
+
+	DescribeTable("name prefixes",
+		func(name string, prefixes, patterns []string, prefix string, found bool) {
+			result, ok := NamePrefix(name, prefixes, patterns)
+			Expect(ok).To(Equal(found))
+			Expect(result).To(Equal(prefix))
+		},
+		Entry("a prefix", "pr123_My App", []string{"pr123"}, nil, "pr123", true),
+		Entry("a different prefix", "pr124_My App", []string{"pr123"}, nil, "", false),
+		Entry("a prefix with an underscore", "my_branch_My App", []string{"my_branch"}, nil, "my_branch", true),
+		Entry("a pattern", "pr124_My_App", nil, []string{"pr*"}, "pr124", true),
+		Entry("no prefix", "My App", nil, []string{"*"}, "", false),
+		Entry("a name which only starts like the prefix", "pr1234_My App", []string{"pr123"}, nil, "", false),
+	)
+
+	DescribeTable("upload prefixes",
+		func(name string, tags []string, prefix string, found bool) {
+			result, ok := UploadPrefix(name, tags, []string{"pr123"}, nil)
+			Expect(ok).To(Equal(found))
+			Expect(result).To(Equal(prefix))
+		},
+		Entry("a prefix tag", "My App [feature/x]", []string{"right_st:prefix=pr123"}, "pr123", true),
+		Entry("a different prefix tag", "pr123_My App", []string{"right_st:prefix=pr124"}, "pr124", false),
+		Entry("no prefix tag", "pr123_My App", []string{"other:tag=1"}, "pr123", true),
+		Entry("an empty prefix tag", "pr123_My App", []string{"right_st:repo=/src/templates", "right_st:prefix="}, "", false),
+		Entry("ownership tags without a prefix tag", "pr123_My App", []string{"right_st:repo=/src/templates"}, "", false),
+	)
+
+	It("does not match an owned production object by its name", func() {
+		tags := Owner{Repo: "git@github.com:example/templates.git", Path: "db/server.yml"}.Tags()
+		_, ok := UploadPrefix("db_server", tags, []string{"db"}, nil)
+		Expect(ok).To(BeFalse())
+		_, ok = UploadPrefix("db_server", tags, nil, []string{"d*"})
+		Expect(ok).To(BeFalse())
+		_, ok = UploadPrefix("db_server", tags, nil, []string{"*"})
+		Expect(ok).To(BeFalse())
+	})
+})
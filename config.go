@@ -23,11 +23,14 @@
 package main
 
 import (
+	"bytes"
 	"fmt"
 	"io"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
+	"text/tabwriter"
 
 	"github.com/spf13/viper"
 	"golang.org/x/crypto/ssh/terminal"
@@ -38,21 +41,46 @@ type ConfigViper struct {
 	*viper.Viper
 	Account  *Account
 	Accounts map[string]*Account
+
+	// ProjectFile is the project config file merged over the user config file, if one was found
+	ProjectFile string
+
+	// userSettings are the settings of the user config file alone which are what gets written back to it
+	userSettings map[string]interface{}
 }
 
+// ProjectConfigFile is the name of the project config file looked for in the working directory and its parents. It
+// differs from the name of the user config file so the user config file in the home directory is never mistaken for one.
+const ProjectConfigFile = ".right_st.project.yml"
+
 var Config ConfigViper
 
 func init() {
-	Config.Viper = viper.New()
-	Config.SetDefault("login", map[string]interface{}{"accounts": make(map[string]interface{})})
-	Config.SetDefault("update", map[string]interface{}{"check": true})
-	Config.SetEnvPrefix(app.Name)
-	Config.SetEnvKeyReplacer(strings.NewReplacer(".", "_"))
-	Config.AutomaticEnv()
+	Config.Viper = newViper()
 }
 
+// newViper returns a viper with the defaults and environment variable bindings of the configuration and no settings
+// from a config file.
+func newViper() *viper.Viper {
+	v := viper.New()
+	v.SetDefault("login", map[string]interface{}{"accounts": make(map[string]interface{})})
+	v.SetDefault("update", map[string]interface{}{"check": true})
+	v.SetEnvPrefix(app.Name)
+	v.SetEnvKeyReplacer(strings.NewReplacer(".", "_"))
+	v.AutomaticEnv()
+	return v
+}
+
+// ReadConfig reads the user config file and merges any project config file found from the working directory over it.
+// Settings are taken from, in order of precedence: command line flags, environment variables, the project config file,
+// and the user config file.
 func ReadConfig(configFile, account string) error {
+	// start from scratch so settings and accounts from a previously read config file do not linger
+	Config.Viper = newViper()
+	Config.Accounts = nil
 	Config.SetConfigFile(configFile)
+	Config.ProjectFile = ""
+	Config.userSettings = nil
 	err := Config.ReadInConfig()
 	if err != nil {
 		if _, ok := err.(*os.PathError); !(ok &&
@@ -65,6 +93,17 @@ func ReadConfig(configFile, account string) error {
 		}
 	}
 
+	if wd, err := os.Getwd(); err == nil {
+		if projectFile := FindProjectConfig(wd); projectFile != "" && !sameFile(projectFile, configFile) {
+			if err := Config.mergeProjectConfig(projectFile); err != nil {
+				return err
+			}
+		}
+	}
+	if account == "" {
+		account = Config.GetString("account")
+	}
+
 	err = Config.UnmarshalKey("login.accounts", &Config.Accounts)
 	if err != nil {
 		return fmt.Errorf("%s: %s", configFile, err)
@@ -107,6 +146,70 @@ func ReadConfig(configFile, account string) error {
 	return nil
 }
 
+// FindProjectConfig looks for a project config file in dir and each of its parents and returns the path of the first
+// one found or an empty string if there is none.
+func FindProjectConfig(dir string) string {
+	for {
+		file := filepath.Join(dir, ProjectConfigFile)
+		if info, err := os.Stat(file); err == nil && !info.IsDir() {
+			return file
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return ""
+		}
+		dir = parent
+	}
+}
+
+func sameFile(a, b string) bool {
+	aInfo, err := os.Stat(a)
+	if err != nil {
+		return false
+	}
+	bInfo, err := os.Stat(b)
+	if err != nil {
+		return false
+	}
+	return os.SameFile(aInfo, bInfo)
+}
+
+// mergeProjectConfig merges a project config file over the config. Project config files are meant to be committed to
+// repositories so they may not contain credentials. Relative paths in them are relative to the project config file.
+func (config *ConfigViper) mergeProjectConfig(projectFile string) error {
+	project := viper.New()
+	project.SetConfigFile(projectFile)
+	if err := project.ReadInConfig(); err != nil {
+		return fmt.Errorf("%s: %s", projectFile, err)
+	}
+	if project.IsSet("login") {
+		return fmt.Errorf("%s: login settings are not allowed in a project config file, use account to choose one of the accounts in %s",
+			projectFile, config.ConfigFileUsed())
+	}
+	if dir := project.GetString("cache.dir"); dir != "" && !filepath.IsAbs(dir) {
+		project.Set("cache.dir", filepath.Join(filepath.Dir(projectFile), dir))
+	}
+
+	yml, err := yaml.Marshal(project.AllSettings())
+	if err != nil {
+		return err
+	}
+	config.userSettings = config.AllSettings()
+	if err := config.MergeConfig(bytes.NewReader(yml)); err != nil {
+		return fmt.Errorf("%s: %s", projectFile, err)
+	}
+	config.ProjectFile = projectFile
+	return nil
+}
+
+// settings returns the settings of the user config file without any project config file merged in.
+func (config *ConfigViper) settings() map[string]interface{} {
+	if config.userSettings != nil {
+		return config.userSettings
+	}
+	return config.AllSettings()
+}
+
 func (config *ConfigViper) GetAccount(id int, host string) (*Account, error) {
 	for _, account := range config.Accounts {
 		if account.Id == id && account.Host == host {
@@ -136,30 +239,42 @@ func (config *ConfigViper) SetAccount(name string, setDefault, setPassword bool,
 		setDefault = true
 	}
 
-	// get the settings and specifically the login settings into a map we can manipulate and marshal to YAML unhindered
-	// by the meddling of the Viper
-	settings := config.AllSettings()
-	if _, ok := settings["login"]; !ok {
-		settings["login"] = map[string]interface{}{"accounts": make(map[string]interface{})}
-	}
-	loginSettings := settings["login"].(map[string]interface{})
-
-	// set the default account if we want or need to
-	if setDefault {
-		loginSettings["default_account"] = name
-	}
-
 	// get the previous value for the named account if it exists and construct a new account to populate
 	oldAccount, hasOldAccount := config.Accounts[name]
 	newAccount := &Account{}
 
+	// read the answer to a prompt, the end of the input is an empty answer when editing an existing account so the
+	// old values can be kept
+	scan := func(field string, value interface{}) error {
+		line, err := readLine(input)
+		if err == io.EOF && hasOldAccount {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("Could not read %s: %s", field, err.Error())
+		}
+		line = strings.TrimSpace(line)
+		if line == "" {
+			return nil
+		}
+		if strings.ContainsAny(line, " \t") {
+			return fmt.Errorf("%s must not contain spaces: %q", field, line)
+		}
+		if _, err := fmt.Sscan(line, value); err != nil {
+			return fmt.Errorf("%s is invalid: %q", field, line)
+		}
+		return nil
+	}
+
 	// prompt for the account ID and use the old value if nothing is entered
 	fmt.Fprint(output, "Account ID")
 	if hasOldAccount {
 		fmt.Fprintf(output, " (%d)", oldAccount.Id)
 	}
 	fmt.Fprint(output, ": ")
-	fmt.Fscanln(input, &newAccount.Id)
+	if err := scan("Account ID", &newAccount.Id); err != nil {
+		return err
+	}
 	if hasOldAccount && newAccount.Id == 0 {
 		newAccount.Id = oldAccount.Id
 	}
@@ -170,7 +285,9 @@ func (config *ConfigViper) SetAccount(name string, setDefault, setPassword bool,
 		fmt.Fprintf(output, " (%s)", oldAccount.Host)
 	}
 	fmt.Fprint(output, ": ")
-	fmt.Fscanln(input, &newAccount.Host)
+	if err := scan("API endpoint host", &newAccount.Host); err != nil {
+		return err
+	}
 	if hasOldAccount && newAccount.Host == "" {
 		newAccount.Host = oldAccount.Host
 	}
@@ -182,7 +299,9 @@ func (config *ConfigViper) SetAccount(name string, setDefault, setPassword bool,
 			fmt.Fprintf(output, " (%s)", oldAccount.Username)
 		}
 		fmt.Fprint(output, ": ")
-		fmt.Fscanln(input, &newAccount.Username)
+		if err := scan("Username", &newAccount.Username); err != nil {
+			return err
+		}
 		if hasOldAccount && newAccount.Username == "" {
 			newAccount.Username = oldAccount.Username
 		}
@@ -205,10 +324,14 @@ func (config *ConfigViper) SetAccount(name string, setDefault, setPassword bool,
 			}
 			password = string(b)
 		} else {
-			fmt.Fscanln(input, &password)
+			line, err := readLine(input)
+			if err != nil && !(err == io.EOF && hasOldAccount) {
+				return fmt.Errorf("Could not read password: %s", err.Error())
+			}
+			password = line
 		}
 		if hasOldAccount && password == "" {
-			newAccount.Password = oldAccount.Password
+			newAccount.Password, newAccount.PasswordCommand = oldAccount.Password, oldAccount.PasswordCommand
 		} else {
 			err := newAccount.EncryptPassword(password)
 			if err != nil {
@@ -222,15 +345,350 @@ func (config *ConfigViper) SetAccount(name string, setDefault, setPassword bool,
 			fmt.Fprintf(output, " (%s)", oldAccount.RefreshToken)
 		}
 		fmt.Fprint(output, ": ")
-		fmt.Fscanln(input, &newAccount.RefreshToken)
+		if err := scan("Refresh token", &newAccount.RefreshToken); err != nil {
+			return err
+		}
 		if hasOldAccount && newAccount.RefreshToken == "" {
-			newAccount.RefreshToken = oldAccount.RefreshToken
+			newAccount.RefreshToken, newAccount.RefreshTokenCommand = oldAccount.RefreshToken, oldAccount.RefreshTokenCommand
+		}
+	}
+
+	// offer to encrypt a new or unencrypted account with the passphrase the other accounts are already encrypted with
+	encrypt := false
+	if encryptedName := config.passphraseEncryptedAccount(); encryptedName != "" && !(hasOldAccount && oldAccount.passphraseEncrypted()) {
+		fmt.Fprint(output, "Encrypt with the passphrase already in use (Y/n): ")
+		answer, err := readLine(input)
+		if err != nil && err != io.EOF {
+			return fmt.Errorf("Could not read answer: %s", err.Error())
+		}
+		switch strings.ToLower(strings.TrimSpace(answer)) {
+		case "", "y", "yes":
+			encrypt = true
+		case "n", "no":
+		default:
+			return fmt.Errorf("Answer must be yes or no: %q", answer)
+		}
+	}
+
+	return config.writeAccount(name, setDefault, encrypt, newAccount)
+}
+
+// SetAccountValues adds or edits an account without prompting, for use by provisioning scripts. Fields of values
+// which are not set keep their previous value if the account already exists. A password is encrypted before it is
+// stored. A refresh token or password command replaces a stored refresh token or password.
+func (config *ConfigViper) SetAccountValues(name string, setDefault bool, values *Account) error {
+	// if the default account isn't set we should set it to the account we are setting
+	if !config.IsSet("login.default_account") {
+		setDefault = true
+	}
+
+	newAccount := &Account{}
+	if oldAccount, ok := config.Accounts[strings.ToLower(name)]; ok {
+		*newAccount = *oldAccount
+		// switching between a refresh token and a username and password replaces the old credentials
+		if values.RefreshToken != "" || values.RefreshTokenCommand != "" {
+			newAccount.RefreshToken, newAccount.RefreshTokenCommand = "", ""
+			newAccount.Username, newAccount.Password, newAccount.PasswordCommand = "", "", ""
+		} else if values.Username != "" || values.Password != "" || values.PasswordCommand != "" {
+			newAccount.RefreshToken, newAccount.RefreshTokenCommand = "", ""
+			if values.Password != "" || values.PasswordCommand != "" {
+				newAccount.Password, newAccount.PasswordCommand = "", ""
+			}
+		}
+	}
+	if values.Id != 0 {
+		newAccount.Id = values.Id
+	}
+	if values.Host != "" {
+		newAccount.Host = values.Host
+	}
+	if values.RefreshToken != "" {
+		newAccount.RefreshToken = values.RefreshToken
+	}
+	if values.RefreshTokenCommand != "" {
+		newAccount.RefreshTokenCommand = values.RefreshTokenCommand
+	}
+	if values.PasswordCommand != "" {
+		newAccount.PasswordCommand = values.PasswordCommand
+	}
+	if values.Username != "" {
+		newAccount.Username = values.Username
+	}
+	if values.Password != "" {
+		if err := newAccount.EncryptPassword(values.Password); err != nil {
+			return err
+		}
+	}
+
+	if newAccount.Id == 0 || newAccount.Host == "" {
+		return fmt.Errorf("Account %s must have an account ID and an API endpoint host", name)
+	}
+	if newAccount.RefreshToken == "" && newAccount.RefreshTokenCommand == "" &&
+		(newAccount.Username == "" || (newAccount.Password == "" && newAccount.PasswordCommand == "")) {
+		return fmt.Errorf("Account %s must have a refresh token or a username and password", name)
+	}
+
+	return config.writeAccount(name, setDefault, false, newAccount)
+}
+
+// reservedAccountNames are the names of the config account commands, an account with one of them could only be edited
+// with an explicit `config account set <name>`.
+var reservedAccountNames = []string{"list", "remove", "rename", "set", "use"}
+
+// checkAccountName returns an error if name cannot be used for a new account.
+func checkAccountName(name string) error {
+	for _, reserved := range reservedAccountNames {
+		if strings.ToLower(name) == reserved {
+			return fmt.Errorf("Account name %s is reserved for the config account %s command", name, reserved)
+		}
+	}
+	return nil
+}
+
+// readLine reads a line of input without the line ending. It reads a byte at a time so no input after the line is
+// consumed, which matters when the rest is read from a terminal. It returns io.EOF if there is no input left.
+func readLine(input io.Reader) (string, error) {
+	var line []byte
+	b := make([]byte, 1)
+	for {
+		n, err := input.Read(b)
+		if n == 1 {
+			if b[0] == '\n' {
+				return strings.TrimSuffix(string(line), "\r"), nil
+			}
+			line = append(line, b[0])
+		}
+		if err == io.EOF && len(line) != 0 {
+			return strings.TrimSuffix(string(line), "\r"), nil
+		}
+		if err != nil {
+			return "", err
+		}
+	}
+}
+
+// writeAccount adds an account to the config file overwriting any old value and optionally makes it the default. If
+// encrypt is set or the old value was encrypted with a passphrase, the new one is encrypted with the passphrase once it
+// is verified against the accounts which are already encrypted.
+func (config *ConfigViper) writeAccount(name string, setDefault, encrypt bool, account *Account) error {
+	oldAccount, hasOldAccount := config.Accounts[strings.ToLower(name)]
+	if !hasOldAccount {
+		if err := checkAccountName(name); err != nil {
+			return err
+		}
+	}
+	if encrypt || (hasOldAccount && oldAccount.passphraseEncrypted()) {
+		passphrase, err := getPassphrase(false)
+		if err != nil {
+			return err
+		}
+		if err := config.checkPassphrase(passphrase); err != nil {
+			return err
+		}
+		if _, err := account.EncryptWithPassphrase(passphrase); err != nil {
+			return err
+		}
+	}
+	return config.updateLoginSettings(func(loginSettings, accounts map[string]interface{}) error {
+		// set the default account if we want or need to
+		if setDefault {
+			loginSettings["default_account"] = name
+		}
+		// add the new account to the map of accounts overwriting any old value
+		accounts[name] = account
+		return nil
+	})
+}
+
+// passphraseEncryptedAccount returns the name of the first account encrypted with a passphrase or an empty string if
+// there is none.
+func (config *ConfigViper) passphraseEncryptedAccount() string {
+	var names []string
+	for name, account := range config.Accounts {
+		if account.passphraseEncrypted() {
+			names = append(names, name)
+		}
+	}
+	if len(names) == 0 {
+		return ""
+	}
+	sort.Strings(names)
+	return names[0]
+}
+
+// checkPassphrase returns an error if passphrase does not decrypt the credentials of an account which is already
+// encrypted with a passphrase, so accounts do not end up encrypted with different passphrases by a typo.
+func (config *ConfigViper) checkPassphrase(passphrase string) error {
+	name := config.passphraseEncryptedAccount()
+	if name == "" {
+		return nil
+	}
+	account := config.Accounts[name]
+	for _, value := range []string{account.RefreshToken, account.Password} {
+		if !strings.HasPrefix(value, passphraseEncryptedPrefix) {
+			continue
+		}
+		if _, err := DecryptWithPassphrase(strings.TrimPrefix(value, passphraseEncryptedPrefix), passphrase); err != nil {
+			// do not keep using a passphrase which is known to be wrong
+			cachedPassphrase = ""
+			return fmt.Errorf("Passphrase does not decrypt the credentials of account %s: %s", name, err.Error())
+		}
+		break
+	}
+	return nil
+}
+
+// EncryptAccounts encrypts the refresh tokens and passwords of the named accounts, or all accounts if no names are
+// given, with a passphrase. This also migrates passwords encrypted with the old built in key. It returns the names of
+// the accounts which were changed.
+func (config *ConfigViper) EncryptAccounts(names []string, passphrase string) ([]string, error) {
+	if len(names) == 0 {
+		for name := range config.Accounts {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	var encrypted []string
+	changed := make(map[string]*Account)
+	for _, name := range names {
+		name = strings.ToLower(name)
+		account, ok := config.Accounts[name]
+		if !ok {
+			return nil, fmt.Errorf("%s: could not find account: %s", config.ConfigFileUsed(), name)
+		}
+		newAccount := *account
+		ok, err := newAccount.EncryptWithPassphrase(passphrase)
+		if err != nil {
+			return nil, fmt.Errorf("Could not encrypt account %s: %s", name, err.Error())
+		}
+		if ok {
+			changed[name] = &newAccount
+			encrypted = append(encrypted, name)
+		}
+	}
+	if len(changed) == 0 {
+		return nil, nil
+	}
+
+	err := config.updateLoginSettings(func(loginSettings, accounts map[string]interface{}) error {
+		for name, account := range changed {
+			accounts[name] = account
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return encrypted, nil
+}
+
+// ListAccounts writes a table of the configured accounts marking the default one.
+func (config *ConfigViper) ListAccounts(output io.Writer) error {
+	names := make([]string, 0, len(config.Accounts))
+	for name := range config.Accounts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	defaultAccount := strings.ToLower(config.GetString("login.default_account"))
+
+	w := tabwriter.NewWriter(output, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "  NAME\tID\tHOST\tAUTH")
+	for _, name := range names {
+		account := config.Accounts[name]
+		marker := " "
+		if name == defaultAccount {
+			marker = "*"
+		}
+		var auth string
+		switch {
+		case account.RefreshTokenCommand != "":
+			auth = "refresh token command"
+		case account.RefreshToken != "":
+			auth = "refresh token"
+		case account.PasswordCommand != "":
+			auth = "password command"
+		default:
+			auth = "password"
 		}
+		if account.passphraseEncrypted() {
+			auth += " (passphrase)"
+		}
+		fmt.Fprintf(w, "%s %s\t%d\t%s\t%s\n", marker, name, account.Id, account.Host, auth)
 	}
+	return w.Flush()
+}
 
-	// add the new account to the map of accounts overwriting any old value
+// RemoveAccount removes an account from the config file. The default account can only be removed if it is the only
+// one left.
+func (config *ConfigViper) RemoveAccount(name string) error {
+	name = strings.ToLower(name)
+	if _, ok := config.Accounts[name]; !ok {
+		return fmt.Errorf("%s: could not find account: %s", config.ConfigFileUsed(), name)
+	}
+	return config.updateLoginSettings(func(loginSettings, accounts map[string]interface{}) error {
+		if strings.ToLower(config.GetString("login.default_account")) == name {
+			if len(accounts) > 1 {
+				return fmt.Errorf("Cannot remove the default account %s, make another account the default first", name)
+			}
+			delete(loginSettings, "default_account")
+		}
+		delete(accounts, name)
+		return nil
+	})
+}
+
+// RenameAccount renames an account in the config file keeping it the default if it was.
+func (config *ConfigViper) RenameAccount(oldName, newName string) error {
+	oldName, newName = strings.ToLower(oldName), strings.ToLower(newName)
+	account, ok := config.Accounts[oldName]
+	if !ok {
+		return fmt.Errorf("%s: could not find account: %s", config.ConfigFileUsed(), oldName)
+	}
+	if _, ok := config.Accounts[newName]; ok {
+		return fmt.Errorf("%s: account already exists: %s", config.ConfigFileUsed(), newName)
+	}
+	if err := checkAccountName(newName); err != nil {
+		return err
+	}
+	return config.updateLoginSettings(func(loginSettings, accounts map[string]interface{}) error {
+		if strings.ToLower(config.GetString("login.default_account")) == oldName {
+			loginSettings["default_account"] = newName
+		}
+		delete(accounts, oldName)
+		accounts[newName] = account
+		return nil
+	})
+}
+
+// UseAccount makes an account the default.
+func (config *ConfigViper) UseAccount(name string) error {
+	name = strings.ToLower(name)
+	if _, ok := config.Accounts[name]; !ok {
+		return fmt.Errorf("%s: could not find account: %s", config.ConfigFileUsed(), name)
+	}
+	return config.updateLoginSettings(func(loginSettings, accounts map[string]interface{}) error {
+		loginSettings["default_account"] = name
+		return nil
+	})
+}
+
+// updateLoginSettings gets the settings and specifically the login settings into maps update can manipulate and then
+// writes them to the config file as YAML unhindered by the meddling of the Viper
+func (config *ConfigViper) updateLoginSettings(update func(loginSettings, accounts map[string]interface{}) error) error {
+	settings := config.settings()
+	if _, ok := settings["login"]; !ok {
+		settings["login"] = map[string]interface{}{"accounts": make(map[string]interface{})}
+	}
+	loginSettings := settings["login"].(map[string]interface{})
+	if _, ok := loginSettings["accounts"]; !ok {
+		loginSettings["accounts"] = make(map[string]interface{})
+	}
 	accounts := loginSettings["accounts"].(map[string]interface{})
-	accounts[name] = newAccount
+
+	if err := update(loginSettings, accounts); err != nil {
+		return err
+	}
 
 	// render the settings map as YAML
 	yml, err := yaml.Marshal(settings)
@@ -265,26 +723,63 @@ func (config *ConfigViper) SetAccount(name string, setDefault, setPassword bool,
 	return nil
 }
 
+func configEncrypt(names []string) error {
+	passphrase, err := getPassphrase(true)
+	if err != nil {
+		return err
+	}
+	if err := Config.checkPassphrase(passphrase); err != nil {
+		return err
+	}
+	encrypted, err := Config.EncryptAccounts(names, passphrase)
+	if err != nil {
+		return err
+	}
+	if len(encrypted) == 0 {
+		fmt.Println("No refresh tokens or passwords needed to be encrypted")
+	} else {
+		fmt.Printf("Encrypted the refresh tokens and passwords of: %s\n", strings.Join(encrypted, ", "))
+	}
+	return nil
+}
+
 func (config *ConfigViper) ShowConfiguration(output io.Writer) error {
 	// Check if config file exists
 	if _, err := os.Stat(config.ConfigFileUsed()); err != nil {
 		return err
 	}
 
-	settings := config.AllSettings()
+	return config.showSettings(config.settings(), output)
+}
+
+// ShowEffectiveConfiguration shows the configuration with any project config file merged in, which is what commands
+// actually use.
+func (config *ConfigViper) ShowEffectiveConfiguration(output io.Writer) error {
+	fmt.Fprintf(output, "# user config: %s\n", config.ConfigFileUsed())
+	if config.ProjectFile != "" {
+		fmt.Fprintf(output, "# project config: %s\n", config.ProjectFile)
+	}
+	return config.showSettings(config.AllSettings(), output)
+}
+
+func (config *ConfigViper) showSettings(settings map[string]interface{}, output io.Writer) error {
 	if _, ok := settings["login"]; !ok {
 		settings["login"] = map[string]interface{}{"accounts": make(map[string]interface{})}
 	}
 	loginSettings := settings["login"].(map[string]interface{})
+	if _, ok := loginSettings["accounts"]; !ok {
+		loginSettings["accounts"] = make(map[string]interface{})
+	}
 	accounts := loginSettings["accounts"].(map[string]interface{})
 
 	for name, account := range config.Accounts {
+		masked := *account
 		var err error
-		account.Password, err = account.MaskPassword()
+		masked.Password, err = account.MaskPassword()
 		if err != nil {
 			return err
 		}
-		accounts[name] = account
+		accounts[name] = &masked
 	}
 
 	yml, err := yaml.Marshal(settings)
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Writing an account encrypted with a passphrase", func() {
+	var (
+		tempDir    string
+		configFile string
+	)
+
+	BeforeEach(func() {
+		var err error
+		tempDir, err = ioutil.TempDir("", "config")
+		Expect(err).NotTo(HaveOccurred())
+		configFile = filepath.Join(tempDir, ".right_st.yml")
+		Expect(ioutil.WriteFile(configFile, []byte(`---
+login:
+  default_account: production
+  accounts:
+    production:
+      host: us-3.rightscale.com
+      id: 12345
+      refresh_token: abcdef1234567890abcdef1234567890abcdef12
+    staging:
+      host: us-4.rightscale.com
+      id: 67890
+      refresh_token: fedcba0987654321febcba0987654321fedcba09
+`), 0600)).To(Succeed())
+		Expect(ReadConfig(configFile, "")).To(Succeed())
+		_, err = Config.EncryptAccounts([]string{"staging"}, "correct horse battery staple")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(ReadConfig(configFile, "")).To(Succeed())
+	})
+
+	AfterEach(func() {
+		cachedPassphrase = ""
+		os.RemoveAll(tempDir)
+	})
+
+	It("refuses a passphrase which does not decrypt the account", func() {
+		cachedPassphrase = "correct horse battery stable"
+		err := Config.SetAccountValues("staging", false, &Account{RefreshToken: "0123456789abcdef0123456789abcdef01234567"})
+		Expect(err).To(MatchError("Passphrase does not decrypt the credentials of account staging: could not decrypt credentials, wrong passphrase?"))
+		Expect(cachedPassphrase).To(BeEmpty())
+
+		Expect(ReadConfig(configFile, "staging")).To(Succeed())
+		Expect(DecryptWithPassphrase(strings.TrimPrefix(Config.Account.RefreshToken, passphraseEncryptedPrefix),
+			"correct horse battery staple")).To(Equal("fedcba0987654321febcba0987654321fedcba09"))
+	})
+
+	It("refuses a passphrase which does not decrypt another account when encrypting a new one", func() {
+		cachedPassphrase = "correct horse battery stable"
+		err := Config.writeAccount("testing", false, true, &Account{Id: 54321, Host: "us-4.rightscale.com", RefreshToken: "0123456789abcdef0123456789abcdef01234567"})
+		Expect(err).To(MatchError(ContainSubstring("Passphrase does not decrypt the credentials of account staging")))
+		Expect(ReadConfig(configFile, "")).To(Succeed())
+		Expect(Config.Accounts).NotTo(HaveKey("testing"))
+	})
+})
@@ -542,6 +542,349 @@ update:
 `))
 					})
 				})
+
+				Context("With bad input", func() {
+					It("Returns an error if the input ends for a new account", func() {
+						Expect(ReadConfig(configFile, "")).To(Succeed())
+						input := new(bytes.Buffer)
+						fmt.Fprintln(input, 54321)
+						err := Config.SetAccount("testing", false, false, input, buffer)
+						Expect(err).To(MatchError("Could not read API endpoint host: EOF"))
+					})
+
+					It("Returns an error for an answer with spaces", func() {
+						Expect(ReadConfig(configFile, "")).To(Succeed())
+						input := new(bytes.Buffer)
+						fmt.Fprintln(input, 54321)
+						fmt.Fprintln(input, "us-4.rightscale.com extra")
+						err := Config.SetAccount("testing", false, false, input, buffer)
+						Expect(err).To(MatchError(`API endpoint host must not contain spaces: "us-4.rightscale.com extra"`))
+					})
+
+					It("Returns an error for an account ID which is not a number", func() {
+						Expect(ReadConfig(configFile, "")).To(Succeed())
+						input := new(bytes.Buffer)
+						fmt.Fprintln(input, "production")
+						err := Config.SetAccount("testing", false, false, input, buffer)
+						Expect(err).To(MatchError(`Account ID is invalid: "production"`))
+					})
+
+					It("Keeps spaces in a password", func() {
+						Expect(ReadConfig(configFile, "")).To(Succeed())
+						input := new(bytes.Buffer)
+						fmt.Fprintln(input, 54321)
+						fmt.Fprintln(input, "us-4.rightscale.com")
+						fmt.Fprintln(input, "cool.dude@rightscale.com")
+						fmt.Fprint(input, "correct horse battery staple\r\n")
+						Expect(Config.SetAccount("testing", false, true, input, buffer)).To(Succeed())
+						Expect(ReadConfig(configFile, "testing")).To(Succeed())
+						Expect(Config.Account.DecryptPassword()).To(Equal("correct horse battery staple"))
+					})
+
+					It("Returns an error for a new account named after a config account command", func() {
+						Expect(ReadConfig(configFile, "")).To(Succeed())
+						input := new(bytes.Buffer)
+						fmt.Fprintln(input, 54321)
+						fmt.Fprintln(input, "us-4.rightscale.com")
+						fmt.Fprintln(input, "21fedcba0987654321fedcba0987654321fedcba")
+						err := Config.SetAccount("list", false, false, input, buffer)
+						Expect(err).To(MatchError("Account name list is reserved for the config account list command"))
+					})
+				})
+			})
+
+			Describe("Set account values", func() {
+				It("Adds an account without prompting", func() {
+					Expect(ReadConfig(configFile, "")).To(Succeed())
+					Expect(Config.SetAccountValues("testing", false, &Account{
+						Id:           54321,
+						Host:         "us-4.rightscale.com",
+						RefreshToken: "21fedcba0987654321fedcba0987654321fedcba",
+					})).To(Succeed())
+					Expect(ReadConfig(configFile, "testing")).To(Succeed())
+					Expect(Config.Account).To(Equal(&Account{
+						Id:           54321,
+						Host:         "us-4.rightscale.com",
+						RefreshToken: "21fedcba0987654321fedcba0987654321fedcba",
+					}))
+					Expect(Config.GetString("login.default_account")).To(Equal("production"))
+				})
+
+				It("Keeps the old values of an existing account and replaces its credentials", func() {
+					Expect(ReadConfig(configFile, "")).To(Succeed())
+					Expect(Config.SetAccountValues("staging", true, &Account{
+						Username: "cool.dude@rightscale.com",
+						Password: "hunter3",
+					})).To(Succeed())
+					Expect(ReadConfig(configFile, "")).To(Succeed())
+					Expect(Config.Account.Id).To(Equal(67890))
+					Expect(Config.Account.RefreshToken).To(BeEmpty())
+					Expect(Config.Account.Username).To(Equal("cool.dude@rightscale.com"))
+					Expect(Config.Account.DecryptPassword()).To(Equal("hunter3"))
+				})
+
+				It("Keeps the refresh token and password commands when an account is set again", func() {
+					Expect(ReadConfig(configFile, "")).To(Succeed())
+					Expect(Config.SetAccountValues("testing", false, &Account{
+						Id:                  54321,
+						Host:                "us-4.rightscale.com",
+						RefreshTokenCommand: "pass show rightscale/testing",
+					})).To(Succeed())
+					Expect(ReadConfig(configFile, "")).To(Succeed())
+					Expect(Config.SetAccountValues("vault", false, &Account{
+						Id:              54322,
+						Host:            "us-4.rightscale.com",
+						Username:        "cool.dude@rightscale.com",
+						PasswordCommand: "pass show rightscale/vault",
+					})).To(Succeed())
+					Expect(ReadConfig(configFile, "")).To(Succeed())
+					Expect(Config.SetAccount("testing", false, false, new(bytes.Buffer), buffer)).To(Succeed())
+					Expect(Config.SetAccount("vault", false, true, new(bytes.Buffer), buffer)).To(Succeed())
+					Expect(ReadConfig(configFile, "testing")).To(Succeed())
+					Expect(Config.Account.RefreshTokenCommand).To(Equal("pass show rightscale/testing"))
+					Expect(ReadConfig(configFile, "vault")).To(Succeed())
+					Expect(Config.Account.Username).To(Equal("cool.dude@rightscale.com"))
+					Expect(Config.Account.PasswordCommand).To(Equal("pass show rightscale/vault"))
+				})
+
+				It("Returns an error for an incomplete account", func() {
+					Expect(ReadConfig(configFile, "")).To(Succeed())
+					err := Config.SetAccountValues("testing", false, &Account{Id: 54321, Host: "us-4.rightscale.com"})
+					Expect(err).To(MatchError("Account testing must have a refresh token or a username and password"))
+				})
+			})
+
+			Describe("List accounts", func() {
+				It("Prints a table of the accounts marking the default", func() {
+					Expect(ReadConfig(configFile, "")).To(Succeed())
+					Expect(Config.ListAccounts(buffer)).To(Succeed())
+					Expect(string(buffer.Contents())).To(Equal(`  NAME        ID      HOST                 AUTH
+  dev         101112  us-4.rightscale.com  password
+* production  12345   us-3.rightscale.com  refresh token
+  staging     67890   us-4.rightscale.com  refresh token
+`))
+				})
+			})
+
+			Describe("Remove account", func() {
+				It("Removes the account and backs up the config file", func() {
+					Expect(ReadConfig(configFile, "")).To(Succeed())
+					Expect(Config.RemoveAccount("Staging")).To(Succeed())
+					Expect(ReadConfig(configFile, "")).To(Succeed())
+					Expect(Config.Accounts).NotTo(HaveKey("staging"))
+					Expect(Config.Accounts).To(HaveLen(2))
+					Expect(configFile + ".bak").To(BeARegularFile())
+					info, err := os.Stat(configFile)
+					Expect(err).NotTo(HaveOccurred())
+					Expect(info.Mode().Perm()).To(Equal(os.FileMode(0600)))
+				})
+
+				It("Returns an error for the default account", func() {
+					Expect(ReadConfig(configFile, "")).To(Succeed())
+					Expect(Config.RemoveAccount("production")).To(MatchError(ContainSubstring("Cannot remove the default account production")))
+				})
+
+				It("Returns an error for a nonexistent account", func() {
+					Expect(ReadConfig(configFile, "")).To(Succeed())
+					Expect(Config.RemoveAccount("development")).To(MatchError(configFile + ": could not find account: development"))
+				})
+			})
+
+			Describe("Rename account", func() {
+				It("Renames the account and keeps it the default", func() {
+					Expect(ReadConfig(configFile, "")).To(Succeed())
+					Expect(Config.RenameAccount("production", "prod")).To(Succeed())
+					Expect(ReadConfig(configFile, "")).To(Succeed())
+					Expect(Config.GetString("login.default_account")).To(Equal("prod"))
+					Expect(Config.Accounts).NotTo(HaveKey("production"))
+					Expect(Config.Account).To(Equal(&Account{
+						Id:           12345,
+						Host:         "us-3.rightscale.com",
+						RefreshToken: "abcdef1234567890abcdef1234567890abcdef12",
+					}))
+				})
+
+				It("Returns an error if the new name is taken", func() {
+					Expect(ReadConfig(configFile, "")).To(Succeed())
+					Expect(Config.RenameAccount("production", "dev")).To(MatchError(configFile + ": account already exists: dev"))
+				})
+
+				It("Returns an error if the new name is a config account command", func() {
+					Expect(ReadConfig(configFile, "")).To(Succeed())
+					Expect(Config.RenameAccount("production", "Use")).To(MatchError("Account name use is reserved for the config account use command"))
+				})
+			})
+
+			Describe("Use account", func() {
+				It("Sets the default account", func() {
+					Expect(ReadConfig(configFile, "")).To(Succeed())
+					Expect(Config.UseAccount("staging")).To(Succeed())
+					Expect(ReadConfig(configFile, "")).To(Succeed())
+					Expect(Config.Account.Id).To(Equal(67890))
+				})
+			})
+
+			Describe("Encrypt accounts", func() {
+				BeforeEach(func() {
+					if err := os.Setenv(PassphraseEnv, "correct horse battery staple"); err != nil {
+						panic(err)
+					}
+				})
+
+				AfterEach(func() {
+					if err := os.Unsetenv(PassphraseEnv); err != nil {
+						panic(err)
+					}
+				})
+
+				It("Encrypts the credentials of all accounts with the passphrase", func() {
+					Expect(ReadConfig(configFile, "")).To(Succeed())
+					encrypted, err := Config.EncryptAccounts(nil, "correct horse battery staple")
+					Expect(err).NotTo(HaveOccurred())
+					Expect(encrypted).To(Equal([]string{"dev", "production", "staging"}))
+
+					config, err := ioutil.ReadFile(configFile)
+					Expect(err).NotTo(HaveOccurred())
+					Expect(string(config)).NotTo(ContainSubstring("hunter2"))
+					Expect(string(config)).NotTo(ContainSubstring("abcdef1234567890abcdef1234567890abcdef12"))
+
+					Expect(ReadConfig(configFile, "dev")).To(Succeed())
+					Expect(Config.Account.Password).To(HavePrefix("{PASSPHRASE}"))
+					Expect(Config.Account.DecryptPassword()).To(Equal("hunter2"))
+					Expect(Config.ListAccounts(buffer)).To(Succeed())
+					Expect(buffer).To(gbytes.Say(`dev +101112 +us-4.rightscale.com +password \(passphrase\)`))
+				})
+
+				It("Keeps an account encrypted when it is set again", func() {
+					Expect(ReadConfig(configFile, "")).To(Succeed())
+					_, err := Config.EncryptAccounts([]string{"staging"}, "correct horse battery staple")
+					Expect(err).NotTo(HaveOccurred())
+					Expect(ReadConfig(configFile, "")).To(Succeed())
+					Expect(Config.SetAccountValues("staging", false, &Account{RefreshToken: "0123456789abcdef0123456789abcdef01234567"})).To(Succeed())
+					Expect(ReadConfig(configFile, "staging")).To(Succeed())
+					Expect(Config.Account.RefreshToken).To(HavePrefix("{PASSPHRASE}"))
+				})
+
+				It("Offers to encrypt a new account with the passphrase already in use", func() {
+					Expect(ReadConfig(configFile, "")).To(Succeed())
+					_, err := Config.EncryptAccounts([]string{"staging"}, "correct horse battery staple")
+					Expect(err).NotTo(HaveOccurred())
+					Expect(ReadConfig(configFile, "")).To(Succeed())
+					input := new(bytes.Buffer)
+					fmt.Fprintln(input, 54321)
+					fmt.Fprintln(input, "us-4.rightscale.com")
+					fmt.Fprintln(input, "21fedcba0987654321fedcba0987654321fedcba")
+					fmt.Fprintln(input)
+					Expect(Config.SetAccount("testing", false, false, input, buffer)).To(Succeed())
+					Expect(buffer).To(gbytes.Say(`Refresh token: Encrypt with the passphrase already in use \(Y/n\): `))
+					Expect(ReadConfig(configFile, "testing")).To(Succeed())
+					Expect(Config.Account.RefreshToken).To(HavePrefix("{PASSPHRASE}"))
+				})
+
+				It("Does not encrypt a new account if the offer is declined", func() {
+					Expect(ReadConfig(configFile, "")).To(Succeed())
+					_, err := Config.EncryptAccounts([]string{"staging"}, "correct horse battery staple")
+					Expect(err).NotTo(HaveOccurred())
+					Expect(ReadConfig(configFile, "")).To(Succeed())
+					input := new(bytes.Buffer)
+					fmt.Fprintln(input, 54321)
+					fmt.Fprintln(input, "us-4.rightscale.com")
+					fmt.Fprintln(input, "21fedcba0987654321fedcba0987654321fedcba")
+					fmt.Fprintln(input, "n")
+					Expect(Config.SetAccount("testing", false, false, input, buffer)).To(Succeed())
+					Expect(ReadConfig(configFile, "testing")).To(Succeed())
+					Expect(Config.Account.RefreshToken).To(Equal("21fedcba0987654321fedcba0987654321fedcba"))
+				})
+			})
+
+			Context("With a project config file", func() {
+				var (
+					projectDir  string
+					projectFile string
+					wd          string
+				)
+
+				BeforeEach(func() {
+					var err error
+					wd, err = os.Getwd()
+					if err != nil {
+						panic(err)
+					}
+					projectDir = filepath.Join(tempDir, "repo")
+					if err := os.MkdirAll(filepath.Join(projectDir, "servertemplates"), 0755); err != nil {
+						panic(err)
+					}
+					projectFile = filepath.Join(projectDir, ".right_st.project.yml")
+					err = ioutil.WriteFile(projectFile, []byte(`account: staging
+prefix: pr1
+cache:
+  dir: .cache
+`), 0644)
+					if err != nil {
+						panic(err)
+					}
+					if err := os.Chdir(filepath.Join(projectDir, "servertemplates")); err != nil {
+						panic(err)
+					}
+				})
+
+				AfterEach(func() {
+					if err := os.Chdir(wd); err != nil {
+						panic(err)
+					}
+				})
+
+				It("Finds the project config file in a parent directory", func() {
+					Expect(FindProjectConfig(filepath.Join(projectDir, "servertemplates"))).To(Equal(projectFile))
+					Expect(FindProjectConfig(tempDir)).To(BeEmpty())
+				})
+
+				It("Does not mistake the user config file for a project config file", func() {
+					Expect(os.Remove(projectFile)).To(Succeed())
+					Expect(ReadConfig(configFile, "")).To(Succeed())
+					Expect(Config.ProjectFile).To(BeEmpty())
+					Expect(Config.Account.Id).To(Equal(12345))
+				})
+
+				It("Merges the project config file over the user config file", func() {
+					Expect(ReadConfig(configFile, "")).To(Succeed())
+					Expect(Config.ProjectFile).To(Equal(projectFile))
+					Expect(Config.Account.Id).To(Equal(67890))
+					Expect(Config.GetString("prefix")).To(Equal("pr1"))
+					Expect(Config.GetString("cache.dir")).To(Equal(filepath.Join(projectDir, ".cache")))
+				})
+
+				It("Lets the command line choose another account", func() {
+					Expect(ReadConfig(configFile, "dev")).To(Succeed())
+					Expect(Config.Account.Id).To(Equal(101112))
+				})
+
+				It("Only shows the project settings in the effective configuration", func() {
+					Expect(ReadConfig(configFile, "")).To(Succeed())
+					Expect(Config.ShowConfiguration(buffer)).To(Succeed())
+					Expect(buffer.Contents()).NotTo(ContainSubstring("pr1"))
+					buffer = gbytes.NewBuffer()
+					Expect(Config.ShowEffectiveConfiguration(buffer)).To(Succeed())
+					Expect(buffer).To(gbytes.Say("# project config: " + regexp.QuoteMeta(projectFile)))
+					Expect(buffer).To(gbytes.Say("prefix: pr1"))
+				})
+
+				It("Does not write the project settings to the user config file", func() {
+					Expect(ReadConfig(configFile, "")).To(Succeed())
+					Expect(Config.UseAccount("dev")).To(Succeed())
+					config, err := ioutil.ReadFile(configFile)
+					Expect(err).NotTo(HaveOccurred())
+					Expect(string(config)).NotTo(ContainSubstring("pr1"))
+					Expect(string(config)).To(ContainSubstring("default_account: dev"))
+				})
+
+				It("Returns an error for login settings in the project config file", func() {
+					err := ioutil.WriteFile(projectFile, []byte(`login:
+  default_account: staging
+`), 0644)
+					Expect(err).NotTo(HaveOccurred())
+					Expect(ReadConfig(configFile, "")).To(MatchError(ContainSubstring("login settings are not allowed in a project config file")))
+				})
 			})
 
 			Describe("Show configuration", func() {
@@ -3,6 +3,7 @@
 package main
 
 import (
+	"os/exec"
 	"os/user"
 	"path/filepath"
 )
@@ -15,3 +16,8 @@ func DefaultConfigFile() string {
 
 	return filepath.Join(currentUser.HomeDir, ".right_st.yml")
 }
+
+// shellCommand returns a command which runs a command line with the shell.
+func shellCommand(command string) *exec.Cmd {
+	return exec.Command("/bin/sh", "-c", command)
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"os/exec"
 	"path/filepath"
 
 	"github.com/douglaswth/rsrdp/win32"
@@ -14,3 +15,8 @@ func DefaultConfigFile() string {
 
 	return filepath.Join(roamingPath, "RightST", ".right_st.yml")
 }
+
+// shellCommand returns a command which runs a command line with the shell.
+func shellCommand(command string) *exec.Cmd {
+	return exec.Command("cmd", "/C", command)
+}
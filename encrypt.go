@@ -6,7 +6,12 @@ import (
 	"crypto/rand"
 	"encoding/base64"
 	"errors"
+	"fmt"
 	"io"
+	"os"
+
+	"golang.org/x/crypto/scrypt"
+	"golang.org/x/crypto/ssh/terminal"
 )
 
 // Key used to encrypt/decrypt password
@@ -64,3 +69,112 @@ func Decrypt(text string) (string, error) {
 	cfb.XORKeyStream(bytes, bytes)
 	return string(decodeBase64(bytes)), nil
 }
+
+// scrypt parameters and sizes for EncryptWithPassphrase, the salt is stored with every value so the same passphrase
+// never results in the same key
+const (
+	scryptN       = 32768
+	scryptR       = 8
+	scryptP       = 1
+	scryptKeyLen  = 32
+	scryptSaltLen = 16
+)
+
+// PassphraseEnv is the environment variable which holds the passphrase for passphrase encrypted credentials when
+// right_st is not run interactively.
+const PassphraseEnv = "RIGHT_ST_PASSPHRASE"
+
+var cachedPassphrase string
+
+// EncryptWithPassphrase encrypts text with AES-GCM using a key derived from the passphrase with scrypt. The result is
+// the base64 encoded salt, nonce and ciphertext.
+func EncryptWithPassphrase(text, passphrase string) (string, error) {
+	salt := make([]byte, scryptSaltLen)
+	if _, err := io.ReadFull(rand.Reader, salt); err != nil {
+		return "", err
+	}
+	gcm, err := passphraseCipher(passphrase, salt)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return "", err
+	}
+	data := append(append(salt, nonce...), gcm.Seal(nil, nonce, []byte(text), nil)...)
+	return base64.StdEncoding.EncodeToString(data), nil
+}
+
+// DecryptWithPassphrase decrypts text encrypted by EncryptWithPassphrase. Unlike Decrypt it returns an error for the
+// wrong passphrase.
+func DecryptWithPassphrase(text, passphrase string) (string, error) {
+	data, err := base64.StdEncoding.DecodeString(text)
+	if err != nil {
+		return "", err
+	}
+	if len(data) < scryptSaltLen {
+		return "", errors.New("ciphertext too short")
+	}
+	gcm, err := passphraseCipher(passphrase, data[:scryptSaltLen])
+	if err != nil {
+		return "", err
+	}
+	data = data[scryptSaltLen:]
+	if len(data) < gcm.NonceSize() {
+		return "", errors.New("ciphertext too short")
+	}
+	plaintext, err := gcm.Open(nil, data[:gcm.NonceSize()], data[gcm.NonceSize():], nil)
+	if err != nil {
+		return "", errors.New("could not decrypt credentials, wrong passphrase?")
+	}
+	return string(plaintext), nil
+}
+
+func passphraseCipher(passphrase string, salt []byte) (cipher.AEAD, error) {
+	key, err := scrypt.Key([]byte(passphrase), salt, scryptN, scryptR, scryptP, scryptKeyLen)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// getPassphrase gets the passphrase for passphrase encrypted credentials from the environment or else by prompting for
+// it on the terminal, asking twice if confirm is set. It is only asked for once per run.
+func getPassphrase(confirm bool) (string, error) {
+	if cachedPassphrase != "" {
+		return cachedPassphrase, nil
+	}
+	if passphrase := os.Getenv(PassphraseEnv); passphrase != "" {
+		cachedPassphrase = passphrase
+		return passphrase, nil
+	}
+	if !terminal.IsTerminal(int(os.Stdin.Fd())) {
+		return "", fmt.Errorf("Credentials are encrypted with a passphrase, set %s or run interactively", PassphraseEnv)
+	}
+	fmt.Fprint(os.Stderr, "Passphrase: ")
+	passphrase, err := terminal.ReadPassword(int(os.Stdin.Fd()))
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return "", err
+	}
+	if len(passphrase) == 0 {
+		return "", errors.New("Passphrase must not be empty")
+	}
+	if confirm {
+		fmt.Fprint(os.Stderr, "Confirm passphrase: ")
+		confirmation, err := terminal.ReadPassword(int(os.Stdin.Fd()))
+		fmt.Fprintln(os.Stderr)
+		if err != nil {
+			return "", err
+		}
+		if string(confirmation) != string(passphrase) {
+			return "", errors.New("Passphrases do not match")
+		}
+	}
+	cachedPassphrase = string(passphrase)
+	return cachedPassphrase, nil
+}
@@ -26,4 +26,33 @@ var _ = Describe("Encrypt", func() {
 
 	})
 
+	Context("given a string value and a passphrase", func() {
+		var (
+			seekret    = "sensitive value"
+			passphrase = "correct horse battery staple"
+		)
+
+		It("encrypts differently every time", func() {
+			first, err := EncryptWithPassphrase(seekret, passphrase)
+			Ω(err).ShouldNot(HaveOccurred())
+			second, err := EncryptWithPassphrase(seekret, passphrase)
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(first).ShouldNot(Equal(second))
+		})
+
+		It("decrypts", func() {
+			encrypted, err := EncryptWithPassphrase(seekret, passphrase)
+			Ω(err).ShouldNot(HaveOccurred())
+			decrypted, err := DecryptWithPassphrase(encrypted, passphrase)
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(decrypted).Should(Equal(seekret))
+		})
+
+		It("does not decrypt with the wrong passphrase", func() {
+			encrypted, err := EncryptWithPassphrase(seekret, passphrase)
+			Ω(err).ShouldNot(HaveOccurred())
+			_, err = DecryptWithPassphrase(encrypted, "wrong passphrase")
+			Ω(err).Should(MatchError(ContainSubstring("wrong passphrase")))
+		})
+	})
 })
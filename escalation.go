@@ -0,0 +1,174 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// Escalation is an alert escalation on disk. Alerts refer to it by Name with `Then escalate <Name>` or `Escalation:`.
+type Escalation struct {
+	Name        string              `yaml:"Name"`
+	Description string              `yaml:"Description,omitempty"`
+	Actions     []*EscalationAction `yaml:"Actions"`
+}
+
+// EscalationAction is a single step of an escalation, exactly one of its fields must be set: an email address to
+// notify, the name of a RightScript to run, a vote tag to vote to grow or shrink a server array with, or a number of
+// minutes to wait before the next action.
+type EscalationAction struct {
+	Email       string `yaml:"Email,omitempty"`
+	RightScript string `yaml:"Run RightScript,omitempty"`
+	Grow        string `yaml:"Grow,omitempty"`
+	Shrink      string `yaml:"Shrink,omitempty"`
+	Delay       int    `yaml:"Delay,omitempty"`
+}
+
+type Escalations struct {
+	Escalations []*Escalation `yaml:"Escalations"`
+}
+
+func ParseEscalations(ymlData io.Reader) (*Escalations, error) {
+	escalations := Escalations{}
+	bytes, err := ioutil.ReadAll(ymlData)
+	if err != nil {
+		return nil, err
+	}
+	err = yaml.UnmarshalStrict(bytes, &escalations)
+	if err != nil {
+		return nil, err
+	}
+	return &escalations, nil
+}
+
+// ValidateEscalations checks that every escalation has a unique name and only well formed actions.
+func ValidateEscalations(escalations *Escalations) []error {
+	var errors []error
+	seen := make(map[string]bool)
+	for i, escalation := range escalations.Escalations {
+		if escalation.Name == "" {
+			errors = append(errors, fmt.Errorf("Escalation %d error: Name field must be present", i))
+			continue
+		}
+		name := normalizeAlertName(escalation.Name)
+		if seen[name] {
+			errors = append(errors, fmt.Errorf("Escalation %d error: Name '%s' is used by more than one escalation", i, escalation.Name))
+		}
+		seen[name] = true
+		if len(escalation.Actions) == 0 {
+			errors = append(errors, fmt.Errorf("Escalation %d error: '%s' must have at least one action", i, escalation.Name))
+		}
+		for j, action := range escalation.Actions {
+			if err := validateEscalationAction(action); err != nil {
+				errors = append(errors, fmt.Errorf("Escalation %d error: '%s' action %d: %s", i, escalation.Name, j, err.Error()))
+			}
+		}
+	}
+	return errors
+}
+
+func validateEscalationAction(action *EscalationAction) error {
+	set := 0
+	for _, field := range []string{action.Email, action.RightScript, action.Grow, action.Shrink} {
+		if field != "" {
+			set++
+		}
+	}
+	if action.Delay != 0 {
+		set++
+	}
+	if set != 1 {
+		return fmt.Errorf("must have exactly one of Email, Run RightScript, Grow, Shrink or Delay")
+	}
+	if action.Email != "" && !strings.Contains(action.Email, "@") {
+		return fmt.Errorf("Email '%s' is not an email address", action.Email)
+	}
+	if action.Delay < 0 {
+		return fmt.Errorf("Delay must be a positive number of minutes")
+	}
+	return nil
+}
+
+// EscalationNames returns the set of normalized names of the escalations.
+func EscalationNames(escalations ...*Escalations) map[string]bool {
+	names := make(map[string]bool)
+	for _, e := range escalations {
+		for _, escalation := range e.Escalations {
+			names[normalizeAlertName(escalation.Name)] = true
+		}
+	}
+	return names
+}
+
+// UndefinedEscalations returns the names of the escalations referred to by alerts which are not in names.
+func UndefinedEscalations(alerts []*Alert, names map[string]bool) []string {
+	var undefined []string
+	seen := make(map[string]bool)
+	for _, alert := range alerts {
+		spec, err := alert.Spec()
+		if err != nil || spec.EscalationName == "" {
+			continue
+		}
+		name := normalizeAlertName(spec.EscalationName)
+		if !names[name] && !seen[name] {
+			undefined = append(undefined, spec.EscalationName)
+			seen[name] = true
+		}
+	}
+	return undefined
+}
+
+func loadEscalations(file string) (*Escalations, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ParseEscalations(f)
+}
+
+func escalationValidate(files []string) {
+	errEncountered := false
+	for _, file := range files {
+		escalations, err := loadEscalations(file)
+		var errors []error
+		if err != nil {
+			errors = []error{err}
+		} else {
+			errors = ValidateEscalations(escalations)
+		}
+		if len(errors) != 0 {
+			fmt.Println("Encountered the following errors with the Escalations:")
+			errEncountered = true
+			for _, err := range errors {
+				fmt.Fprintf(os.Stderr, "%s: %s\n", file, err.Error())
+			}
+		} else {
+			fmt.Printf("%s: Valid Escalations\n", file)
+		}
+	}
+	if errEncountered {
+		exit(1)
+	}
+}
+
+// escalationNamesFromFiles loads the names of the escalations defined in files for checking the escalations Alerts
+// refer to. It returns nil if there are no files since there is nothing to check against.
+func escalationNamesFromFiles(files []string) (map[string]bool, error) {
+	if len(files) == 0 {
+		return nil, nil
+	}
+	var all []*Escalations
+	for _, file := range files {
+		escalations, err := loadEscalations(file)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", file, err.Error())
+		}
+		all = append(all, escalations)
+	}
+	return EscalationNames(all...), nil
+}
@@ -0,0 +1,91 @@
+package main_test
+
+import (
+	"strings"
+
+	. "github.com/rightscale/right_st"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Escalations", func() {
+	Context("With a valid Escalations file", func() {
+		var escalations *Escalations
+
+		BeforeEach(func() {
+			var err error
+			escalations, err = ParseEscalations(strings.NewReader(`Escalations:
+- Name: critical
+  Description: Page the on call engineer and restart the app
+  Actions:
+  - Email: oncall@example.com
+  - Delay: 5
+  - Run RightScript: Restart App
+- Name: scale up
+  Actions:
+  - Grow: my_app_name
+`))
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("parses the escalations", func() {
+			Expect(escalations.Escalations).To(Equal([]*Escalation{
+				{
+					Name:        "critical",
+					Description: "Page the on call engineer and restart the app",
+					Actions: []*EscalationAction{
+						{Email: "oncall@example.com"},
+						{Delay: 5},
+						{RightScript: "Restart App"},
+					},
+				},
+				{
+					Name:    "scale up",
+					Actions: []*EscalationAction{{Grow: "my_app_name"}},
+				},
+			}))
+			Expect(ValidateEscalations(escalations)).To(BeEmpty())
+		})
+
+		It("finds escalations Alerts refer to which are not defined", func() {
+			alerts := []*Alert{
+				{Name: "CPU", Clause: "If cpu-0/cpu-idle.value < 10 for 5 minutes Then escalate Critical"},
+				{Name: "Memory", Clause: "If memory/memory-free.value < 1000 for 5 minutes Then escalate warning"},
+				{Name: "Disk", Metric: "df-root/df_complex-free", ValueType: "value", Condition: "<", Threshold: "1000",
+					Duration: 5, Escalation: "warning"},
+				{Name: "Scale", Clause: "If cpu-0/cpu-idle.value > 80 for 5 minutes Then shrink my_app_name"},
+			}
+			Expect(UndefinedEscalations(alerts, EscalationNames(escalations))).To(Equal([]string{"warning"}))
+		})
+	})
+
+	It("rejects unknown fields", func() {
+		_, err := ParseEscalations(strings.NewReader(`Escalations:
+- Name: critical
+  Actions:
+  - Page: oncall
+`))
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("reports invalid escalations", func() {
+		escalations := &Escalations{Escalations: []*Escalation{
+			{Actions: []*EscalationAction{{Delay: 1}}},
+			{Name: "critical"},
+			{Name: "Critical ", Actions: []*EscalationAction{
+				{Email: "oncall"},
+				{Email: "oncall@example.com", Delay: 5},
+				{Delay: -1},
+			}},
+		}}
+		errors := ValidateEscalations(escalations)
+		Expect(errors).To(HaveLen(6))
+		Expect(errors[0]).To(MatchError("Escalation 0 error: Name field must be present"))
+		Expect(errors[1]).To(MatchError(ContainSubstring("must have at least one action")))
+		Expect(errors[2]).To(MatchError(ContainSubstring("is used by more than one escalation")))
+		Expect(errors[3]).To(MatchError(ContainSubstring("is not an email address")))
+		Expect(errors[4]).To(MatchError(ContainSubstring("must have exactly one of")))
+		Expect(errors[5]).To(MatchError(ContainSubstring("Delay must be a positive number")))
+	})
+})
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/rightscale/rsc/cm15"
+	"github.com/rightscale/rsc/rsapi"
+	"gopkg.in/yaml.v2"
+)
+
+// ExportIndexFile is the name of the index file written to the top of an account export.
+const ExportIndexFile = "index.yml"
+
+// ExportIndex maps the hrefs of everything exported from an account to the path it was written to relative to the
+// export directory.
+type ExportIndex struct {
+	ServerTemplates  map[string]string `yaml:"ServerTemplates,omitempty"`
+	RightScripts     map[string]string `yaml:"RightScripts,omitempty"`
+	MultiCloudImages map[string]string `yaml:"MultiCloudImages,omitempty"`
+}
+
+// ExportDir returns the directory within base to export an object to: base itself for a HEAD revision or an r<N>
+// subdirectory for revision N. If another object with the same name was already exported there, a subdirectory named
+// by the ID of the object is used instead. taken records the names used so far in each directory.
+func ExportDir(base, name string, revision int, id string, taken map[string]bool) string {
+	dir := base
+	if revision != 0 {
+		dir = filepath.Join(base, fmt.Sprintf("r%d", revision))
+	}
+	key := strings.ToLower(filepath.Join(dir, cleanFileName(name)))
+	if taken[key] {
+		dir = filepath.Join(base, id)
+		key = strings.ToLower(filepath.Join(dir, cleanFileName(name)))
+	}
+	taken[key] = true
+	return dir
+}
+
+// accountExport writes every ServerTemplate, RightScript and MultiCloudImage in the account to dir along with an
+// index of where each was written. RightScripts shared between ServerTemplates are only downloaded once and share
+// their attachments directory so identical attachments are only written once as well.
+func accountExport(dir string, revisions bool) {
+	client, _ := Config.Account.Client15()
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		fatalError("Error creating directory: %s", err.Error())
+	}
+	index := ExportIndex{
+		ServerTemplates:  make(map[string]string),
+		RightScripts:     make(map[string]string),
+		MultiCloudImages: make(map[string]string),
+	}
+	taken := make(map[string]bool)
+	relative := func(file string) string {
+		rel, err := filepath.Rel(dir, file)
+		if err != nil {
+			return file
+		}
+		return filepath.ToSlash(rel)
+	}
+
+	scripts := make(map[string]string)
+	downloadScript := func(rsHref, stDir string) string {
+		if downloadedTo, ok := scripts[rsHref]; ok {
+			return downloadedTo
+		}
+		rs, err := client.RightScriptLocator(rsHref).Show(rsapi.APIParams{})
+		if err != nil {
+			fatalError("Could not get RightScript %s: %s\n", rsHref, err.Error())
+		}
+		scriptDir := ExportDir(filepath.Join(dir, "right_scripts"), rs.Name, rs.Revision, path.Base(rsHref), taken)
+		if err := os.MkdirAll(scriptDir, 0755); err != nil {
+			fatalError("Error creating directory: %s", err.Error())
+		}
+		downloadedTo := rightScriptDownload(rsHref, scriptDir)
+		scripts[rsHref] = downloadedTo
+		index.RightScripts[rsHref] = relative(downloadedTo)
+		return downloadedTo
+	}
+
+	//-------------------------------------
+	// ServerTemplates
+	//-------------------------------------
+	sts, err := client.ServerTemplateLocator("/api/server_templates").Index(rsapi.APIParams{})
+	if err != nil {
+		fatalError("Could not list ServerTemplates: %s", err.Error())
+	}
+	sort.SliceStable(sts, func(i, j int) bool {
+		return sts[i].Name < sts[j].Name || (sts[i].Name == sts[j].Name && sts[i].Revision < sts[j].Revision)
+	})
+	for _, st := range sts {
+		if st.Revision != 0 && !revisions {
+			continue
+		}
+		href := getLink(st.Links, "self")
+		recipes, err := hasRecipes(st)
+		if err != nil {
+			fatalError("Could not find attached RightScripts for ServerTemplate %s: %s", href, err.Error())
+		}
+		if recipes {
+			fmt.Printf("WARNING: Skipping ServerTemplate '%s' revision %s, it has attached cookbook recipes, which are not supported by this tool.\n",
+				st.Name, formatRev(st.Revision))
+			continue
+		}
+		stDir := ExportDir(filepath.Join(dir, "server_templates"), st.Name, st.Revision, path.Base(href), taken)
+		if err := os.MkdirAll(stDir, 0755); err != nil {
+			fatalError("Error creating directory: %s", err.Error())
+		}
+		downloadedTo := downloadServerTemplate(href, stDir, false, false, false, ClauseAlertFormat, SingleLayout, eachRightScript(downloadScript))
+		index.ServerTemplates[href] = relative(downloadedTo)
+	}
+
+	//-------------------------------------
+	// RightScripts not attached to any ServerTemplate
+	//-------------------------------------
+	rss, err := client.RightScriptLocator("/api/right_scripts").Index(rsapi.APIParams{})
+	if err != nil {
+		fatalError("Could not list RightScripts: %s", err.Error())
+	}
+	for _, rs := range rss {
+		if rs.Revision != 0 && !revisions {
+			continue
+		}
+		downloadScript(getLink(rs.Links, "self"), "")
+	}
+
+	//-------------------------------------
+	// MultiCloudImages
+	//-------------------------------------
+	mcis, err := client.MultiCloudImageLocator("/api/multi_cloud_images").Index(rsapi.APIParams{})
+	if err != nil {
+		fatalError("Could not list MultiCloudImages: %s", err.Error())
+	}
+	for _, mci := range mcis {
+		if mci.Revision != 0 && !revisions {
+			continue
+		}
+		href := getLink(mci.Links, "self")
+		mciImage, err := downloadMultiCloudImageSettings(mci)
+		if err != nil {
+			fatalError("%s", err.Error())
+		}
+		if mciImage == nil {
+			fmt.Printf("WARNING: skipping MCI '%s', contains no usable settings\n", mci.Name)
+			continue
+		}
+		mciDir := ExportDir(filepath.Join(dir, "multi_cloud_images"), mci.Name, mci.Revision, path.Base(href), taken)
+		if err := os.MkdirAll(mciDir, 0755); err != nil {
+			fatalError("Error creating directory: %s", err.Error())
+		}
+		downloadTo := filepath.Join(mciDir, cleanFileName(mci.Name)+".yml")
+		fmt.Printf("Downloading '%s' to '%s'\n", mci.Name, downloadTo)
+		bytes, err := yaml.Marshal(mciImage)
+		if err != nil {
+			fatalError("Creating yaml failed: %s", err.Error())
+		}
+		if err := ioutil.WriteFile(downloadTo, bytes, 0644); err != nil {
+			fatalError("Could not create file: %s", err.Error())
+		}
+		index.MultiCloudImages[href] = relative(downloadTo)
+	}
+
+	indexFile := filepath.Join(dir, ExportIndexFile)
+	bytes, err := yaml.Marshal(&index)
+	if err != nil {
+		fatalError("Creating yaml failed: %s", err.Error())
+	}
+	if err := ioutil.WriteFile(indexFile, bytes, 0644); err != nil {
+		fatalError("Could not create file: %s", err.Error())
+	}
+	fmt.Printf("Exported %d ServerTemplates, %d RightScripts and %d MultiCloudImages to '%s', see '%s'\n",
+		len(index.ServerTemplates), len(index.RightScripts), len(index.MultiCloudImages), dir, indexFile)
+}
+
+// hasRecipes returns true if a ServerTemplate has cookbook recipes attached instead of RightScripts.
+func hasRecipes(st *cm15.ServerTemplate) (bool, error) {
+	client, _ := Config.Account.Client15()
+
+	rbs, err := client.RunnableBindingLocator(getLink(st.Links, "runnable_bindings")).Index(rsapi.APIParams{})
+	if err != nil {
+		return false, err
+	}
+	for _, rb := range rbs {
+		if getLink(rb.Links, "right_script") == "" {
+			return true, nil
+		}
+	}
+	return false, nil
+}
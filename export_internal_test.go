@@ -0,0 +1,101 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/rightscale/rsc/cm15"
+	"github.com/rightscale/rsc/httpclient"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Exporting an account", func() {
+	var (
+		server   *httptest.Server
+		dir      string
+		account  *Account
+		insecure bool
+	)
+
+	BeforeEach(func() {
+		responses := map[string]string{
+			"/api/server_templates": `[
+				{"name": "Chef Base", "revision": 0, "links": [
+					{"rel": "self", "href": "/api/server_templates/1"},
+					{"rel": "runnable_bindings", "href": "/api/server_templates/1/runnable_bindings"}]}]`,
+			"/api/server_templates/1/runnable_bindings": `[
+				{"recipe": "app::install", "sequence": "boot", "position": 1, "links": [
+					{"rel": "self", "href": "/api/server_templates/1/runnable_bindings/1"}]}]`,
+			"/api/right_scripts": `[
+				{"name": "Install App", "revision": 0, "links": [{"rel": "self", "href": "/api/right_scripts/2"}]},
+				{"name": "Install App", "revision": 3, "links": [{"rel": "self", "href": "/api/right_scripts/3"}]}]`,
+			"/api/right_scripts/2": `{"name": "Install App", "revision": 0, "links": [
+				{"rel": "self", "href": "/api/right_scripts/2"}]}`,
+			"/api/right_scripts/2/source":      "#!/bin/bash\necho installing\n",
+			"/api/right_scripts/2/attachments": `[]`,
+			"/api/right_scripts/3": `{"name": "Install App", "revision": 3, "links": [
+				{"rel": "self", "href": "/api/right_scripts/3"}]}`,
+			"/api/right_scripts/3/source":      "#!/bin/bash\necho installing\n",
+			"/api/right_scripts/3/attachments": `[]`,
+			"/api/multi_cloud_images":          `[]`,
+		}
+		server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			response, ok := responses[r.URL.Path]
+			if !ok {
+				http.NotFound(w, r)
+				return
+			}
+			if strings.HasSuffix(r.URL.Path, "/source") {
+				w.Header().Set("Content-Type", "text/plain")
+			} else {
+				w.Header().Set("Content-Type", "application/json")
+			}
+			fmt.Fprint(w, response)
+		}))
+		insecure = httpclient.Insecure
+		httpclient.Insecure = true
+		account = Config.Account
+		Config.Account = &Account{client15: cm15.New(strings.TrimPrefix(server.URL, "http://"), nil)}
+
+		var err error
+		dir, err = ioutil.TempDir("", "right_st_export")
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		Config.Account = account
+		httpclient.Insecure = insecure
+		server.Close()
+		os.RemoveAll(dir)
+	})
+
+	It("skips ServerTemplates with cookbook recipes and indexes what was exported", func() {
+		accountExport(dir, false)
+
+		Expect(filepath.Join(dir, "server_templates")).NotTo(BeADirectory())
+		Expect(filepath.Join(dir, "right_scripts", "Install_App.sh")).To(BeARegularFile())
+		index, err := ioutil.ReadFile(filepath.Join(dir, ExportIndexFile))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(index).To(MatchYAML(`RightScripts:
+  /api/right_scripts/2: right_scripts/Install_App.sh
+`))
+	})
+
+	It("indexes each revision in its own directory", func() {
+		accountExport(dir, true)
+
+		index, err := ioutil.ReadFile(filepath.Join(dir, ExportIndexFile))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(index).To(MatchYAML(`RightScripts:
+  /api/right_scripts/2: right_scripts/Install_App.sh
+  /api/right_scripts/3: right_scripts/r3/Install_App.sh
+`))
+	})
+})
@@ -0,0 +1,22 @@
+package main_test
+
+import (
+	"path/filepath"
+
+	. "github.com/rightscale/right_st"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Account export", func() {
+	It("exports each object to a unique directory", func() {
+		taken := make(map[string]bool)
+		base := filepath.Join("export", "right_scripts")
+		Expect(ExportDir(base, "Install App", 0, "1", taken)).To(Equal(base))
+		Expect(ExportDir(base, "Install App", 3, "2", taken)).To(Equal(filepath.Join(base, "r3")))
+		Expect(ExportDir(base, "Configure App", 3, "3", taken)).To(Equal(filepath.Join(base, "r3")))
+		Expect(ExportDir(base, "install app", 0, "4", taken)).To(Equal(filepath.Join(base, "4")))
+		Expect(ExportDir(base, "Install App", 3, "5", taken)).To(Equal(filepath.Join(base, "5")))
+	})
+})
@@ -0,0 +1,257 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/rightscale/rsc/cm15"
+	"github.com/rightscale/rsc/rsapi"
+)
+
+// fingerprintTag records what a ServerTemplate or RightScript looked like right after right_st last uploaded it so
+// changes made since then, such as in the dashboard, are not silently overwritten by the next upload.
+const fingerprintTag = tagNamespace + "fingerprint"
+
+// forceOverwrite is set by --force to upload over changes made since the last upload.
+var forceOverwrite bool
+
+// forceOverwriteFlag is the flag which sets forceOverwrite for the command being run, since rightscript upload already
+// uses --force for uploading scripts without metadata.
+var forceOverwriteFlag = "--force"
+
+// Fingerprint is a hash of each part of a ServerTemplate or RightScript keyed by the name of the part.
+type Fingerprint map[string]string
+
+// String returns a Fingerprint in the form it is recorded in its tag: part:hash pairs separated by commas.
+func (fingerprint Fingerprint) String() string {
+	var parts []string
+	for part, hash := range fingerprint {
+		parts = append(parts, part+":"+hash)
+	}
+	sort.Strings(parts)
+	return strings.Join(parts, ",")
+}
+
+// ParseFingerprint parses a Fingerprint from the form it is recorded in its tag.
+func ParseFingerprint(value string) (Fingerprint, error) {
+	fingerprint := make(Fingerprint)
+	for _, pair := range strings.Split(value, ",") {
+		fields := strings.SplitN(pair, ":", 2)
+		if len(fields) != 2 || fields[0] == "" || fields[1] == "" {
+			return nil, fmt.Errorf("invalid fingerprint %s", value)
+		}
+		fingerprint[fields[0]] = fields[1]
+	}
+	return fingerprint, nil
+}
+
+// Changed returns the parts which differ between two Fingerprints in sorted order.
+func (fingerprint Fingerprint) Changed(other Fingerprint) []string {
+	var changed []string
+	for part, hash := range fingerprint {
+		if other[part] != hash {
+			changed = append(changed, part)
+		}
+	}
+	for part := range other {
+		if _, ok := fingerprint[part]; !ok {
+			changed = append(changed, part)
+		}
+	}
+	sort.Strings(changed)
+	return changed
+}
+
+// hashOf hashes a list of values, short enough to keep a whole Fingerprint within the length limit of a tag.
+func hashOf(values ...string) string {
+	hash := sha256.New()
+	for _, value := range values {
+		hash.Write([]byte(value))
+		hash.Write([]byte{0})
+	}
+	return hex.EncodeToString(hash.Sum(nil))[:12]
+}
+
+// serverTemplateFingerprint fingerprints the description, inputs, attached RightScripts, MultiCloudImages and Alerts of
+// a ServerTemplate.
+func serverTemplateFingerprint(st *cm15.ServerTemplate) (Fingerprint, error) {
+	client, _ := Config.Account.Client15()
+	href := getLink(st.Links, "self")
+
+	inputs, err := client.InputLocator(href + "/inputs").Index(rsapi.APIParams{"view": "inputs_2_0"})
+	if err != nil {
+		return nil, fmt.Errorf("Could not find inputs for ServerTemplate '%s': %s", st.Name, err.Error())
+	}
+	var inputValues []string
+	for _, input := range inputs {
+		// inherited values come from the RightScripts which are fingerprinted themselves
+		if input.Value != "inherit" {
+			inputValues = append(inputValues, input.Name+"="+input.Value)
+		}
+	}
+	sort.Strings(inputValues)
+
+	rbs, err := client.RunnableBindingLocator(getLink(st.Links, "runnable_bindings")).Index(rsapi.APIParams{})
+	if err != nil {
+		return nil, fmt.Errorf("Could not find attached RightScripts for ServerTemplate '%s': %s", st.Name, err.Error())
+	}
+	var bindings []string
+	for _, rb := range rbs {
+		bindings = append(bindings, fmt.Sprintf("%s %04d %s%s", rb.Sequence, rb.Position, getLink(rb.Links, "right_script"), rb.Recipe))
+	}
+	sort.Strings(bindings)
+
+	stMcis, err := client.ServerTemplateMultiCloudImageLocator("/api/server_template_multi_cloud_images").Index(
+		rsapi.APIParams{"filter": []string{"server_template_href==" + href}})
+	if err != nil {
+		return nil, fmt.Errorf("Could not find MCIs for ServerTemplate '%s': %s", st.Name, err.Error())
+	}
+	var mcis []string
+	for _, stMci := range stMcis {
+		mcis = append(mcis, fmt.Sprintf("%s %t", getLink(stMci.Links, "multi_cloud_image"), stMci.IsDefault))
+	}
+	sort.Strings(mcis)
+
+	alertSpecs, err := client.AlertSpecLocator(getLink(st.Links, "alert_specs")).Index(rsapi.APIParams{})
+	if err != nil {
+		return nil, fmt.Errorf("Could not find Alerts for ServerTemplate '%s': %s", st.Name, err.Error())
+	}
+	var alerts []string
+	for _, alert := range alertSpecs {
+		alerts = append(alerts, strings.Join([]string{alert.Name, alert.Description, alert.File, alert.Variable,
+			alert.Condition, alert.Threshold, fmt.Sprint(alert.Duration), alert.EscalationName, alert.VoteTag,
+			alert.VoteType}, "\x00"))
+	}
+	sort.Strings(alerts)
+
+	return Fingerprint{
+		"description":  hashOf(st.Description),
+		"inputs":       hashOf(inputValues...),
+		"rightscripts": hashOf(bindings...),
+		"mcis":         hashOf(mcis...),
+		"alerts":       hashOf(alerts...),
+	}, nil
+}
+
+// rightScriptFingerprint fingerprints the metadata, source and attachments of a RightScript.
+func rightScriptFingerprint(href string) (Fingerprint, error) {
+	client, _ := Config.Account.Client15()
+	loc := client.RightScriptLocator(href)
+
+	rs, err := loc.Show(rsapi.APIParams{})
+	if err != nil {
+		return nil, fmt.Errorf("Could not find RightScript %s: %s", href, err.Error())
+	}
+	source, err := getSource(loc)
+	if err != nil {
+		return nil, fmt.Errorf("Could not get source for RightScript '%s': %s", rs.Name, err.Error())
+	}
+	attachments, err := client.RightScriptAttachmentLocator(href + "/attachments").Index(rsapi.APIParams{})
+	if err != nil {
+		return nil, fmt.Errorf("Could not find attachments for RightScript '%s': %s", rs.Name, err.Error())
+	}
+	var digests []string
+	for _, a := range attachments {
+		digests = append(digests, a.Filename+" "+a.Digest)
+	}
+	sort.Strings(digests)
+
+	return Fingerprint{
+		"metadata":    hashOf(rs.Description, rs.Packages),
+		"source":      hashOf(string(source)),
+		"attachments": hashOf(digests...),
+	}, nil
+}
+
+// localRightScriptFingerprint fingerprints a RightScript on disk the same way rightScriptFingerprint fingerprints one
+// in RightScale, so the two are equal when pushing it would not change anything.
+func localRightScriptFingerprint(r *RightScript) (Fingerprint, error) {
+	source, err := ioutil.ReadFile(r.Path)
+	if err != nil {
+		return nil, err
+	}
+	var digests []string
+	for _, a := range r.Metadata.Attachments {
+		md5, err := fmd5sum(filepath.Join(filepath.Dir(r.Path), "attachments", a))
+		if err != nil {
+			return nil, err
+		}
+		digests = append(digests, path.Base(a)+" "+md5)
+	}
+	sort.Strings(digests)
+
+	return Fingerprint{
+		"metadata":    hashOf(r.Metadata.Description, r.Metadata.Packages),
+		"source":      hashOf(string(source)),
+		"attachments": hashOf(digests...),
+	}, nil
+}
+
+// checkFingerprint returns a conflict error if something changed since right_st last uploaded it, unless --force was
+// given. Nothing is checked for something which has never been uploaded with a fingerprint.
+func checkFingerprint(resourceType, name, href string, fingerprint func() (Fingerprint, error)) error {
+	if forceOverwrite {
+		return nil
+	}
+	tags, err := getTagsByHref(href)
+	if err != nil {
+		return err
+	}
+	value, ok := tagValue(tags, fingerprintTag)
+	if !ok {
+		return nil
+	}
+	recorded, err := ParseFingerprint(value)
+	if err != nil {
+		fmt.Printf("WARNING: Ignoring %s '%s' fingerprint: %s\n", resourceTypeNames[resourceType], name, err.Error())
+		return nil
+	}
+	current, err := fingerprint()
+	if err != nil {
+		return err
+	}
+	changed := recorded.Changed(current)
+	if len(changed) == 0 {
+		return nil
+	}
+	lastUpload := ""
+	if owner, ok := OwnerFromTags(tags); ok {
+		lastUpload = fmt.Sprintf(" from %s", owner.Path)
+		if owner.Commit != "" {
+			lastUpload += fmt.Sprintf(" at commit %s", owner.Commit)
+		}
+	}
+	return fmt.Errorf("%s '%s' HREF %s was changed since it was last uploaded%s (changed: %s), review the changes and use %s to overwrite them",
+		resourceTypeNames[resourceType], name, href, lastUpload, strings.Join(changed, ", "), forceOverwriteFlag)
+}
+
+// recordFingerprint records what something looks like right after uploading it, replacing any earlier fingerprint.
+func recordFingerprint(href string, fingerprint Fingerprint) error {
+	client, _ := Config.Account.Client15()
+
+	tags, err := getTagsByHref(href)
+	if err != nil {
+		return err
+	}
+	tag := fmt.Sprintf("%s=%s", fingerprintTag, fingerprint)
+	var toDelete []string
+	for _, t := range tags {
+		if strings.HasPrefix(t, fingerprintTag+"=") && t != tag {
+			toDelete = append(toDelete, t)
+		}
+	}
+	if len(toDelete) > 0 {
+		tagsLoc := client.TagLocator("/api/tags/multi_delete")
+		if err := tagsLoc.MultiDelete([]string{href}, toDelete); err != nil {
+			return err
+		}
+	}
+	tagsLoc := client.TagLocator("/api/tags/multi_add")
+	return tagsLoc.MultiAdd([]string{href}, []string{tag})
+}
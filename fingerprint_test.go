@@ -0,0 +1,44 @@
+package main_test
+
+import (
+	. "github.com/rightscale/right_st"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Fingerprint", func() {
+	fingerprint := Fingerprint{
+		"source":      "0123456789ab",
+		"attachments": "ba9876543210",
+		"metadata":    "aaaaaaaaaaaa",
+	}
+
+	It("is written in a stable order", func() {
+		Expect(fingerprint.String()).To(Equal("attachments:ba9876543210,metadata:aaaaaaaaaaaa,source:0123456789ab"))
+	})
+
+	It("is parsed back", func() {
+		parsed, err := ParseFingerprint(fingerprint.String())
+		Expect(err).NotTo(HaveOccurred())
+		Expect(parsed).To(Equal(fingerprint))
+	})
+
+	It("fails to parse something else", func() {
+		_, err := ParseFingerprint("source")
+		Expect(err).To(MatchError("invalid fingerprint source"))
+	})
+
+	It("has no changes from itself", func() {
+		Expect(fingerprint.Changed(fingerprint)).To(BeEmpty())
+	})
+
+	It("reports changed, added and removed parts", func() {
+		other := Fingerprint{
+			"source":   "cccccccccccc",
+			"inputs":   "dddddddddddd",
+			"metadata": "aaaaaaaaaaaa",
+		}
+		Expect(fingerprint.Changed(other)).To(Equal([]string{"attachments", "inputs", "source"}))
+	})
+})
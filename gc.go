@@ -0,0 +1,357 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/rightscale/rsc/cm15"
+	"github.com/rightscale/rsc/rsapi"
+	"gopkg.in/yaml.v2"
+)
+
+// accountObject is a ServerTemplate, MultiCloudImage or RightScript in the account which may be deleted.
+type accountObject struct {
+	resourceType string
+	name         string
+	href         string
+	updatedAt    time.Time
+}
+
+// resourceTypeNames are the names of the resource types for showing to users.
+var resourceTypeNames = map[string]string{
+	"server_templates":   "ServerTemplate",
+	"multi_cloud_images": "MultiCloudImage",
+	"right_scripts":      "RightScript",
+}
+
+// ReferencedNames returns the names of the ServerTemplates, RightScripts and MultiCloudImages defined or referred to
+// by ServerTemplate YAML files and RightScript files, keyed by resource type. Only references to HEAD revisions are
+// returned since those are the only ones which could be garbage collected. Files which are neither are ignored.
+func ReferencedNames(files []string) (map[string][]string, error) {
+	names := map[string]map[string]bool{
+		"server_templates":   make(map[string]bool),
+		"right_scripts":      make(map[string]bool),
+		"multi_cloud_images": make(map[string]bool),
+	}
+	for _, file := range files {
+		if isDirectory(file) {
+			continue
+		}
+		ext := strings.ToLower(filepath.Ext(file))
+		if ext == ".yml" || ext == ".yaml" {
+			st, err := loadServerTemplate(file)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %s", file, err.Error())
+			}
+			if st != nil {
+				names["server_templates"][st.Name] = true
+				for _, scripts := range st.RightScripts {
+					for _, rs := range scripts {
+						if rs.Type == LocalRightScript {
+							name, err := rightScriptName(filepath.Join(filepath.Dir(file), rs.Path))
+							if err != nil {
+								return nil, fmt.Errorf("%s: %s", file, err.Error())
+							}
+							names["right_scripts"][name] = true
+						} else if rs.Publisher == "" && rs.Revision == 0 {
+							names["right_scripts"][rs.Name] = true
+						}
+					}
+				}
+				mcis, err := ExpandMultiCloudImages(filepath.Dir(file), st.MultiCloudImages)
+				if err != nil {
+					return nil, fmt.Errorf("%s: %s", file, err.Error())
+				}
+				for _, mci := range mcis {
+					if mci.Publisher == "" && mci.Revision == 0 && mci.Name != "" {
+						names["multi_cloud_images"][mci.Name] = true
+					}
+				}
+				continue
+			}
+			if mci := loadMultiCloudImage(file); mci != nil {
+				names["multi_cloud_images"][mci.Name] = true
+				continue
+			}
+		}
+		name, err := rightScriptName(file)
+		if err == nil && name != "" {
+			names["right_scripts"][name] = true
+		}
+	}
+
+	referenced := make(map[string][]string)
+	for resourceType, set := range names {
+		for name := range set {
+			referenced[resourceType] = append(referenced[resourceType], name)
+		}
+		sort.Strings(referenced[resourceType])
+	}
+	return referenced, nil
+}
+
+// loadServerTemplate parses a ServerTemplate YAML file, returning nil if it is some other kind of YAML file.
+func loadServerTemplate(file string) (*ServerTemplate, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	st, err := ParseServerTemplate(f)
+	if err != nil || st.Name == "" {
+		return nil, nil
+	}
+	return st, nil
+}
+
+// loadMultiCloudImage parses a MultiCloudImage YAML file, returning nil if it is some other kind of file.
+func loadMultiCloudImage(file string) *MultiCloudImage {
+	bytes, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil
+	}
+	var mci MultiCloudImage
+	if err := yaml.UnmarshalStrict(bytes, &mci); err != nil || mci.Name == "" || len(mci.Settings) == 0 && mci.Matrix == nil {
+		return nil
+	}
+	return &mci
+}
+
+// rightScriptName returns the name of the RightScript in a file from its metadata.
+func rightScriptName(file string) (string, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	metadata, err := ParseRightScriptMetadata(f)
+	if err != nil {
+		return "", err
+	}
+	if metadata == nil {
+		return "", nil
+	}
+	return metadata.Name, nil
+}
+
+// findUnreferenced finds the HEAD RightScripts and MultiCloudImages in the account which are not attached to any
+// ServerTemplate and the HEAD ServerTemplates with one of the prefixes. Anything named by the local files is kept,
+// and ServerTemplates which are found are not counted as referring to anything. Only objects uploaded from the current
+// repository are found unless unowned is set.
+func findUnreferenced(files []string, prefixes []string, unowned bool) ([]*accountObject, error) {
+	client, _ := Config.Account.Client15()
+
+	referencedNames, err := ReferencedNames(files)
+	if err != nil {
+		return nil, err
+	}
+	keep := make(map[string]bool)
+	for resourceType, names := range referencedNames {
+		for _, name := range names {
+			hrefs, err := paramToHrefs(resourceType, name, 0)
+			if err != nil {
+				return nil, err
+			}
+			for _, href := range hrefs {
+				keep[href] = true
+			}
+		}
+	}
+
+	repo := currentRepository()
+	skipped := 0
+	var unreferenced []*accountObject
+
+	sts, err := client.ServerTemplateLocator("/api/server_templates").Index(rsapi.APIParams{})
+	if err != nil {
+		return nil, fmt.Errorf("Could not list ServerTemplates: %s", err.Error())
+	}
+	var tags map[string][]string
+	if len(prefixes) > 0 {
+		var hrefs []string
+		for _, st := range sts {
+			if st.Revision == 0 {
+				hrefs = append(hrefs, getLink(st.Links, "self"))
+			}
+		}
+		if tags, err = getTagsByHrefs(hrefs); err != nil {
+			return nil, fmt.Errorf("Could not get tags: %s", err.Error())
+		}
+	}
+	var referringSts []*cm15.ServerTemplate
+	for _, st := range sts {
+		href := getLink(st.Links, "self")
+		_, prefixed := UploadPrefix(st.Name, tags[href], prefixes, nil)
+		if st.Revision == 0 && prefixed && !keep[href] {
+			if unowned || OwnedBy(tags[href], repo) {
+				unreferenced = append(unreferenced, &accountObject{resourceType: "server_templates", name: st.Name, href: href})
+				continue
+			}
+			skipped++
+		}
+		referringSts = append(referringSts, st)
+	}
+
+	if err := referencedBy(referringSts, keep); err != nil {
+		return nil, err
+	}
+
+	mcis, err := client.MultiCloudImageLocator("/api/multi_cloud_images").Index(rsapi.APIParams{})
+	if err != nil {
+		return nil, fmt.Errorf("Could not list MultiCloudImages: %s", err.Error())
+	}
+	for _, mci := range mcis {
+		href := getLink(mci.Links, "self")
+		if mci.Revision == 0 && !keep[href] {
+			unreferenced = append(unreferenced, &accountObject{resourceType: "multi_cloud_images", name: mci.Name, href: href})
+		}
+	}
+
+	rss, err := client.RightScriptLocator("/api/right_scripts").Index(rsapi.APIParams{})
+	if err != nil {
+		return nil, fmt.Errorf("Could not list RightScripts: %s", err.Error())
+	}
+	for _, rs := range rss {
+		href := getLink(rs.Links, "self")
+		if rs.Revision != 0 || keep[href] {
+			continue
+		}
+		// Only ever consider our own RightScripts
+		accountId, err := rightScriptAccountId(rs)
+		if err != nil {
+			return nil, err
+		}
+		if accountId != Config.Account.Id {
+			continue
+		}
+		object := &accountObject{resourceType: "right_scripts", name: rs.Name, href: href}
+		if rs.UpdatedAt != nil {
+			object.updatedAt = rs.UpdatedAt.Time
+		}
+		unreferenced = append(unreferenced, object)
+	}
+
+	if !unowned {
+		var hrefs []string
+		for _, object := range unreferenced {
+			if object.resourceType != "server_templates" {
+				hrefs = append(hrefs, object.href)
+			}
+		}
+		tags, err := getTagsByHrefs(hrefs)
+		if err != nil {
+			return nil, fmt.Errorf("Could not get tags: %s", err.Error())
+		}
+		var owned []*accountObject
+		for _, object := range unreferenced {
+			if object.resourceType == "server_templates" || OwnedBy(tags[object.href], repo) {
+				owned = append(owned, object)
+			} else {
+				skipped++
+			}
+		}
+		unreferenced = owned
+	}
+	skippedUnowned(skipped, repo)
+
+	return unreferenced, nil
+}
+
+// referencedBy adds the hrefs of the RightScripts and MultiCloudImages attached to ServerTemplates to referenced.
+func referencedBy(sts []*cm15.ServerTemplate, referenced map[string]bool) error {
+	client, _ := Config.Account.Client15()
+
+	for _, st := range sts {
+		rbs, err := client.RunnableBindingLocator(getLink(st.Links, "runnable_bindings")).Index(rsapi.APIParams{})
+		if err != nil {
+			return fmt.Errorf("Could not find attached RightScripts for ServerTemplate '%s': %s", st.Name, err.Error())
+		}
+		for _, rb := range rbs {
+			referenced[getLink(rb.Links, "right_script")] = true
+		}
+		mcis, err := client.MultiCloudImageLocator(getLink(st.Links, "multi_cloud_images")).Index(rsapi.APIParams{})
+		if err != nil {
+			return fmt.Errorf("Could not find MCIs for ServerTemplate '%s': %s", st.Name, err.Error())
+		}
+		for _, mci := range mcis {
+			referenced[getLink(mci.Links, "self")] = true
+		}
+	}
+	return nil
+}
+
+// showObjects prints a table of objects which may be deleted.
+func showObjects(objects []*accountObject, output io.Writer) {
+	tw := tabwriter.NewWriter(output, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "TYPE\tNAME\tHREF\tLAST MODIFIED")
+	for _, object := range objects {
+		updatedAt := "unknown"
+		if !object.updatedAt.IsZero() {
+			updatedAt = object.updatedAt.Format("2006-01-02 15:04:05 -0700")
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", resourceTypeNames[object.resourceType], object.name, object.href, updatedAt)
+	}
+	tw.Flush()
+}
+
+// confirm asks a yes or no question and returns true if the answer is yes.
+func confirm(question string, input io.Reader, output io.Writer) bool {
+	fmt.Fprintf(output, "%s [y/N]: ", question)
+	var answer string
+	fmt.Fscanln(input, &answer)
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes"
+}
+
+// deleteObjects deletes ServerTemplates before MultiCloudImages and RightScripts since ServerTemplates use them.
+func deleteObjects(objects []*accountObject) error {
+	client, _ := Config.Account.Client15()
+
+	for _, resourceType := range []string{"server_templates", "multi_cloud_images", "right_scripts"} {
+		for _, object := range objects {
+			if object.resourceType != resourceType {
+				continue
+			}
+			fmt.Printf("Deleting %s '%s' HREF %s\n", resourceTypeNames[resourceType], object.name, object.href)
+			var err error
+			switch resourceType {
+			case "server_templates":
+				err = client.ServerTemplateLocator(object.href).Destroy()
+			case "multi_cloud_images":
+				err = client.MultiCloudImageLocator(object.href).Destroy()
+			case "right_scripts":
+				err = client.RightScriptLocator(object.href).Destroy()
+			}
+			if err != nil {
+				return fmt.Errorf("Failed to delete %s '%s': %s", resourceTypeNames[resourceType], object.name, err.Error())
+			}
+		}
+	}
+	return nil
+}
+
+func accountGc(files []string, prefixes []string, unowned, yes bool) {
+	unreferenced, err := findUnreferenced(files, prefixes, unowned)
+	if err != nil {
+		fatalError("%s", err.Error())
+	}
+	if len(unreferenced) == 0 {
+		fmt.Println("Nothing to delete")
+		return
+	}
+	showObjects(unreferenced, os.Stdout)
+	if !yes && !confirm(fmt.Sprintf("Delete these %d objects?", len(unreferenced)), os.Stdin, os.Stdout) {
+		fmt.Println("Not deleting anything")
+		return
+	}
+	if err := deleteObjects(unreferenced); err != nil {
+		fatalError("%s", err.Error())
+	}
+}
@@ -0,0 +1,90 @@
+package main_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	. "github.com/rightscale/right_st"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Account garbage collection", func() {
+	var tempDir string
+
+	BeforeEach(func() {
+		var err error
+		tempDir, err = ioutil.TempDir("", "gc")
+		if err != nil {
+			panic(err)
+		}
+		files := map[string]string{
+			"st.yml": `Name: My App
+Description: The app
+Inputs: {}
+RightScripts:
+  Boot:
+  - install.sh
+  - Name: Shared Script
+  - Name: Published Script
+    Revision: 3
+    Publisher: RightScale
+MultiCloudImages:
+- Name: Ubuntu
+  Revision: head
+- Name: CentOS
+  Revision: 4
+- mci.yml
+Alerts: []
+`,
+			"install.sh": `#!/bin/bash
+# ---
+# RightScript Name: Install App
+# ...
+`,
+			"mci.yml": `Name: Managed Image
+Settings:
+- Cloud: EC2 us-east-1
+  Instance Type: m3.medium
+  Image: ami-12345678
+`,
+			"other.yml": `Name: Standalone Image
+Matrix:
+  Images:
+    EC2 us-east-1: ami-12345678
+  Instance Type: m3.medium
+`,
+			"tool.sh": `#!/bin/bash
+# ---
+# RightScript Name: Standalone Tool
+# ...
+`,
+			"README": "Not a RightScript\n",
+		}
+		for name, content := range files {
+			if err := ioutil.WriteFile(filepath.Join(tempDir, name), []byte(content), 0644); err != nil {
+				panic(err)
+			}
+		}
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(tempDir)
+	})
+
+	It("finds the names of everything the local files define or refer to", func() {
+		var files []string
+		for _, name := range []string{"st.yml", "install.sh", "mci.yml", "other.yml", "tool.sh", "README"} {
+			files = append(files, filepath.Join(tempDir, name))
+		}
+		names, err := ReferencedNames(append(files, tempDir))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(names).To(Equal(map[string][]string{
+			"server_templates":   {"My App"},
+			"right_scripts":      {"Install App", "Shared Script", "Standalone Tool"},
+			"multi_cloud_images": {"Managed Image", "Standalone Image", "Ubuntu"},
+		}))
+	})
+})
@@ -27,7 +27,12 @@ var (
 	app        = kingpin.New("right_st", "A command-line application for managing RightScripts")
 	debug      = app.Flag("debug", "Debug mode").Short('d').Bool()
 	configFile = app.Flag("config", "Set the config file path.").Short('c').Default(DefaultConfigFile()).String()
-	account    = app.Flag("account", "RightScale account name to use").Short('a').String()
+	account    = app.Flag("account", "RightScale account name to use, st upload, st validate, st commit and rightscript upload take a comma separated list of accounts").Short('a').String()
+
+	allAccounts = app.Flag("all-accounts", "Run st upload, st validate, st commit or rightscript upload for every configured account").Bool()
+	failFast    = app.Flag("fail-fast", "Stop running for the remaining accounts after one fails").Bool()
+
+	refreshCache = app.Flag("refresh-cache", "Refetch cached clouds, instance types and images from the API and compare RightScripts pushed before with RightScale again").Bool()
 
 	// ----- ServerTemplates -----
 	stCmd = app.Command("st", "ServerTemplate")
@@ -35,13 +40,16 @@ var (
 	stShowCmd        = stCmd.Command("show", "Show a single ServerTemplate")
 	stShowNameOrHref = stShowCmd.Arg("name|href|id", "ServerTemplate Name or HREF or Id").Required().String()
 
-	stUploadCmd    = stCmd.Command("upload", "Upload a ServerTemplate specified by a YAML document")
-	stUploadPaths  = stUploadCmd.Arg("path", "File or directory containing script files to upload").Required().ExistingFilesOrDirs()
-	stUploadPrefix = stUploadCmd.Flag("prefix", "Create dev/test version by adding prefix to name of all ServerTemplate and RightScripts uploaded").Short('x').String()
+	stUploadCmd         = stCmd.Command("upload", "Upload a ServerTemplate specified by a YAML document")
+	stUploadPaths       = stUploadCmd.Arg("path", "File or directory containing script files to upload").Required().ExistingFilesOrDirs()
+	stUploadPrefix      = prefixFlag(stUploadCmd.Flag("prefix", "Create dev/test version by adding prefix to name of all ServerTemplate and RightScripts uploaded").Short('x'))
+	stUploadForce       = stUploadCmd.Flag("force", "Overwrite changes made to the ServerTemplate or its RightScripts since they were last uploaded").Short('f').Bool()
+	stUploadAdopt       = stUploadCmd.Flag("adopt", "Record existing ServerTemplates, RightScripts and MultiCloudImages which were not uploaded from this repository as uploaded from it").Bool()
+	stUploadConcurrency = stUploadCmd.Flag("concurrency", "Number of RightScripts, MultiCloudImages and ServerTemplate steps, and of attachments, to upload at once.").Default(strconv.Itoa(defaultConcurrency)).Int()
 
 	stDeleteCmd    = stCmd.Command("delete", "Delete dev/test ServerTemplates and RightScripts with a prefix")
 	stDeletePaths  = stDeleteCmd.Arg("path", "File or directory containing script files").Required().ExistingFilesOrDirs()
-	stDeletePrefix = stDeleteCmd.Flag("prefix", "Prefix to delete").Short('x').String()
+	stDeletePrefix = prefixFlag(stDeleteCmd.Flag("prefix", "Prefix to delete").Short('x'))
 
 	stDownloadCmd         = stCmd.Command("download", "Download a ServerTemplate and all associated RightScripts/Attachments to disk")
 	stDownloadNameOrHref  = stDownloadCmd.Arg("name|href|id", "Script Name or HREF or Id").Required().String()
@@ -49,9 +57,27 @@ var (
 	stDownloadPublished   = stDownloadCmd.Flag("published", "Insert links to published RightScripts instead of downloading to disk.").Short('p').Bool()
 	stDownloadMciSettings = stDownloadCmd.Flag("mci-settings", "Download MCI settings data to recreate/manage an MCI.").Short('m').Bool()
 	stDownloadScriptPath  = stDownloadCmd.Flag("script-path", "Download RightScripts and their attachments to a subdirectory relative to the download location.").Short('s').String()
-
-	stValidateCmd   = stCmd.Command("validate", "Validate a ServerTemplate YAML document")
-	stValidatePaths = stValidateCmd.Arg("path", "Path to script file(s)").Required().ExistingFiles()
+	stDownloadAlertFormat = stDownloadCmd.Flag("alert-format", "Write Alerts as a single Clause or as structured fields.").Default(ClauseAlertFormat).Enum(ClauseAlertFormat, StructuredAlertFormat)
+	stDownloadLayout      = stDownloadCmd.Flag("layout", "Write MultiCloudImages, Alerts and Inputs into the ServerTemplate YAML file or split them into their own files.").Default(SingleLayout).Enum(SingleLayout, SplitLayout)
+	stDownloadConcurrency = stDownloadCmd.Flag("concurrency", "Number of RightScripts to download at once.").Default(strconv.Itoa(defaultConcurrency)).Int()
+
+	stPullCmd    = stCmd.Command("pull", "Merge changes made to ServerTemplates since they were uploaded into the local files")
+	stPullPaths  = stPullCmd.Arg("path", "File or directory containing ServerTemplate YAML files to pull").Required().ExistingFilesOrDirs()
+	stPullPrefix = prefixFlag(stPullCmd.Flag("prefix", "Pull the dev/test version with this prefix").Short('x'))
+	stPullForce  = stPullCmd.Flag("force", "Overwrite local changes which are not committed with remote changes").Short('f').Bool()
+
+	stCopyCmd         = stCmd.Command("copy", "Copy a ServerTemplate with its RightScripts, MultiCloudImages and Alerts from one account to another")
+	stCopyNameOrHref  = stCopyCmd.Arg("name|href|id", "ServerTemplate Name or HREF or Id in the account to copy from").Required().String()
+	stCopyFromAccount = stCopyCmd.Flag("from-account", "Name of the RightScale API Account to copy from").Required().String()
+	stCopyToAccount   = stCopyCmd.Flag("to-account", "Name of the RightScale API Account to copy to").Required().String()
+	stCopyForce       = stCopyCmd.Flag("force", "Overwrite changes made to the ServerTemplate or its RightScripts in the account to copy to since they were last copied").Short('f').Bool()
+	stCopyAdopt       = stCopyCmd.Flag("adopt", "Record existing ServerTemplates, RightScripts and MultiCloudImages in the account to copy to which were not copied from this ServerTemplate as copied from it").Bool()
+	stCopyConcurrency = stCopyCmd.Flag("concurrency", "Number of RightScripts to download and of RightScripts, MultiCloudImages and ServerTemplate steps, and of attachments, to upload at once.").Default(strconv.Itoa(defaultConcurrency)).Int()
+
+	stValidateCmd         = stCmd.Command("validate", "Validate a ServerTemplate YAML document")
+	stValidatePaths       = stValidateCmd.Arg("path", "Path to script file(s)").Required().ExistingFiles()
+	stValidateOffline     = stValidateCmd.Flag("offline", "Validate MultiCloudImage settings against cached clouds, instance types and images without using the API").Bool()
+	stValidateEscalations = stValidateCmd.Flag("escalations", "Escalations YAML file to check the escalations Alerts refer to against").Short('e').ExistingFiles()
 
 	stCommitCmd                = stCmd.Command("commit", "Commit ServerTemplate")
 	stCommitNameOrHrefOrPath   = stCommitCmd.Arg("name|href|id|path", "ServerTemplate name, HREF, ID or file path").Required().Strings()
@@ -65,14 +91,16 @@ var (
 	rightScriptShowCmd        = rightScriptCmd.Command("show", "Show a single RightScript and its attachments")
 	rightScriptShowNameOrHref = rightScriptShowCmd.Arg("name|href|id", "Script Name or HREF or Id").Required().String()
 
-	rightScriptUploadCmd    = rightScriptCmd.Command("upload", "Upload a RightScript")
-	rightScriptUploadPaths  = rightScriptUploadCmd.Arg("path", "File or directory containing script files to upload").Required().ExistingFilesOrDirs()
-	rightScriptUploadPrefix = rightScriptUploadCmd.Flag("prefix", "Create dev/test version by adding prefix to name of all RightScripts uploaded").Short('x').String()
-	rightScriptUploadForce  = rightScriptUploadCmd.Flag("force", "Force upload of file if metadata is not present").Short('f').Bool()
+	rightScriptUploadCmd       = rightScriptCmd.Command("upload", "Upload a RightScript")
+	rightScriptUploadPaths     = rightScriptUploadCmd.Arg("path", "File or directory containing script files to upload").Required().ExistingFilesOrDirs()
+	rightScriptUploadPrefix    = prefixFlag(rightScriptUploadCmd.Flag("prefix", "Create dev/test version by adding prefix to name of all RightScripts uploaded").Short('x'))
+	rightScriptUploadForce     = rightScriptUploadCmd.Flag("force", "Force upload of file if metadata is not present").Short('f').Bool()
+	rightScriptUploadOverwrite = rightScriptUploadCmd.Flag("overwrite", "Overwrite changes made to RightScripts since they were last uploaded").Bool()
+	rightScriptUploadAdopt     = rightScriptUploadCmd.Flag("adopt", "Record existing RightScripts which were not uploaded from this repository as uploaded from it").Bool()
 
 	rightScriptDeleteCmd    = rightScriptCmd.Command("delete", "Delete dev/test RightScripts with a prefix.")
 	rightScriptDeletePaths  = rightScriptDeleteCmd.Arg("path", "File or directory containing script files").Required().ExistingFilesOrDirs()
-	rightScriptDeletePrefix = rightScriptDeleteCmd.Flag("prefix", "Prefix to delete").Short('x').String()
+	rightScriptDeletePrefix = prefixFlag(rightScriptDeleteCmd.Flag("prefix", "Prefix to delete").Short('x'))
 
 	rightScriptDownloadCmd        = rightScriptCmd.Command("download", "Download a RightScript to a file or files")
 	rightScriptDownloadNameOrHref = rightScriptDownloadCmd.Arg("name|href|id", "Script Name or HREF or Id").Required().String()
@@ -90,15 +118,90 @@ var (
 	rightScriptCommitNameOrHrefOrPath = rightScriptCommitCmd.Arg("name|href|id|path", "RightScript name, HREF, ID or file path").Required().Strings()
 	rightScriptCommitMessage          = rightScriptCommitCmd.Flag("message", "RightScript commit message").Short('m').Required().String()
 
+	// ----- Alerts -----
+	alertCmd = app.Command("alert", "Alerts on Deployments, Servers and ServerArrays")
+
+	alertShowCmd    = alertCmd.Command("show", "Show the Alerts on a Deployment, Server or ServerArray")
+	alertShowTarget = alertShowCmd.Arg("name|href", "Deployment, Server or ServerArray name or HREF").Required().String()
+
+	alertDownloadCmd    = alertCmd.Command("download", "Download the Alerts on a Deployment, Server or ServerArray to an Alerts YAML file")
+	alertDownloadTarget = alertDownloadCmd.Arg("name|href", "Deployment, Server or ServerArray name or HREF").Required().String()
+	alertDownloadTo     = alertDownloadCmd.Arg("path", "Download location").String()
+	alertDownloadFormat = alertDownloadCmd.Flag("alert-format", "Write Alerts as a single Clause or as structured fields.").Default(ClauseAlertFormat).Enum(ClauseAlertFormat, StructuredAlertFormat)
+
+	alertSyncCmd    = alertCmd.Command("sync", "Add, update and remove the Alerts on a Deployment, Server or ServerArray to match an Alerts YAML file")
+	alertSyncPath   = alertSyncCmd.Arg("path", "Alerts YAML file").Required().ExistingFile()
+	alertSyncTarget = alertSyncCmd.Flag("target", "Deployment, Server or ServerArray name or HREF").Short('t').Required().String()
+
+	// ----- Accounts -----
+	accountCmd = app.Command("account", "Everything in a RightScale account")
+
+	accountExportCmd       = accountCmd.Command("export", "Export every ServerTemplate, RightScript and MultiCloudImage in the account to a directory")
+	accountExportTo        = accountExportCmd.Arg("path", "Directory to export to").Required().String()
+	accountExportRevisions = accountExportCmd.Flag("revisions", "Export committed revisions as well as HEAD revisions").Short('r').Bool()
+
+	accountGcCmd     = accountCmd.Command("gc", "Delete RightScripts, MultiCloudImages and dev/test ServerTemplates nothing refers to")
+	accountGcPaths   = accountGcCmd.Arg("path", "ServerTemplate YAML files, RightScript files or directories containing them to keep everything named in").ExistingFilesOrDirs()
+	accountGcPrefix  = accountGcCmd.Flag("prefix", "Delete HEAD ServerTemplates uploaded with this prefix which are not in the files (may be given more than once)").Short('x').Strings()
+	accountGcYes     = accountGcCmd.Flag("yes", "Delete without asking for confirmation").Short('y').Bool()
+	accountGcUnowned = accountGcCmd.Flag("unowned", "Delete objects which were not uploaded from this repository as well").Bool()
+
+	// ----- Cleanup -----
+	cleanupCmd           = app.Command("cleanup", "Delete dev/test ServerTemplates, MultiCloudImages and RightScripts with a prefix without needing their files")
+	cleanupPrefix        = cleanupCmd.Flag("prefix", "Prefix to delete (may be given more than once)").Short('x').Strings()
+	cleanupPrefixPattern = cleanupCmd.Flag("prefix-pattern", "Glob pattern of prefixes to delete such as 'pr*' (may be given more than once)").Strings()
+	cleanupOlderThan     = cleanupCmd.Flag("older-than", "Only delete prefixes not uploaded to for this long such as 14d or 36h").String()
+	cleanupYes           = cleanupCmd.Flag("yes", "Delete without asking for confirmation").Short('y').Bool()
+	cleanupUnowned       = cleanupCmd.Flag("unowned", "Delete objects which were not uploaded from this repository as well").Bool()
+
+	// ----- Escalations -----
+	escalationCmd = app.Command("escalation", "Alert escalations (local files only, the API has no escalation resource)")
+
+	escalationValidateCmd   = escalationCmd.Command("validate", "Validate an Escalations YAML document")
+	escalationValidatePaths = escalationValidateCmd.Arg("path", "Path to Escalations file(s)").Required().ExistingFiles()
+
 	// ----- Configuration -----
 	configCmd = app.Command("config", "Manage Configuration")
 
-	configAccountCmd      = configCmd.Command("account", "Add or edit configuration for a RightScale API account")
-	configAccountName     = configAccountCmd.Arg("name", "Name of RightScale API Account to add or edit").Required().String()
-	configAccountDefault  = configAccountCmd.Flag("default", "Set the named RightScale API Account as the default").Short('D').Bool()
-	configAccountPassword = configAccountCmd.Flag("password", "Store a username and encrypted password instead of a refresh token").Short('p').Bool()
+	configAccountCmd = configCmd.Command("account", "Manage configuration for RightScale API accounts")
+
+	configAccountSetCmd       = configAccountCmd.Command("set", "Add or edit configuration for a RightScale API account").Default()
+	configAccountName         = configAccountSetCmd.Arg("name", "Name of RightScale API Account to add or edit").Required().String()
+	configAccountDefault      = configAccountSetCmd.Flag("default", "Set the named RightScale API Account as the default").Short('D').Bool()
+	configAccountPassword     = configAccountSetCmd.Flag("password", "Store a username and encrypted password instead of a refresh token").Short('p').Bool()
+	configAccountId           = configAccountSetCmd.Flag("id", "Account ID, setting any of the account fields from flags does not prompt and reads a password from standard input").Int()
+	configAccountHost         = configAccountSetCmd.Flag("host", "API endpoint host").String()
+	configAccountRefreshToken = configAccountSetCmd.Flag("refresh-token", "Refresh token").String()
+	configAccountUsername     = configAccountSetCmd.Flag("username", "Username").String()
+	configAccountTokenCommand = configAccountSetCmd.Flag("refresh-token-command", "Command which outputs the refresh token, such as 'pass show rightscale'").String()
+	configAccountPassCommand  = configAccountSetCmd.Flag("password-command", "Command which outputs the password").String()
+	configAccountEncrypt      = configAccountSetCmd.Flag("encrypt", "Encrypt the refresh token or password with a passphrase").Short('e').Bool()
+
+	configEncryptCmd   = configCmd.Command("encrypt", "Encrypt stored refresh tokens and passwords with a passphrase")
+	configEncryptNames = configEncryptCmd.Arg("name", "Names of RightScale API Accounts to encrypt, defaults to all accounts").Strings()
+
+	configAccountListCmd = configAccountCmd.Command("list", "List the configured RightScale API accounts")
+
+	configAccountRemoveCmd  = configAccountCmd.Command("remove", "Remove configuration for a RightScale API account")
+	configAccountRemoveName = configAccountRemoveCmd.Arg("name", "Name of RightScale API Account to remove").Required().String()
+
+	configAccountRenameCmd     = configAccountCmd.Command("rename", "Rename a RightScale API account")
+	configAccountRenameName    = configAccountRenameCmd.Arg("name", "Name of RightScale API Account to rename").Required().String()
+	configAccountRenameNewName = configAccountRenameCmd.Arg("new-name", "New name of the RightScale API Account").Required().String()
+
+	configAccountUseCmd  = configAccountCmd.Command("use", "Set a RightScale API account as the default")
+	configAccountUseName = configAccountUseCmd.Arg("name", "Name of RightScale API Account to use by default").Required().String()
+
+	configShowCmd       = configCmd.Command("show", "Show configuration")
+	configShowEffective = configShowCmd.Flag("effective", "Show the configuration with the project config file merged in").Bool()
 
-	configShowCmd = configCmd.Command("show", "Show configuration")
+	// ----- Catalog cache -----
+	cacheCmd = app.Command("cache", "Manage the cache of clouds, instance types and images")
+
+	cacheShowCmd = cacheCmd.Command("show", "Show the cached clouds, instance types, images and pushed RightScripts for the account")
+
+	cacheClearCmd = cacheCmd.Command("clear", "Clear the cached clouds, instance types, images and pushed RightScripts for the account")
+	cacheClearAll = cacheClearCmd.Flag("all", "Clear the caches for all accounts").Bool()
 
 	// ----- Update right_st -----
 	updateCmd = app.Command("update", "Update "+app.Name+" executable")
@@ -116,19 +219,18 @@ func main() {
 	app.VersionFlag.Short('v')
 	command := kingpin.MustParse(app.Parse(os.Args[1:]))
 
-	err := ReadConfig(*configFile, *account)
+	readAccount := *account
+	multipleAccounts := *allAccounts || strings.Contains(*account, ",")
+	if multipleAccounts {
+		// read the config with the first account, each account is switched to in turn when the command runs
+		readAccount = strings.TrimSpace(strings.Split(*account, ",")[0])
+	}
+	err := ReadConfig(*configFile, readAccount)
 	if !strings.HasPrefix(command, "config") && !strings.HasPrefix(command, "update") {
 		// Makes sure the config file structure is valid
 		if err != nil {
 			fatalError("%s: Error reading config file: %s\n", filepath.Base(os.Args[0]), err.Error())
 		}
-
-		// Make sure the config file auth token is valid. Check now so we don't have to
-		// keep rechecking in code.
-		_, err := Config.Account.Client15()
-		if err != nil {
-			fatalError("Authentication error: %s", err.Error())
-		}
 	}
 
 	// Handle logging
@@ -149,6 +251,49 @@ func main() {
 		defer UpdateCheck(VV, os.Stderr)
 	}
 
+	if multipleAccounts {
+		if !multipleAccountCommands[command] {
+			fatalError("%s cannot be run for more than one account", command)
+		}
+		names, err := Config.AccountNames(*account, *allAccounts)
+		if err != nil {
+			fatalError("%s\n", err.Error())
+		}
+		results := RunForAccounts(names, *failFast, os.Stdout, func() error {
+			authenticate(command)
+			runCommand(command)
+			return nil
+		})
+		if !AccountsSucceeded(results) {
+			os.Exit(1)
+		}
+		return
+	}
+
+	authenticate(command)
+	runCommand(command)
+}
+
+// authenticate makes sure the config file auth token is valid. Check now so we don't have to keep rechecking in code.
+func authenticate(command string) {
+	// The config, update, cache and escalation commands and offline validation never use the API.
+	for _, prefix := range []string{"config", "update", "cache", "escalation"} {
+		if strings.HasPrefix(command, prefix) {
+			return
+		}
+	}
+	// Copying authenticates with the accounts it copies between instead.
+	if *stValidateOffline || command == stCopyCmd.FullCommand() {
+		return
+	}
+	_, err := Config.Account.Client15()
+	if err != nil {
+		fatalError("Authentication error: %s", err.Error())
+	}
+}
+
+func runCommand(command string) {
+	var err error
 	switch command {
 	case stShowCmd.FullCommand():
 		href, err := paramToHref("server_templates", *stShowNameOrHref, 0, true)
@@ -161,25 +306,37 @@ func main() {
 		if err != nil {
 			fatalError("%s\n", err.Error())
 		}
-		stUpload(files, *stUploadPrefix)
+		adoptExisting = *stUploadAdopt
+		forceOverwrite = *stUploadForce
+		stUpload(files, defaultPrefix(stUploadPrefix), *stUploadConcurrency)
 	case stDeleteCmd.FullCommand():
 		files, err := walkPaths(*stDeletePaths)
 		if err != nil {
 			fatalError("%s\n", err.Error())
 		}
-		stDelete(files, *stDeletePrefix)
+		stDelete(files, defaultPrefix(stDeletePrefix))
 	case stDownloadCmd.FullCommand():
 		href, err := paramToHref("server_templates", *stDownloadNameOrHref, 0, false)
 		if err != nil {
 			fatalError("%s", err.Error())
 		}
-		stDownload(href, *stDownloadTo, *stDownloadPublished, *stDownloadMciSettings, *stDownloadScriptPath)
+		stDownload(href, *stDownloadTo, *stDownloadPublished, *stDownloadMciSettings, false, *stDownloadScriptPath, *stDownloadAlertFormat, *stDownloadLayout, *stDownloadConcurrency)
+	case stPullCmd.FullCommand():
+		files, err := walkPaths(*stPullPaths)
+		if err != nil {
+			fatalError("%s\n", err.Error())
+		}
+		stPull(files, defaultPrefix(stPullPrefix), *stPullForce)
+	case stCopyCmd.FullCommand():
+		adoptExisting = *stCopyAdopt
+		forceOverwrite = *stCopyForce
+		stCopy(*stCopyNameOrHref, *stCopyFromAccount, *stCopyToAccount, *stCopyConcurrency)
 	case stValidateCmd.FullCommand():
 		files, err := walkPaths(*stValidatePaths)
 		if err != nil {
 			fatalError("%s\n", err.Error())
 		}
-		stValidate(files)
+		stValidate(files, *stValidateEscalations)
 	case stCommitCmd.FullCommand():
 		for _, input := range *stCommitNameOrHrefOrPath {
 			href, err := paramToHref("server_templates", input, 0, true)
@@ -199,13 +356,16 @@ func main() {
 		if err != nil {
 			fatalError("%s\n", err.Error())
 		}
-		rightScriptUpload(files, *rightScriptUploadForce, *rightScriptUploadPrefix)
+		adoptExisting = *rightScriptUploadAdopt
+		forceOverwrite = *rightScriptUploadOverwrite
+		forceOverwriteFlag = "--overwrite"
+		rightScriptUpload(files, *rightScriptUploadForce, defaultPrefix(rightScriptUploadPrefix))
 	case rightScriptDeleteCmd.FullCommand():
 		files, err := walkPaths(*rightScriptDeletePaths)
 		if err != nil {
 			fatalError("%s\n", err.Error())
 		}
-		rightScriptDelete(files, *rightScriptDeletePrefix)
+		rightScriptDelete(files, defaultPrefix(rightScriptDeletePrefix))
 	case rightScriptDownloadCmd.FullCommand():
 		href, err := paramToHref("right_scripts", *rightScriptDownloadNameOrHref, 0, false)
 		if err != nil {
@@ -232,16 +392,93 @@ func main() {
 			}
 			rightScriptCommit(href, *rightScriptCommitMessage)
 		}
-	case configAccountCmd.FullCommand():
-		err := Config.SetAccount(*configAccountName, *configAccountDefault, *configAccountPassword, os.Stdin, os.Stdout)
+	case alertShowCmd.FullCommand():
+		alertShow(*alertShowTarget)
+	case alertDownloadCmd.FullCommand():
+		alertDownload(*alertDownloadTarget, *alertDownloadTo, *alertDownloadFormat)
+	case alertSyncCmd.FullCommand():
+		alertSync(*alertSyncPath, *alertSyncTarget)
+	case accountExportCmd.FullCommand():
+		accountExport(*accountExportTo, *accountExportRevisions)
+	case accountGcCmd.FullCommand():
+		files, err := walkPaths(*accountGcPaths)
+		if err != nil {
+			fatalError("%s\n", err.Error())
+		}
+		accountGc(files, *accountGcPrefix, *accountGcUnowned, *accountGcYes)
+	case cleanupCmd.FullCommand():
+		cleanup(*cleanupPrefix, *cleanupPrefixPattern, *cleanupOlderThan, *cleanupUnowned, *cleanupYes)
+	case escalationValidateCmd.FullCommand():
+		escalationValidate(*escalationValidatePaths)
+	case configAccountSetCmd.FullCommand():
+		if *configAccountId != 0 || *configAccountHost != "" || *configAccountRefreshToken != "" || *configAccountUsername != "" ||
+			*configAccountTokenCommand != "" || *configAccountPassCommand != "" {
+			values := &Account{
+				Id:                  *configAccountId,
+				Host:                *configAccountHost,
+				RefreshToken:        *configAccountRefreshToken,
+				Username:            *configAccountUsername,
+				RefreshTokenCommand: *configAccountTokenCommand,
+				PasswordCommand:     *configAccountPassCommand,
+			}
+			if *configAccountPassword && values.PasswordCommand == "" {
+				// read the password from standard input so it does not show up in the process list
+				if values.Password, err = readLine(os.Stdin); err != nil {
+					fatalError("Could not read password: %s\n", err.Error())
+				}
+			}
+			err = Config.SetAccountValues(*configAccountName, *configAccountDefault, values)
+		} else {
+			err = Config.SetAccount(*configAccountName, *configAccountDefault, *configAccountPassword, os.Stdin, os.Stdout)
+		}
+		if err == nil && *configAccountEncrypt {
+			// read back the account which was just written so it can be encrypted
+			if err = ReadConfig(*configFile, *configAccountName); err == nil {
+				err = configEncrypt([]string{*configAccountName})
+			}
+		}
+		if err != nil {
+			fatalError("%s\n", err.Error())
+		}
+	case configEncryptCmd.FullCommand():
+		err := configEncrypt(*configEncryptNames)
+		if err != nil {
+			fatalError("%s\n", err.Error())
+		}
+	case configAccountListCmd.FullCommand():
+		err := Config.ListAccounts(os.Stdout)
+		if err != nil {
+			fatalError("%s\n", err.Error())
+		}
+	case configAccountRemoveCmd.FullCommand():
+		err := Config.RemoveAccount(*configAccountRemoveName)
+		if err != nil {
+			fatalError("%s\n", err.Error())
+		}
+	case configAccountRenameCmd.FullCommand():
+		err := Config.RenameAccount(*configAccountRenameName, *configAccountRenameNewName)
+		if err != nil {
+			fatalError("%s\n", err.Error())
+		}
+	case configAccountUseCmd.FullCommand():
+		err := Config.UseAccount(*configAccountUseName)
 		if err != nil {
 			fatalError("%s\n", err.Error())
 		}
 	case configShowCmd.FullCommand():
-		err := Config.ShowConfiguration(os.Stdout)
+		var err error
+		if *configShowEffective {
+			err = Config.ShowEffectiveConfiguration(os.Stdout)
+		} else {
+			err = Config.ShowConfiguration(os.Stdout)
+		}
 		if err != nil {
 			fatalError("%s\n", err.Error())
 		}
+	case cacheShowCmd.FullCommand():
+		cacheShow()
+	case cacheClearCmd.FullCommand():
+		cacheClear(*cacheClearAll)
 	case updateListCmd.FullCommand():
 		err := UpdateList(VV, os.Stdout)
 		if err != nil {
@@ -255,6 +492,30 @@ func main() {
 	}
 }
 
+// prefixesGiven are the values of the prefix flags which were given on the command line, even as an empty string.
+var prefixesGiven = make(map[*string]bool)
+
+// prefixFlag returns the value of a prefix flag and records whether it was given so defaultPrefix can tell an empty
+// prefix given on the command line from no prefix given.
+func prefixFlag(flag *kingpin.FlagClause) *string {
+	prefix := new(string)
+	flag.Action(func(*kingpin.ParseContext) error {
+		prefixesGiven[prefix] = true
+		return nil
+	}).StringVar(prefix)
+	return prefix
+}
+
+// defaultPrefix returns the prefix given on the command line or else the one from the config, usually set by a
+// project config file. Giving an empty prefix on the command line works with the production versions even if the
+// config has a prefix.
+func defaultPrefix(prefix *string) string {
+	if prefixesGiven[prefix] {
+		return *prefix
+	}
+	return Config.GetString("prefix")
+}
+
 // Distill a passed in user parameter (id or href or name) to hrefs. A name
 // can correspond to multiple hrefs so an array of all matches is returned in
 // that case.
@@ -411,7 +672,7 @@ func fatalError(format string, v ...interface{}) {
 	msg := fmt.Sprintf("ERROR: "+format, v...)
 	fmt.Fprintf(os.Stderr, "%s\n", msg)
 
-	os.Exit(1)
+	exit(1)
 }
 
 func fmd5sum(path string) (string, error) {
@@ -551,7 +812,7 @@ func setTagsByHref(href string, tags []string) error {
 		return err
 	}
 	toDelete := []string{}
-	for _, et := range existingTags {
+	for _, et := range withoutRightStTags(existingTags) {
 		seen := false
 		for _, t := range tags {
 			if t == et {
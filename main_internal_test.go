@@ -0,0 +1,82 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Choosing the prefix", func() {
+	var file string
+
+	BeforeEach(func() {
+		f, err := ioutil.TempFile("", "right_st_prefix")
+		Expect(err).NotTo(HaveOccurred())
+		f.Close()
+		file = f.Name()
+		Config.Viper = newViper()
+		Config.Set("prefix", "pr1")
+		prefixesGiven = make(map[*string]bool)
+	})
+
+	AfterEach(func() {
+		os.Remove(file)
+		Config.Viper = newViper()
+		prefixesGiven = make(map[*string]bool)
+	})
+
+	It("uses the prefix from the config if no prefix is given", func() {
+		_, err := app.Parse([]string{"st", "delete", file})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(defaultPrefix(stDeletePrefix)).To(Equal("pr1"))
+	})
+
+	It("uses the prefix given on the command line", func() {
+		_, err := app.Parse([]string{"st", "delete", "--prefix", "pr2", file})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(defaultPrefix(stDeletePrefix)).To(Equal("pr2"))
+	})
+
+	It("uses no prefix if an empty prefix is given on the command line", func() {
+		_, err := app.Parse([]string{"st", "upload", "--prefix", "", file})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(defaultPrefix(stUploadPrefix)).To(BeEmpty())
+
+		_, err = app.Parse([]string{"rightscript", "delete", "-x", "", file})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(defaultPrefix(rightScriptDeletePrefix)).To(BeEmpty())
+	})
+})
+
+var _ = Describe("Forcing a RightScript upload", func() {
+	var file string
+
+	BeforeEach(func() {
+		f, err := ioutil.TempFile("", "right_st_force")
+		Expect(err).NotTo(HaveOccurred())
+		f.Close()
+		file = f.Name()
+		// flags keep their values from earlier parses
+		*rightScriptUploadForce, *rightScriptUploadOverwrite = false, false
+	})
+
+	AfterEach(func() {
+		os.Remove(file)
+	})
+
+	It("uploads without metadata but keeps checking for changes with -f", func() {
+		_, err := app.Parse([]string{"rightscript", "upload", "-f", file})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(*rightScriptUploadForce).To(BeTrue())
+		Expect(*rightScriptUploadOverwrite).To(BeFalse())
+	})
+
+	It("overwrites changes with --overwrite", func() {
+		_, err := app.Parse([]string{"rightscript", "upload", "--overwrite", file})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(*rightScriptUploadForce).To(BeFalse())
+		Expect(*rightScriptUploadOverwrite).To(BeTrue())
+	})
+})
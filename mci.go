@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"io/ioutil"
 	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
 
@@ -24,6 +25,18 @@ type Setting struct {
 	imageHref        string
 }
 
+// SettingsMatrix is a compact way of describing many Settings which share an instance type. It is expanded into
+// Settings by ExpandMultiCloudImages with one Setting per cloud.
+type SettingsMatrix struct {
+	// Clouds lists the clouds to generate Settings for in order. If it is empty the clouds are taken from the keys of
+	// Images in sorted order.
+	Clouds        []string          `yaml:"Clouds,omitempty"`
+	Images        map[string]string `yaml:"Images"`
+	InstanceType  string            `yaml:"Instance Type"`
+	InstanceTypes map[string]string `yaml:"Instance Types,omitempty"`
+	UserData      string            `yaml:"User Data,omitempty"`
+}
+
 type MultiCloudImage struct {
 	Href        string     `yaml:"Href,omitempty"`
 	Name        string     `yaml:"Name,omitempty"`
@@ -32,16 +45,15 @@ type MultiCloudImage struct {
 	Publisher   string     `yaml:"Publisher,omitempty"`
 	Tags        []string   `yaml:"Tags,omitempty"`
 	// Settings are like MultiCloudImageSettings, defining cloud/resource_uid sets
-	Settings []*Setting `yaml:"Settings,omitempty"`
-	File     string     `yaml:"-"`
+	Settings []*Setting      `yaml:"Settings,omitempty"`
+	Matrix   *SettingsMatrix `yaml:"Matrix,omitempty"`
+	File     string          `yaml:"-"`
+	// source is the MCI YAML file a File reference was read from
+	source string
 }
 
 type RsRevision int
 
-// Cache lookups for below
-var cloudsLookup []*cm15.Cloud
-var instanceTypesLookup map[string][]*cm15.InstanceType
-
 func (mci *MultiCloudImage) UnmarshalYAML(unmarshal func(interface{}) error) error {
 	err := unmarshal(&mci.File)
 	if err == nil {
@@ -50,13 +62,14 @@ func (mci *MultiCloudImage) UnmarshalYAML(unmarshal func(interface{}) error) err
 
 	// make a dummy struct that will parse the same as MultiCloudImage so we can unmarshall it without having UnmarshalYAML infinitely recursing
 	var mapMCI struct {
-		Href        string     `yaml:"Href,omitempty"`
-		Name        string     `yaml:"Name,omitempty"`
-		Description string     `yaml:"Description,omitempty"`
-		Revision    RsRevision `yaml:"Revision,omitempty"`
-		Publisher   string     `yaml:"Publisher,omitempty"`
-		Tags        []string   `yaml:"Tags,omitempty"`
-		Settings    []*Setting `yaml:"Settings,omitempty"`
+		Href        string          `yaml:"Href,omitempty"`
+		Name        string          `yaml:"Name,omitempty"`
+		Description string          `yaml:"Description,omitempty"`
+		Revision    RsRevision      `yaml:"Revision,omitempty"`
+		Publisher   string          `yaml:"Publisher,omitempty"`
+		Tags        []string        `yaml:"Tags,omitempty"`
+		Settings    []*Setting      `yaml:"Settings,omitempty"`
+		Matrix      *SettingsMatrix `yaml:"Matrix,omitempty"`
 	}
 	err = unmarshal(&mapMCI)
 	if err != nil {
@@ -69,31 +82,110 @@ func (mci *MultiCloudImage) UnmarshalYAML(unmarshal func(interface{}) error) err
 	mci.Publisher = mapMCI.Publisher
 	mci.Tags = mapMCI.Tags
 	mci.Settings = mapMCI.Settings
+	mci.Matrix = mapMCI.Matrix
 	mci.File = ""
 	return nil
 }
 
 // ExpandMultiCloudImages goes through a slice of MultiCloudImage structs and for any that have a File reference, it
 // reads in a MultiCloudImage struct from the file and returns the new slice. It is an error to specify a File reference
-// in an MCI YAML file.
+// in an MCI YAML file. Any settings Matrix is expanded into Settings as well.
 func ExpandMultiCloudImages(dir string, mcis []*MultiCloudImage) ([]*MultiCloudImage, error) {
 	expandedMCIs := make([]*MultiCloudImage, 0, len(mcis))
 	for _, mci := range mcis {
 		if mci.File != "" {
-			bytes, err := ioutil.ReadFile(filepath.Join(dir, mci.File))
+			file := mci.File
+			bytes, err := ioutil.ReadFile(filepath.Join(dir, file))
 			if err != nil {
 				return nil, err
 			}
 			err = yaml.UnmarshalStrict(bytes, &mci)
 			if err != nil {
-				return nil, fmt.Errorf("%v: %v", mci.File, err)
+				return nil, fmt.Errorf("%v: %v", file, err)
 			}
+			if err := mci.expandMatrix(); err != nil {
+				return nil, fmt.Errorf("%v: %v", file, err)
+			}
+			mci.source = filepath.Join(dir, file)
+		} else if err := mci.expandMatrix(); err != nil {
+			return nil, err
 		}
 		expandedMCIs = append(expandedMCIs, mci)
 	}
 	return expandedMCIs, nil
 }
 
+// expandMatrix generates a Setting for every cloud in the settings Matrix and appends them to any Settings given
+// explicitly. A cloud may only have one Setting so it is an error for a cloud to be both in Settings and the Matrix.
+func (mci *MultiCloudImage) expandMatrix() error {
+	if mci.Matrix == nil {
+		return nil
+	}
+	matrix := mci.Matrix
+
+	clouds := matrix.Clouds
+	if len(clouds) == 0 {
+		for cloud := range matrix.Images {
+			clouds = append(clouds, cloud)
+		}
+		sort.Strings(clouds)
+	}
+	if len(clouds) == 0 {
+		return fmt.Errorf("Matrix for MCI '%s' must have at least one cloud in Clouds or Images", mci.Name)
+	}
+
+	seenClouds := make(map[string]bool)
+	for _, s := range mci.Settings {
+		seenClouds[s.Cloud] = true
+	}
+	for cloud := range matrix.Images {
+		if !containsString(clouds, cloud) {
+			return fmt.Errorf("Matrix for MCI '%s' has an image for cloud %s which is not listed in Clouds", mci.Name, cloud)
+		}
+	}
+	for cloud := range matrix.InstanceTypes {
+		if !containsString(clouds, cloud) {
+			return fmt.Errorf("Matrix for MCI '%s' has an instance type for cloud %s which is not listed in Clouds", mci.Name, cloud)
+		}
+	}
+
+	for _, cloud := range clouds {
+		if seenClouds[cloud] {
+			return fmt.Errorf("Matrix for MCI '%s' cloud %s duplicates another setting for the same cloud", mci.Name, cloud)
+		}
+		seenClouds[cloud] = true
+
+		image, ok := matrix.Images[cloud]
+		if !ok || image == "" {
+			return fmt.Errorf("Matrix for MCI '%s' has no image for cloud %s", mci.Name, cloud)
+		}
+		instanceType := matrix.InstanceType
+		if it, ok := matrix.InstanceTypes[cloud]; ok {
+			instanceType = it
+		}
+		if instanceType == "" {
+			return fmt.Errorf("Matrix for MCI '%s' has no instance type for cloud %s", mci.Name, cloud)
+		}
+		mci.Settings = append(mci.Settings, &Setting{
+			Cloud:        cloud,
+			InstanceType: instanceType,
+			Image:        image,
+			UserData:     matrix.UserData,
+		})
+	}
+	mci.Matrix = nil
+	return nil
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
 // Let people specify MCIs multiple ways:
 //   1. Href (Sort of there for completeness and to break ties for 2. may remove at some point)
 //   2. Name/Revision pair (similar to above, but at least somewhat portable)
@@ -102,16 +194,11 @@ func ExpandMultiCloudImages(dir string, mcis []*MultiCloudImage) ([]*MultiCloudI
 func validateMultiCloudImage(mciDef *MultiCloudImage) (errors []error) {
 	client, _ := Config.Account.Client15()
 
-	if instanceTypesLookup == nil {
-		cl, err := client.CloudLocator("/api/clouds").Index(rsapi.APIParams{})
-		if err != nil {
-			fatalError("Could not execute API call to get clouds: %s", err.Error())
-		}
-		cloudsLookup = cl
-		instanceTypesLookup = make(map[string][]*cm15.InstanceType)
-	}
-
 	if mciDef.Href != "" {
+		if getCatalog().Offline {
+			fmt.Printf("WARNING: Not validating MCI HREF %s in offline mode\n", mciDef.Href)
+			return
+		}
 		loc := client.MultiCloudImageLocator(mciDef.Href)
 		mci, err := loc.Show()
 		if err != nil {
@@ -122,50 +209,46 @@ func validateMultiCloudImage(mciDef *MultiCloudImage) (errors []error) {
 	} else if len(mciDef.Settings) > 0 {
 		for i, s := range mciDef.Settings {
 			if s.Cloud == "" || s.InstanceType == "" || s.Image == "" {
-				errors = append(errors, fmt.Errorf("Invalid setting, Cloud, Instance Type, and Image fields must be set\n"))
+				errors = append(errors, fmt.Errorf("Invalid setting #%d for MCI '%s' cloud %s, Cloud, Instance Type, and Image fields must be set",
+					i+1, mciDef.Name, s.Cloud))
 				return
 			}
-			for _, c := range cloudsLookup {
-				if getLink(c.Links, "self") == s.Cloud || c.DisplayName == s.Cloud || c.Name == s.Cloud {
-					mciDef.Settings[i].cloudHref = getLink(c.Links, "self")
-				}
+			cloud, err := getCatalog().Cloud(s.Cloud)
+			if err != nil {
+				errors = append(errors, err)
+				return
 			}
-			if mciDef.Settings[i].cloudHref == "" {
+			if cloud == nil {
 				errors = append(errors, fmt.Errorf("Cannot find cloud %s for MCI '%s' Setting #%d",
 					s.Cloud, mciDef.Name, i+1))
 				return
 			}
-			if _, ok := instanceTypesLookup[mciDef.Settings[i].cloudHref]; !ok {
-				its, err := client.InstanceTypeLocator(mciDef.Settings[i].cloudHref + "/instance_types").Index(rsapi.APIParams{})
-				if err != nil {
-					errors = append(errors, fmt.Errorf("WARNING: Could not complete API call: %s\n", err.Error()))
-				}
-				instanceTypesLookup[mciDef.Settings[i].cloudHref] = its
-			}
-			for _, it := range instanceTypesLookup[mciDef.Settings[i].cloudHref] {
-				if getLink(it.Links, "self") == s.InstanceType || it.Name == s.InstanceType || it.ResourceUid == s.InstanceType {
-					mciDef.Settings[i].instanceTypeHref = getLink(it.Links, "self")
-				}
-			}
-			if mciDef.Settings[i].instanceTypeHref == "" {
+			mciDef.Settings[i].cloudHref = cloud.Href
+
+			it, err := getCatalog().InstanceType(cloud.Href, s.InstanceType)
+			if err != nil {
+				errors = append(errors, fmt.Errorf("WARNING: Could not look up instance type %s for MCI '%s' cloud %s: %s\n",
+					s.InstanceType, mciDef.Name, s.Cloud, err.Error()))
+			} else if it == nil {
 				errors = append(errors, fmt.Errorf("Cannot find instance type %s for MCI '%s' cloud %s",
-					s.InstanceType, mciDef.Name, mciDef.Settings[i].Cloud))
+					s.InstanceType, mciDef.Name, s.Cloud))
+			} else {
+				mciDef.Settings[i].instanceTypeHref = it.Href
 			}
 
-			apiParams := rsapi.APIParams{"filter": []string{"resource_uid==" + s.Image}}
-			images, err := client.ImageLocator(mciDef.Settings[i].cloudHref + "/images").Index(apiParams)
+			image, err := getCatalog().Image(cloud.Href, s.Image)
 			if err != nil {
-				errors = append(errors, fmt.Errorf("WARNING: Could not complete API call for MCI '%s' cloud %s: : %s\n",
-					err.Error(), mciDef.Name, mciDef.Settings[i].Cloud))
-			}
-			if len(images) < 1 {
+				errors = append(errors, fmt.Errorf("WARNING: Could not complete API call for MCI '%s' cloud %s: %s\n",
+					mciDef.Name, s.Cloud, err.Error()))
+			} else if image == nil {
 				errors = append(errors, fmt.Errorf("Cannot find image with resource_uid %s for MCI '%s' cloud %s",
-					s.Image, mciDef.Name, mciDef.Settings[i].Cloud))
+					s.Image, mciDef.Name, s.Cloud))
 			} else {
-				mciDef.Settings[i].imageHref = getLink(images[0].Links, "self")
+				mciDef.Settings[i].imageHref = image.Href
 			}
-
 		}
+	} else if getCatalog().Offline {
+		fmt.Printf("WARNING: Not validating MCI '%s' in offline mode\n", mciDef.Name)
 	} else if mciDef.Publisher != "" {
 		pub, err := findPublication("MultiCloudImage", mciDef.Name, int(mciDef.Revision),
 			map[string]string{`Publisher`: mciDef.Publisher})
@@ -189,7 +272,10 @@ func validateMultiCloudImage(mciDef *MultiCloudImage) (errors []error) {
 	return
 }
 
-func downloadMultiCloudImages(st *cm15.ServerTemplate, downloadMciSettings bool) ([]*MultiCloudImage, error) {
+// downloadMultiCloudImages gets the MCIs of a ServerTemplate, as Name/Revision(/Publisher) references or, with
+// downloadMciSettings, as fully managed MCIs with their settings. If referencePublished is also set, MCIs which are
+// published in the MultiCloud Marketplace are still referenced by their publication.
+func downloadMultiCloudImages(st *cm15.ServerTemplate, downloadMciSettings, referencePublished bool) ([]*MultiCloudImage, error) {
 	client, _ := Config.Account.Client15()
 
 	defaultMciHref := getLink(st.Links, "default_multi_cloud_image")
@@ -202,260 +288,304 @@ func downloadMultiCloudImages(st *cm15.ServerTemplate, downloadMciSettings bool)
 	}
 	mciImages := make([]*MultiCloudImage, 0)
 	for _, mci := range apiMcis {
+		var mciImage *MultiCloudImage
 		if downloadMciSettings {
-			tags, err := getTagsByHref(getLink(mci.Links, "self"))
-			if err != nil {
-				return nil, fmt.Errorf("Could not get tags for MultiCloudImage '%s': %s\n", getLink(mci.Links, "self"), err.Error())
-			}
-
-			settingsLoc := client.MultiCloudImageSettingLocator(getLink(mci.Links, "settings"))
-			settings, err := settingsLoc.Index(rsapi.APIParams{})
-			if err != nil {
-				return nil, fmt.Errorf("Could not get MultiCloudImage settings %s: %s\n", getLink(mci.Links, "settings"), err.Error())
-			}
-			mciSettings := make([]*Setting, 0)
-			for _, s := range settings {
-				cloud, err := client.CloudLocator(getLink(s.Links, "cloud")).Show(rsapi.APIParams{})
+			if referencePublished {
+				reference, err := downloadMultiCloudImageReference(mci)
 				if err != nil {
-					if strings.Contains(err.Error(), "ResourceNotFound") {
-						fmt.Printf("WARNING: For MCI '%s', skipping setting for cloud %s: cloud isn't registered in this account.\n",
-							mci.Name, getLink(s.Links, "cloud"))
-						continue
-					} else {
-						return nil, fmt.Errorf("Could not complete API call for MCI '%s' cloud %s: %s\n",
-							mci.Name, getLink(s.Links, "cloud"), err.Error())
-					}
+					return nil, err
 				}
-				if getLink(s.Links, "instance_type") == "" {
-					fmt.Printf("WARNING: For MCI '%s', skipping setting for cloud %s: fingerprinted MCIs not supported by this tool.\n",
-						mci.Name, cloud.Name)
-					continue
+				if reference.Publisher != "" {
+					fmt.Printf("Not downloading settings for MCI '%s', using Revision %s, Publisher '%s' from the MultiCloud Marketplace\n",
+						reference.Name, formatRev(int(reference.Revision)), reference.Publisher)
+					mciImage = reference
 				}
-				instanceType, err := client.InstanceTypeLocator(getLink(s.Links, "instance_type")).Show(rsapi.APIParams{})
+			}
+			if mciImage == nil {
+				mciImage, err = downloadMultiCloudImageSettings(mci)
 				if err != nil {
-					return nil, fmt.Errorf("Could not complete API call for MCI '%s' cloud %s: %s\n", mci.Name, cloud.Name, err.Error())
+					return nil, err
 				}
-				image, err := client.ImageLocator(getLink(s.Links, "image")).Show(rsapi.APIParams{})
-				if err != nil {
-					fmt.Printf("WARNING: Could not complete API call for MCI '%s' cloud %s: %s\n", mci.Name, cloud.Name, err.Error())
+				if mciImage == nil {
+					fmt.Printf("WARNING: skipping MCI '%s', contains no usable settings\n", mci.Name)
 					continue
 				}
-
-				mciSetting := Setting{Cloud: cloud.Name, InstanceType: instanceType.ResourceUid, Image: image.ResourceUid}
-				mciSettings = append(mciSettings, &mciSetting)
-			}
-			if len(mciSettings) > 0 {
-				// Default MCI is the first in the list
-				mciImage := MultiCloudImage{
-					Name:        mci.Name,
-					Tags:        tags,
-					Description: removeCarriageReturns(mci.Description),
-					Settings:    mciSettings,
-				}
-				if getLink(mci.Links, "self") == defaultMciHref {
-					mciImages = append([]*MultiCloudImage{&mciImage}, mciImages...)
-				} else {
-					mciImages = append(mciImages, &mciImage)
-				}
-			} else {
-				fmt.Printf("WARNING: skipping MCI '%s', contains no usable settings\n", mci.Name)
 			}
 		} else {
-			// We repull the MCI here to get the description field, which we need to break ties between
-			// similarly named publications!
-			mciLoc := client.MultiCloudImageLocator(getLink(mci.Links, "self"))
-			mci, err := mciLoc.Show()
+			mciImage, err = downloadMultiCloudImageReference(mci)
 			if err != nil {
-				return nil, fmt.Errorf("Could not get MultiCloudImage %s: %s\n", getLink(mci.Links, "self"), err.Error())
-			}
-			pub, err := findPublication("MultiCloudImage", mci.Name, mci.Revision, map[string]string{`Description`: mci.Description})
-			if err != nil {
-				return nil, fmt.Errorf("Error finding publication: %s\n", err.Error())
-			}
-			mciImage := MultiCloudImage{Name: mci.Name, Revision: RsRevision(mci.Revision)}
-			if pub != nil {
-				mciImage.Publisher = pub.Publisher
-			}
-			// Default MCI is the first in the list
-			if getLink(mci.Links, "self") == defaultMciHref {
-				mciImages = append([]*MultiCloudImage{&mciImage}, mciImages...)
-			} else {
-				mciImages = append(mciImages, &mciImage)
+				return nil, err
 			}
 		}
-
+		// Default MCI is the first in the list
+		if getLink(mci.Links, "self") == defaultMciHref {
+			mciImages = append([]*MultiCloudImage{mciImage}, mciImages...)
+		} else {
+			mciImages = append(mciImages, mciImage)
+		}
 	}
 
 	return mciImages, nil
 }
 
-func uploadMultiCloudImages(stDef *ServerTemplate, prefix string) error {
+// downloadMultiCloudImageSettings gets an MCI along with its tags and settings so it can be recreated. It returns nil
+// if the MCI has no settings usable by this tool.
+func downloadMultiCloudImageSettings(mci *cm15.MultiCloudImage) (*MultiCloudImage, error) {
 	client, _ := Config.Account.Client15()
 
-	stMciLocator := client.ServerTemplateMultiCloudImageLocator("/api/server_template_multi_cloud_images")
+	tags, err := getTagsByHref(getLink(mci.Links, "self"))
+	if err != nil {
+		return nil, fmt.Errorf("Could not get tags for MultiCloudImage '%s': %s\n", getLink(mci.Links, "self"), err.Error())
+	}
+	tags = withoutRightStTags(tags)
 
-	existingMcis, err := stMciLocator.Index(rsapi.APIParams{"filter": []string{"server_template_href==" + stDef.href}})
+	settingsLoc := client.MultiCloudImageSettingLocator(getLink(mci.Links, "settings"))
+	settings, err := settingsLoc.Index(rsapi.APIParams{})
 	if err != nil {
-		return fmt.Errorf("Could not find MCIs with href %s: %s", stMciLocator.Href, err.Error())
+		return nil, fmt.Errorf("Could not get MultiCloudImage settings %s: %s\n", getLink(mci.Links, "settings"), err.Error())
+	}
+	mciSettings := make([]*Setting, 0)
+	for _, s := range settings {
+		cloud, err := getCatalog().Cloud(getLink(s.Links, "cloud"))
+		if err != nil {
+			return nil, fmt.Errorf("Could not complete API call for MCI '%s' cloud %s: %s\n",
+				mci.Name, getLink(s.Links, "cloud"), err.Error())
+		}
+		if cloud == nil {
+			fmt.Printf("WARNING: For MCI '%s', skipping setting for cloud %s: cloud isn't registered in this account.\n",
+				mci.Name, getLink(s.Links, "cloud"))
+			continue
+		}
+		if getLink(s.Links, "instance_type") == "" {
+			fmt.Printf("WARNING: For MCI '%s', skipping setting for cloud %s: fingerprinted MCIs not supported by this tool.\n",
+				mci.Name, cloud.Name)
+			continue
+		}
+		instanceType, err := getCatalog().InstanceType(cloud.Href, getLink(s.Links, "instance_type"))
+		if err != nil {
+			return nil, fmt.Errorf("Could not complete API call for MCI '%s' cloud %s: %s\n", mci.Name, cloud.Name, err.Error())
+		}
+		if instanceType == nil {
+			return nil, fmt.Errorf("Could not find instance type %s for MCI '%s' cloud %s\n",
+				getLink(s.Links, "instance_type"), mci.Name, cloud.Name)
+		}
+		image, err := getCatalog().ImageByHref(cloud.Href, getLink(s.Links, "image"))
+		if err != nil {
+			fmt.Printf("WARNING: Could not complete API call for MCI '%s' cloud %s: %s\n", mci.Name, cloud.Name, err.Error())
+			continue
+		}
+
+		mciSetting := Setting{Cloud: cloud.Name, InstanceType: instanceType.ResourceUid, Image: image.ResourceUid}
+		mciSettings = append(mciSettings, &mciSetting)
+	}
+	if len(mciSettings) == 0 {
+		return nil, nil
+	}
+	return &MultiCloudImage{
+		Name:        mci.Name,
+		Tags:        tags,
+		Description: removeCarriageReturns(mci.Description),
+		Settings:    mciSettings,
+	}, nil
+}
+
+// downloadMultiCloudImageReference gets an MCI as a Name/Revision reference, with the Publisher set if it is published
+// in the MultiCloud Marketplace.
+func downloadMultiCloudImageReference(mci *cm15.MultiCloudImage) (*MultiCloudImage, error) {
+	client, _ := Config.Account.Client15()
+
+	// We repull the MCI here to get the description field, which we need to break ties between
+	// similarly named publications!
+	mciLoc := client.MultiCloudImageLocator(getLink(mci.Links, "self"))
+	mci, err := mciLoc.Show()
+	if err != nil {
+		return nil, fmt.Errorf("Could not get MultiCloudImage %s: %s\n", mciLoc.Href, err.Error())
+	}
+	pub, err := findPublication("MultiCloudImage", mci.Name, mci.Revision, map[string]string{`Description`: mci.Description})
+	if err != nil {
+		return nil, fmt.Errorf("Error finding publication: %s\n", err.Error())
+	}
+	mciImage := MultiCloudImage{Name: mci.Name, Revision: RsRevision(mci.Revision)}
+	if pub != nil {
+		mciImage.Publisher = pub.Publisher
 	}
+	return &mciImage, nil
+}
+
+// importMultiCloudImage finds the MultiCloudImage a publication in the MultiCloud Marketplace was imported as, importing
+// it first if it was not imported yet, and sets its Href.
+func importMultiCloudImage(mciDef *MultiCloudImage) error {
+	client, _ := Config.Account.Client15()
 
 	// Algorithm for linking Publications:
 	//   1. For MultiCloudImages with a publication, find the publications first. Get the name/description/publisher
 	//   2. If we don't find it, throw an error
 	//   3. Get the imported MultiCloudImages. If it doesn't exist, import it then get the href
 	//   4. Insert HREF into r struct for later use.
-	for _, mciDef := range stDef.MultiCloudImages {
-		if mciDef.Publisher != "" {
-			pub, err := findPublication("MultiCloudImage", mciDef.Name, int(mciDef.Revision),
-				map[string]string{`Publisher`: mciDef.Publisher})
-			if err != nil {
-				return fmt.Errorf("Could not lookup publication %s", err.Error())
-			}
-			if pub == nil {
-				return fmt.Errorf("Could not find a publication in the MultiCloud Marketplace for MultiCloudImage '%s' Revision %s Publisher '%s'",
-					mciDef.Name, formatRev(int(mciDef.Revision)), mciDef.Publisher)
-			}
+	pub, err := findPublication("MultiCloudImage", mciDef.Name, int(mciDef.Revision),
+		map[string]string{`Publisher`: mciDef.Publisher})
+	if err != nil {
+		return fmt.Errorf("Could not lookup publication %s", err.Error())
+	}
+	if pub == nil {
+		return fmt.Errorf("Could not find a publication in the MultiCloud Marketplace for MultiCloudImage '%s' Revision %s Publisher '%s'",
+			mciDef.Name, formatRev(int(mciDef.Revision)), mciDef.Publisher)
+	}
 
-			mciLocator := client.MultiCloudImageLocator("/api/multi_cloud_images")
-			filters := []string{
-				"name==" + mciDef.Name,
-			}
+	mciLocator := client.MultiCloudImageLocator("/api/multi_cloud_images")
+	filters := []string{
+		"name==" + mciDef.Name,
+	}
 
-			mciUnfiltered, err := mciLocator.Index(rsapi.APIParams{"filter": filters})
-			if err != nil {
-				return fmt.Errorf("Error looking up MCI: %s", err.Error())
-			}
-			for _, mci := range mciUnfiltered {
-				// Recheck the name here, filter does a partial match and we need an exact one.
-				// Matching the descriptions helps to disambiguate if we have multiple publications
-				// with that same name/revision pair.
-				if mci.Name == mciDef.Name && mci.Revision == pub.Revision && mci.Description == pub.Description {
-					mciDef.Href = getLink(mci.Links, "self")
-				}
-			}
+	mciUnfiltered, err := mciLocator.Index(rsapi.APIParams{"filter": filters})
+	if err != nil {
+		return fmt.Errorf("Error looking up MCI: %s", err.Error())
+	}
+	for _, mci := range mciUnfiltered {
+		// Recheck the name here, filter does a partial match and we need an exact one.
+		// Matching the descriptions helps to disambiguate if we have multiple publications
+		// with that same name/revision pair.
+		if mci.Name == mciDef.Name && mci.Revision == pub.Revision && mci.Description == pub.Description {
+			mciDef.Href = getLink(mci.Links, "self")
+		}
+	}
 
-			if mciDef.Href == "" {
-				loc := pub.Locator(client)
+	if mciDef.Href == "" {
+		loc := pub.Locator(client)
 
-				err = loc.Import()
+		err = loc.Import()
 
-				if err != nil {
-					return fmt.Errorf("Failed to import publication %s for MultiCloudImage '%s' Revision %s Publisher %s\n",
-						getLink(pub.Links, "self"), mciDef.Name, formatRev(int(mciDef.Revision)), mciDef.Publisher)
-				}
+		if err != nil {
+			return fmt.Errorf("Failed to import publication %s for MultiCloudImage '%s' Revision %s Publisher %s\n",
+				getLink(pub.Links, "self"), mciDef.Name, formatRev(int(mciDef.Revision)), mciDef.Publisher)
+		}
 
-				mciUnfiltered, err := mciLocator.Index(rsapi.APIParams{"filter": filters})
-				if err != nil {
-					return fmt.Errorf("Error looking up MCI: %s", err.Error())
-				}
-				for _, mci := range mciUnfiltered {
-					if mci.Name == mciDef.Name && mci.Revision == pub.Revision && mci.Description == pub.Description {
-						mciDef.Href = getLink(mci.Links, "self")
-					}
-				}
-				if mciDef.Href == "" {
-					return fmt.Errorf("Could not refind MultiCloudImage '%s' Revision %s after import!", mciDef.Name, formatRev(pub.Revision))
-				}
+		mciUnfiltered, err := mciLocator.Index(rsapi.APIParams{"filter": filters})
+		if err != nil {
+			return fmt.Errorf("Error looking up MCI: %s", err.Error())
+		}
+		for _, mci := range mciUnfiltered {
+			if mci.Name == mciDef.Name && mci.Revision == pub.Revision && mci.Description == pub.Description {
+				mciDef.Href = getLink(mci.Links, "self")
 			}
 		}
+		if mciDef.Href == "" {
+			return fmt.Errorf("Could not refind MultiCloudImage '%s' Revision %s after import!", mciDef.Name, formatRev(pub.Revision))
+		}
 	}
+	return nil
+}
 
-	// For MCIs managed by us, we create them as needed. All Hrefs to cloud/instance type objects should be resolved
-	// during the validation step, so we should be good to go
-	for _, mciDef := range stDef.MultiCloudImages {
-		if len(mciDef.Settings) > 0 {
-			mciName := mciDef.Name
-			if prefix != "" {
-				mciName = fmt.Sprintf("%s_%s", prefix, mciName)
-			}
+// uploadMultiCloudImage creates or updates a MultiCloudImage managed by us along with its settings and sets its Href.
+// All Hrefs to cloud/instance type objects should be resolved during the validation step, so we should be good to go.
+// source is the file it is defined in.
+func uploadMultiCloudImage(mciDef *MultiCloudImage, source, prefix string) error {
+	client, _ := Config.Account.Client15()
+
+	mciName := devName(mciDef.Name, prefix)
 
-			href, err := paramToHref("multi_cloud_images", mciName, 0, false)
-			if err != nil && !strings.Contains(err.Error(), "Found no multi_cloud_images matching") {
-				return fmt.Errorf("API call to find MultiCloudImage '%s' failed: %s", mciName, err.Error())
+	href, err := paramToHref("multi_cloud_images", mciName, 0, false)
+	if err != nil && !strings.Contains(err.Error(), "Found no multi_cloud_images matching") {
+		return fmt.Errorf("API call to find MultiCloudImage '%s' failed: %s", mciName, err.Error())
+	}
+	created := href == ""
+	if created {
+		createParams := cm15.MultiCloudImageParam{Description: mciDef.Description, Name: mciName}
+		loc, err := client.MultiCloudImageLocator("/api/multi_cloud_images").Create(&createParams)
+		if err != nil {
+			return fmt.Errorf("API call to create MultiCloudImage '%s' failed: %s", mciName, err.Error())
+		}
+		href = string(loc.Href)
+		fmt.Printf("  Created MultiCloudImage with name '%s': %s\n", mciName, href)
+	} else {
+		mci, err := client.MultiCloudImageLocator(href).Show()
+		if err != nil {
+			return fmt.Errorf("API call failed: %s", err.Error())
+		}
+		fmt.Printf("  Updating MultiCloudImage '%s'\n", mciName)
+		if mci.Description != mciDef.Description {
+			err := mci.Locator(client).Update(&cm15.MultiCloudImageParam{Description: mciDef.Description})
+			if err != nil {
+				return fmt.Errorf("Failed to update MultiCloudImage '%s' description: %s", mciName, err.Error())
 			}
-			if href == "" {
-				createParams := cm15.MultiCloudImageParam{Description: mciDef.Description, Name: mciName}
-				loc, err := client.MultiCloudImageLocator("/api/multi_cloud_images").Create(&createParams)
-				if err != nil {
-					return fmt.Errorf("API call to create MultiCloudImage '%s' failed: %s", mciName, err.Error())
+		}
+	}
+	mciDef.Href = href
+
+	err = setTagsByHref(mciDef.Href, mciDef.Tags)
+	if err != nil {
+		return fmt.Errorf("Failed to add tags to MultiCloudImage '%s': %s", mciDef.Href, err.Error())
+	}
+	if err := claimOwnership("multi_cloud_images", mciName, mciDef.Href, ownerOf(source, prefix), created); err != nil {
+		return fmt.Errorf("Failed to tag MultiCloudImage '%s': %s", mciDef.Href, err.Error())
+	}
+	// get existing settings
+	settingsLoc := client.MultiCloudImageSettingLocator(mciDef.Href + "/settings")
+	settings, err := settingsLoc.Index(rsapi.APIParams{})
+	if err != nil {
+		return fmt.Errorf("Could not get MultiCloudImage settings %s: %s", mciDef.Href, err.Error())
+	}
+	seenSettings := make(map[string]bool)
+
+	for _, s := range mciDef.Settings {
+		// for each desired setting, if existing setting with same cloud exists, update it. else add it.
+		updated := false
+		seenSettings[s.cloudHref] = true
+		for _, s2 := range settings {
+			if s.cloudHref == getLink(s2.Links, "cloud") {
+				updateParams := cm15.MultiCloudImageSettingParam{
+					CloudHref:        s.cloudHref,
+					ImageHref:        s.imageHref,
+					InstanceTypeHref: s.instanceTypeHref,
+					UserData:         s.UserData,
+					// unsupported: KernelImageHref, RamdiskImageHref
 				}
-				href = string(loc.Href)
-				fmt.Printf("  Created MultiCloudImage with name '%s': %s\n", mciName, href)
-			} else {
-				mci, err := client.MultiCloudImageLocator(href).Show()
+
+				err := s2.Locator(client).Update(&updateParams)
 				if err != nil {
-					return fmt.Errorf("API call failed: %s", err.Error())
-				}
-				fmt.Printf("  Updating MultiCloudImage '%s'\n", mciName)
-				if mci.Description != mciDef.Description {
-					err := mci.Locator(client).Update(&cm15.MultiCloudImageParam{Description: mciDef.Description})
-					if err != nil {
-						return fmt.Errorf("Failed to update MultiCloudImage '%s' description: %s", mciName, err.Error())
-					}
+					return fmt.Errorf("Could not update MultiCloudImage setting %s: %s", getLink(s2.Links, "self"), err.Error())
 				}
+				updated = true
 			}
-			mciDef.Href = href
-
-			err = setTagsByHref(mciDef.Href, mciDef.Tags)
+		}
+		if !updated {
+			createParams := cm15.MultiCloudImageSettingParam{
+				CloudHref:        s.cloudHref,
+				ImageHref:        s.imageHref,
+				InstanceTypeHref: s.instanceTypeHref,
+				UserData:         s.UserData,
+				// unsupported: KernelImageHref, RamdiskImageHref
+			}
+			_, err := settingsLoc.Create(&createParams)
 			if err != nil {
-				return fmt.Errorf("Failed to add tags to MultiCloudImage '%s': %s", mciDef.Href, err.Error())
+				return fmt.Errorf("Could not create MultiCloudImage setting %s: %s", mciDef.Href, err.Error())
 			}
-			// get existing settings
-			settingsLoc := client.MultiCloudImageSettingLocator(mciDef.Href + "/settings")
-			settings, err := settingsLoc.Index(rsapi.APIParams{})
+		}
+	}
+	// for existing settings not in desired settings, remove them
+	for _, s := range settings {
+		if !seenSettings[getLink(s.Links, "cloud")] {
+			err := s.Locator(client).Destroy()
 			if err != nil {
-				fatalError("Could not get MultiCloudImage settings %s: %s\n", mciDef.Href, err.Error())
+				return fmt.Errorf("Could not Remove MCI Setting for MCI '%s' with cloud %s: %s",
+					mciName, getLink(s.Links, "cloud"), err.Error())
 			}
-			seenSettings := make(map[string]bool)
-
-			for _, s := range mciDef.Settings {
-				// for each desired setting, if existing setting with same cloud exists, update it. else add it.
-				updated := false
-				seenSettings[s.cloudHref] = true
-				for _, s2 := range settings {
-					if s.cloudHref == getLink(s2.Links, "cloud") {
-						updateParams := cm15.MultiCloudImageSettingParam{
-							CloudHref:        s.cloudHref,
-							ImageHref:        s.imageHref,
-							InstanceTypeHref: s.instanceTypeHref,
-							UserData:         s.UserData,
-							// unsupported: KernelImageHref, RamdiskImageHref
-						}
-
-						err := s2.Locator(client).Update(&updateParams)
-						if err != nil {
-							fatalError("Could not update MultiCloudImage setting %s: %s\n", getLink(s2.Links, "self"), err.Error())
-						}
-						updated = true
-					}
-				}
-				if !updated {
-					createParams := cm15.MultiCloudImageSettingParam{
-						CloudHref:        s.cloudHref,
-						ImageHref:        s.imageHref,
-						InstanceTypeHref: s.instanceTypeHref,
-						UserData:         s.UserData,
-						// unsupported: KernelImageHref, RamdiskImageHref
-					}
-					_, err := settingsLoc.Create(&createParams)
-					if err != nil {
-						fatalError("Could not create MultiCloudImage setting %s: %s\n", mciDef.Href, err.Error())
-					}
-				}
-			}
-			// for existing settings not in desired settings, remove them
-			for _, s := range settings {
-				if !seenSettings[getLink(s.Links, "cloud")] {
-					err := s.Locator(client).Destroy()
-					if err != nil {
-						fatalError("  Could not Remove MCI Setting for MCI '%s' with cloud %s: %s",
-							mciName, getLink(s.Links, "cloud"), err.Error())
-					}
-				}
-			}
-
 		}
 	}
+	return nil
+}
+
+// attachMultiCloudImages makes the MCIs of a ServerTemplate the ones in its definition, with the first one as the
+// default. The Href of every MCI must already be set by importMultiCloudImage or uploadMultiCloudImage.
+func attachMultiCloudImages(stDef *ServerTemplate, prefix string) error {
+	client, _ := Config.Account.Client15()
+
+	stMciLocator := client.ServerTemplateMultiCloudImageLocator("/api/server_template_multi_cloud_images")
+
+	existingMcis, err := stMciLocator.Index(rsapi.APIParams{"filter": []string{"server_template_href==" + stDef.href}})
+	if err != nil {
+		return fmt.Errorf("Could not find MCIs with href %s: %s", stMciLocator.Href, err.Error())
+	}
 
 	// Delete MCIs that exist on the existing ST but not in this definition. We perform all the deletions first so
 	// we don't have to worry about reading the same MCI with a different revision and throwing an error later
@@ -480,7 +610,7 @@ func uploadMultiCloudImages(stDef *ServerTemplate, prefix string) error {
 	if len(existingMcis) > 0 && firstValidMci == nil {
 		b := make([]byte, 16)
 		if _, err := rand.Read(b); err != nil {
-			fatalError("Failed to read random bytes: %v", err)
+			return fmt.Errorf("Failed to read random bytes: %v", err)
 		}
 
 		mciLocator := client.MultiCloudImageLocator("/api/multi_cloud_images")
@@ -490,7 +620,7 @@ func uploadMultiCloudImages(stDef *ServerTemplate, prefix string) error {
 			Description: "This is a dummy MCI created by right_st when replacing MCIs on a ServerTemplate, it is safe to delete.",
 		})
 		if err != nil {
-			fatalError("Failed to create dummy MCI: %v", err)
+			return fmt.Errorf("Failed to create dummy MCI: %v", err)
 		}
 		params := cm15.ServerTemplateMultiCloudImageParam{
 			MultiCloudImageHref: string(dummyMci.Href),
@@ -498,7 +628,7 @@ func uploadMultiCloudImages(stDef *ServerTemplate, prefix string) error {
 		}
 		loc, err := stMciLocator.Create(&params)
 		if err != nil {
-			fatalError("  Failed to associate Dummy MCI '%v' with ServerTemplate '%v': %v", dummyMci.Href, stDef.href, err)
+			return fmt.Errorf("Failed to associate Dummy MCI '%v' with ServerTemplate '%v': %v", dummyMci.Href, stDef.href, err)
 		}
 		firstValidMci = loc
 		defer func() {
@@ -518,12 +648,12 @@ func uploadMultiCloudImages(stDef *ServerTemplate, prefix string) error {
 			fmt.Printf("  Removing MCI %s\n", mciHref)
 			if mci.IsDefault {
 				if err := firstValidMci.MakeDefault(); err != nil {
-					fatalError("  Failed to make temporary MCI the default for ServerTemplate '%v': %v", stDef.href, err)
+					return fmt.Errorf("Failed to make temporary MCI the default for ServerTemplate '%v': %v", stDef.href, err)
 				}
 			}
 			err := mci.Locator(client).Destroy()
 			if err != nil {
-				fatalError("  Could not Remove MCI %s", mciHref)
+				return fmt.Errorf("Could not Remove MCI %s", mciHref)
 			}
 		}
 	}
@@ -536,7 +666,7 @@ func uploadMultiCloudImages(stDef *ServerTemplate, prefix string) error {
 			if mciDef.Href == mciHref {
 				if i == 0 && !mci.IsDefault {
 					if err := mci.Locator(client).MakeDefault(); err != nil {
-						fatalError("  Failed to make MCI '%v' the default for ServerTemplate '%v': %v", mciDef.Href, stDef.href, err)
+						return fmt.Errorf("Failed to make MCI '%v' the default for ServerTemplate '%v': %v", mciDef.Href, stDef.href, err)
 					}
 				}
 				foundMci = true
@@ -548,22 +678,22 @@ func uploadMultiCloudImages(stDef *ServerTemplate, prefix string) error {
 				ServerTemplateHref:  stDef.href,
 			}
 			mciName := mciDef.Name
-			if prefix != "" && len(mciDef.Settings) > 0 {
-				mciName = fmt.Sprintf("%s_%s", prefix, mciName)
+			if len(mciDef.Settings) > 0 {
+				mciName = devName(mciDef.Name, prefix)
 			}
 			fmt.Printf("  Adding MCI '%s' revision %s (%s)\n", mciName, formatRev(int(mciDef.Revision)), mciDef.Href)
 			loc, err := stMciLocator.Create(&params)
 			if err != nil {
-				fatalError("  Failed to associate MCI '%s' with ServerTemplate '%s': %s", mciDef.Href, stDef.href, err.Error())
+				return fmt.Errorf("Failed to associate MCI '%s' with ServerTemplate '%s': %s", mciDef.Href, stDef.href, err.Error())
 			}
 			if i == 0 {
 				mci, err := loc.Show(rsapi.APIParams{})
 				if err != nil {
-					fatalError("  Failed to show MCI ServerTemplate '%v': %v", loc.Href, err)
+					return fmt.Errorf("Failed to show MCI ServerTemplate '%v': %v", loc.Href, err)
 				}
 				if !mci.IsDefault {
 					if err := loc.MakeDefault(); err != nil {
-						fatalError("  Failed to make MCI '%v' the default for ServerTemplate '%v': %v", mciDef.Href, stDef.href, err)
+						return fmt.Errorf("Failed to make MCI '%v' the default for ServerTemplate '%v': %v", mciDef.Href, stDef.href, err)
 					}
 				}
 			}
@@ -0,0 +1,136 @@
+package main_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	. "github.com/rightscale/right_st"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("MultiCloudImage", func() {
+	Describe("ExpandMultiCloudImages", func() {
+		var tempDir string
+
+		BeforeEach(func() {
+			var err error
+			tempDir, err = ioutil.TempDir("", "mci")
+			if err != nil {
+				panic(err)
+			}
+		})
+
+		AfterEach(func() {
+			os.RemoveAll(tempDir)
+		})
+
+		Context("With a settings matrix", func() {
+			It("expands one setting per cloud in order", func() {
+				mcis := []*MultiCloudImage{{
+					Name: "Matrix MCI",
+					Matrix: &SettingsMatrix{
+						Clouds:        []string{"EC2 us-west-2", "EC2 us-east-1", "AzureRM West US"},
+						InstanceType:  "m3.medium",
+						InstanceTypes: map[string]string{"AzureRM West US": "Standard_D1_v2"},
+						Images: map[string]string{
+							"EC2 us-east-1":   "ami-11111111",
+							"EC2 us-west-2":   "ami-22222222",
+							"AzureRM West US": "Canonical/UbuntuServer/16.04-LTS/latest",
+						},
+						UserData: "Foo",
+					},
+				}}
+				expanded, err := ExpandMultiCloudImages(tempDir, mcis)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(expanded).To(HaveLen(1))
+				Expect(expanded[0].Matrix).To(BeNil())
+				Expect(expanded[0].Settings).To(Equal([]*Setting{
+					{Cloud: "EC2 us-west-2", InstanceType: "m3.medium", Image: "ami-22222222", UserData: "Foo"},
+					{Cloud: "EC2 us-east-1", InstanceType: "m3.medium", Image: "ami-11111111", UserData: "Foo"},
+					{Cloud: "AzureRM West US", InstanceType: "Standard_D1_v2", Image: "Canonical/UbuntuServer/16.04-LTS/latest", UserData: "Foo"},
+				}))
+			})
+
+			It("uses the sorted image clouds when no clouds are listed", func() {
+				mcis := []*MultiCloudImage{{
+					Name: "Matrix MCI",
+					Matrix: &SettingsMatrix{
+						InstanceType: "m3.medium",
+						Images: map[string]string{
+							"EC2 us-west-2": "ami-22222222",
+							"EC2 us-east-1": "ami-11111111",
+						},
+					},
+				}}
+				expanded, err := ExpandMultiCloudImages(tempDir, mcis)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(expanded[0].Settings).To(Equal([]*Setting{
+					{Cloud: "EC2 us-east-1", InstanceType: "m3.medium", Image: "ami-11111111"},
+					{Cloud: "EC2 us-west-2", InstanceType: "m3.medium", Image: "ami-22222222"},
+				}))
+			})
+
+			It("appends to explicit settings", func() {
+				mcis := []*MultiCloudImage{{
+					Name:     "Matrix MCI",
+					Settings: []*Setting{{Cloud: "Google", InstanceType: "n1-standard-1", Image: "ubuntu-1604"}},
+					Matrix: &SettingsMatrix{
+						InstanceType: "m3.medium",
+						Images:       map[string]string{"EC2 us-east-1": "ami-11111111"},
+					},
+				}}
+				expanded, err := ExpandMultiCloudImages(tempDir, mcis)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(expanded[0].Settings).To(Equal([]*Setting{
+					{Cloud: "Google", InstanceType: "n1-standard-1", Image: "ubuntu-1604"},
+					{Cloud: "EC2 us-east-1", InstanceType: "m3.medium", Image: "ami-11111111"},
+				}))
+			})
+
+			It("names the cloud missing an image", func() {
+				mcis := []*MultiCloudImage{{
+					Name: "Matrix MCI",
+					Matrix: &SettingsMatrix{
+						Clouds:       []string{"EC2 us-east-1", "EC2 us-west-2"},
+						InstanceType: "m3.medium",
+						Images:       map[string]string{"EC2 us-east-1": "ami-11111111"},
+					},
+				}}
+				_, err := ExpandMultiCloudImages(tempDir, mcis)
+				Expect(err).To(MatchError("Matrix for MCI 'Matrix MCI' has no image for cloud EC2 us-west-2"))
+			})
+
+			It("names the cloud that duplicates an explicit setting", func() {
+				mcis := []*MultiCloudImage{{
+					Name:     "Matrix MCI",
+					Settings: []*Setting{{Cloud: "EC2 us-east-1", InstanceType: "m3.large", Image: "ami-33333333"}},
+					Matrix: &SettingsMatrix{
+						InstanceType: "m3.medium",
+						Images:       map[string]string{"EC2 us-east-1": "ami-11111111"},
+					},
+				}}
+				_, err := ExpandMultiCloudImages(tempDir, mcis)
+				Expect(err).To(MatchError("Matrix for MCI 'Matrix MCI' cloud EC2 us-east-1 duplicates another setting for the same cloud"))
+			})
+
+			It("expands a matrix from an MCI file", func() {
+				err := ioutil.WriteFile(filepath.Join(tempDir, "matrix.yml"), []byte(`Name: Matrix MCI
+Matrix:
+  Instance Type: m3.medium
+  Images:
+    EC2 us-east-1: ami-11111111
+`), 0644)
+				Expect(err).NotTo(HaveOccurred())
+				expanded, err := ExpandMultiCloudImages(tempDir, []*MultiCloudImage{{File: "matrix.yml"}})
+				Expect(err).NotTo(HaveOccurred())
+				Expect(expanded[0].Name).To(Equal("Matrix MCI"))
+				Expect(expanded[0].Settings).To(Equal([]*Setting{
+					{Cloud: "EC2 us-east-1", InstanceType: "m3.medium", Image: "ami-11111111"},
+				}))
+			})
+		})
+	})
+})
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"text/tabwriter"
+)
+
+// multipleAccountCommands are the commands which may be run for more than one account with a comma separated
+// --account or --all-accounts.
+var multipleAccountCommands = map[string]bool{
+	stUploadCmd.FullCommand():          true,
+	stValidateCmd.FullCommand():        true,
+	stCommitCmd.FullCommand():          true,
+	rightScriptUploadCmd.FullCommand(): true,
+}
+
+// accountFailure is what exit panics with while a command is run for more than one account so a failure only ends
+// the run for the current account.
+type accountFailure struct {
+	code int
+}
+
+// runningForAccounts is set while RunForAccounts runs a command.
+var runningForAccounts bool
+
+// exitCleanups are run by exit before it ends the process, since deferred calls do not run then.
+var (
+	exitCleanups     = make(map[int]func())
+	exitCleanupsLock sync.Mutex
+	nextExitCleanup  int
+)
+
+// onExit registers a cleanup, such as removing a temporary directory, to run if exit ends the process. It returns a
+// function which runs the cleanup and unregisters it for deferring when the command returns normally.
+func onExit(cleanup func()) func() {
+	exitCleanupsLock.Lock()
+	defer exitCleanupsLock.Unlock()
+	id := nextExitCleanup
+	nextExitCleanup++
+	exitCleanups[id] = cleanup
+	return func() {
+		exitCleanupsLock.Lock()
+		delete(exitCleanups, id)
+		exitCleanupsLock.Unlock()
+		cleanup()
+	}
+}
+
+// exit ends the process with a status code or, when running for more than one account, just the run for the
+// current account.
+func exit(code int) {
+	if runningForAccounts {
+		panic(accountFailure{code})
+	}
+	exitCleanupsLock.Lock()
+	for _, cleanup := range exitCleanups {
+		cleanup()
+	}
+	exitCleanupsLock.Unlock()
+	os.Exit(code)
+}
+
+// AccountResult is the outcome of running a command for one account. Skipped is set for the accounts which were not
+// run because an earlier one failed with --fail-fast.
+type AccountResult struct {
+	Name    string
+	Err     error
+	Skipped bool
+}
+
+// AccountNames returns the names of the accounts in a comma separated list, or every configured account in name order
+// if all is set.
+func (config *ConfigViper) AccountNames(list string, all bool) ([]string, error) {
+	if all {
+		if list != "" {
+			return nil, fmt.Errorf("--account and --all-accounts cannot be used together")
+		}
+		if len(config.Accounts) == 0 {
+			return nil, fmt.Errorf("no accounts are configured, add one with: config account <name>")
+		}
+		var names []string
+		for name := range config.Accounts {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return names, nil
+	}
+
+	var names []string
+	seen := make(map[string]bool)
+	for _, name := range strings.Split(list, ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" || seen[name] {
+			continue
+		}
+		if _, ok := config.Accounts[name]; !ok {
+			return nil, fmt.Errorf("could not find account: %s", name)
+		}
+		names = append(names, name)
+		seen[name] = true
+	}
+	if len(names) == 0 {
+		return nil, fmt.Errorf("no accounts given")
+	}
+	return names, nil
+}
+
+// switchAccount makes the named account the one commands use and checks that it can authenticate.
+func (config *ConfigViper) switchAccount(name string) error {
+	account, ok := config.Accounts[strings.ToLower(name)]
+	if !ok {
+		return fmt.Errorf("could not find account: %s", name)
+	}
+	config.Account = account
+	resetCatalog()
+	if _, err := account.Client15(); err != nil {
+		return fmt.Errorf("Authentication error for account %s: %s", name, err.Error())
+	}
+	return nil
+}
+
+// RunForAccounts runs a command once for each of the named accounts, switching Config.Account (and so the Client15
+// used) to each in turn, and prints a summary of how it went for each account. A failure in one account does not stop
+// the others unless failFast is set.
+func RunForAccounts(names []string, failFast bool, output io.Writer, run func() error) []*AccountResult {
+	account := Config.Account
+	runningForAccounts = true
+	defer func() {
+		runningForAccounts = false
+		Config.Account = account
+		resetCatalog()
+	}()
+
+	var results []*AccountResult
+	failed := false
+	for _, name := range names {
+		result := &AccountResult{Name: name}
+		results = append(results, result)
+		if failed && failFast {
+			result.Skipped = true
+			continue
+		}
+		fmt.Fprintf(output, "==> Account %s\n", name)
+		Config.Account = Config.Accounts[name]
+		resetCatalog()
+		result.Err = runForAccount(run)
+		if result.Err != nil {
+			failed = true
+		}
+	}
+
+	fmt.Fprintln(output, "Summary:")
+	tw := tabwriter.NewWriter(output, 0, 8, 2, ' ', 0)
+	for _, result := range results {
+		switch {
+		case result.Skipped:
+			fmt.Fprintf(tw, "  %s\tSKIPPED\n", result.Name)
+		case result.Err != nil:
+			fmt.Fprintf(tw, "  %s\tFAILED: %s\n", result.Name, result.Err.Error())
+		default:
+			fmt.Fprintf(tw, "  %s\tOK\n", result.Name)
+		}
+	}
+	tw.Flush()
+	return results
+}
+
+// AccountsSucceeded returns true if the command ran without failing for every account.
+func AccountsSucceeded(results []*AccountResult) bool {
+	for _, result := range results {
+		if result.Skipped || result.Err != nil {
+			return false
+		}
+	}
+	return true
+}
+
+func runForAccount(run func() error) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			failure, ok := r.(accountFailure)
+			if !ok {
+				panic(r)
+			}
+			err = fmt.Errorf("exited with status %d", failure.code)
+		}
+	}()
+	return run()
+}
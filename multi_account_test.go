@@ -0,0 +1,84 @@
+package main_test
+
+import (
+	"fmt"
+
+	. "github.com/rightscale/right_st"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/gbytes"
+)
+
+var _ = Describe("Multiple accounts", func() {
+	var (
+		buffer   *gbytes.Buffer
+		accounts map[string]*Account
+		account  *Account
+	)
+
+	BeforeEach(func() {
+		buffer = gbytes.NewBuffer()
+		accounts, account = Config.Accounts, Config.Account
+		Config.Accounts = map[string]*Account{
+			"production": {Id: 1, Host: "us-3.rightscale.com", RefreshToken: "abc"},
+			"staging":    {Id: 2, Host: "us-4.rightscale.com", RefreshToken: "def"},
+			"dev":        {Id: 3, Host: "us-4.rightscale.com", RefreshToken: "ghi"},
+		}
+		Config.Account = Config.Accounts["production"]
+	})
+
+	AfterEach(func() {
+		Config.Accounts, Config.Account = accounts, account
+	})
+
+	DescribeTable("account names",
+		func(list string, all bool, names []string, errorText string) {
+			result, err := Config.AccountNames(list, all)
+			if errorText != "" {
+				Expect(err).To(MatchError(errorText))
+			} else {
+				Expect(err).NotTo(HaveOccurred())
+				Expect(result).To(Equal(names))
+			}
+		},
+		Entry("a list", "staging, Production,staging", false, []string{"staging", "production"}, ""),
+		Entry("all accounts", "", true, []string{"dev", "production", "staging"}, ""),
+		Entry("an unknown account", "staging,qa", false, nil, "could not find account: qa"),
+		Entry("an empty list", " , ", false, nil, "no accounts given"),
+		Entry("a list and all accounts", "staging", true, nil, "--account and --all-accounts cannot be used together"),
+	)
+
+	It("runs for each account and summarizes the results", func() {
+		var ids []int
+		results := RunForAccounts([]string{"dev", "staging", "production"}, false, buffer, func() error {
+			ids = append(ids, Config.Account.Id)
+			if Config.Account.Id == 2 {
+				return fmt.Errorf("upload failed")
+			}
+			return nil
+		})
+		Expect(ids).To(Equal([]int{3, 2, 1}))
+		Expect(AccountsSucceeded(results)).To(BeFalse())
+		Expect(buffer).To(gbytes.Say(`==> Account dev`))
+		Expect(buffer).To(gbytes.Say(`==> Account staging`))
+		Expect(buffer).To(gbytes.Say(`==> Account production`))
+		Expect(buffer).To(gbytes.Say(`Summary:\n  dev +OK\n  staging +FAILED: upload failed\n  production +OK\n`))
+		Expect(Config.Account.Id).To(Equal(1))
+	})
+
+	It("skips the remaining accounts after a failure with fail fast", func() {
+		results := RunForAccounts([]string{"dev", "staging", "production"}, true, buffer, func() error {
+			if Config.Account.Id == 3 {
+				return fmt.Errorf("validation failed")
+			}
+			return nil
+		})
+		Expect(results).To(HaveLen(3))
+		Expect(results[1].Skipped).To(BeTrue())
+		Expect(results[2].Skipped).To(BeTrue())
+		Expect(buffer).NotTo(gbytes.Say(`==> Account staging`))
+		Expect(buffer.Contents()).To(ContainSubstring("staging     SKIPPED"))
+	})
+})
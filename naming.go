@@ -0,0 +1,140 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"strings"
+	"sync"
+	"text/template"
+)
+
+// DefaultNamingTemplate is how dev/test versions are named unless naming.template is set in the configuration.
+const DefaultNamingTemplate = "{{.Prefix}}_{{.Name}}"
+
+// tagNamespace is the namespace of the machine tags right_st records on what it creates. setTagsByHref leaves tags in
+// this namespace alone and they are not downloaded with MultiCloudImages.
+const tagNamespace = "right_st:"
+
+// prefixTag records the prefix an object was uploaded with so it can be found again however it was named.
+const prefixTag = tagNamespace + "prefix"
+
+// Naming is what a naming template is executed with.
+type Naming struct {
+	// Name is the name in the YAML or RightScript metadata.
+	Name string
+	// Prefix is the prefix given with --prefix or in the configuration.
+	Prefix string
+	// Branch is the current git branch of the working directory, if any.
+	Branch string
+}
+
+// FormatName executes a naming template to get the name of a dev/test version of something.
+func FormatName(namingTemplate string, naming Naming) (string, error) {
+	tmpl, err := template.New("naming").Option("missingkey=error").Parse(namingTemplate)
+	if err != nil {
+		return "", fmt.Errorf("naming.template in the configuration is invalid: %s", err.Error())
+	}
+	var name bytes.Buffer
+	if err := tmpl.Execute(&name, naming); err != nil {
+		return "", fmt.Errorf("naming.template in the configuration is invalid: %s", err.Error())
+	}
+	if strings.TrimSpace(name.String()) == "" {
+		return "", fmt.Errorf("naming.template in the configuration results in an empty name for '%s'", naming.Name)
+	}
+	return name.String(), nil
+}
+
+// devName returns the name to upload, delete or find something as: the name itself without a prefix or else the name
+// from the naming template.
+func devName(name, prefix string) string {
+	if prefix == "" {
+		return name
+	}
+	namingTemplate := Config.GetString("naming.template")
+	if namingTemplate == "" {
+		namingTemplate = DefaultNamingTemplate
+	}
+	devName, err := FormatName(namingTemplate, Naming{Name: name, Prefix: prefix, Branch: gitBranch()})
+	if err != nil {
+		fatalError("%s", err.Error())
+	}
+	return devName
+}
+
+var (
+	branch     string
+	branchOnce sync.Once
+)
+
+// gitBranch returns the current git branch of the working directory or an empty string if it is not in a git
+// repository or git is not installed.
+func gitBranch() string {
+	branchOnce.Do(func() {
+		output, err := exec.Command("git", "rev-parse", "--abbrev-ref", "HEAD").Output()
+		if err == nil {
+			branch = strings.TrimSpace(string(output))
+		}
+	})
+	return branch
+}
+
+// getTagsByHrefs gets the tags of many resources at once.
+func getTagsByHrefs(hrefs []string) (map[string][]string, error) {
+	client, _ := Config.Account.Client15()
+	tagsLoc := client.TagLocator("/api/tags/by_resource")
+
+	tagsByHref := make(map[string][]string)
+	// keep requests to a reasonable size
+	const batchSize = 100
+	for start := 0; start < len(hrefs); start += batchSize {
+		end := start + batchSize
+		if end > len(hrefs) {
+			end = len(hrefs)
+		}
+		res, err := tagsLoc.ByResource(hrefs[start:end])
+		if err != nil {
+			return nil, err
+		}
+		for _, resource := range res {
+			var href string
+			if links, ok := resource["links"].([]interface{}); ok {
+				for _, l := range links {
+					if link, ok := l.(map[string]interface{}); ok && link["rel"] == "resource" {
+						href, _ = link["href"].(string)
+					}
+				}
+			}
+			tagset, _ := resource["tags"].([]interface{})
+			for _, t := range tagset {
+				if th, ok := t.(map[string]interface{}); ok {
+					if name, ok := th["name"].(string); ok {
+						tagsByHref[href] = append(tagsByHref[href], name)
+					}
+				}
+			}
+		}
+	}
+	return tagsByHref, nil
+}
+
+// tagValue returns the value of a machine tag such as right_st:prefix=dev in a list of tags.
+func tagValue(tags []string, predicate string) (string, bool) {
+	for _, tag := range tags {
+		if strings.HasPrefix(tag, predicate+"=") {
+			return strings.TrimPrefix(tag, predicate+"="), true
+		}
+	}
+	return "", false
+}
+
+// withoutRightStTags removes the tags right_st records on what it creates.
+func withoutRightStTags(tags []string) []string {
+	var filtered []string
+	for _, tag := range tags {
+		if !strings.HasPrefix(tag, tagNamespace) {
+			filtered = append(filtered, tag)
+		}
+	}
+	return filtered
+}
@@ -0,0 +1,39 @@
+package main_test
+
+import (
+	. "github.com/rightscale/right_st"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Naming", func() {
+	naming := Naming{Name: "My App", Prefix: "pr123", Branch: "feature/x"}
+
+	DescribeTable("templates",
+		func(namingTemplate, name string) {
+			result, err := FormatName(namingTemplate, naming)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result).To(Equal(name))
+		},
+		Entry("the default template", DefaultNamingTemplate, "pr123_My App"),
+		Entry("a branch suffix", "{{.Name}} [{{.Branch}}]", "My App [feature/x]"),
+		Entry("a prefix suffix", "{{.Name}}-{{.Prefix}}", "My App-pr123"),
+	)
+
+	It("fails for an invalid template", func() {
+		_, err := FormatName("{{.Name", naming)
+		Expect(err).To(MatchError(ContainSubstring("naming.template in the configuration is invalid")))
+	})
+
+	It("fails for an unknown field", func() {
+		_, err := FormatName("{{.Nmae}}", naming)
+		Expect(err).To(MatchError(ContainSubstring("naming.template in the configuration is invalid")))
+	})
+
+	It("fails for an empty name", func() {
+		_, err := FormatName("{{.Branch}}", Naming{Name: "My App"})
+		Expect(err).To(MatchError("naming.template in the configuration results in an empty name for 'My App'"))
+	})
+})
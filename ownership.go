@@ -0,0 +1,256 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+const (
+	// repoTag records the repository something was uploaded from: its origin remote or else its directory.
+	repoTag = tagNamespace + "repo"
+	// pathTag records the file something was uploaded from relative to the top of the repository.
+	pathTag = tagNamespace + "path"
+	// commitTag records the commit the repository was at when something was uploaded.
+	commitTag = tagNamespace + "commit"
+)
+
+// adoptExisting is set by --adopt to record ownership of existing objects which were not uploaded from the
+// repository.
+var adoptExisting bool
+
+// copiedFrom is set by st copy to the Owner recorded for everything it uploads, since the temporary directory it
+// downloads to is not a repository and is gone once the copy is done.
+var copiedFrom *Owner
+
+// Owner is where something right_st uploaded came from as recorded in its right_st machine tags.
+type Owner struct {
+	Repo   string
+	Path   string
+	Commit string
+	Prefix string
+}
+
+// Tags returns the machine tags recording an Owner. The prefix tag is always recorded, with an empty value for
+// something uploaded without a prefix, so cleanup never mistakes a production object for a prefixed one by its name.
+func (owner Owner) Tags() []string {
+	var tags []string
+	for _, tag := range []struct{ predicate, value string }{
+		{repoTag, owner.Repo},
+		{pathTag, owner.Path},
+		{commitTag, owner.Commit},
+	} {
+		if tag.value != "" {
+			tags = append(tags, fmt.Sprintf("%s=%s", tag.predicate, tag.value))
+		}
+	}
+	return append(tags, fmt.Sprintf("%s=%s", prefixTag, owner.Prefix))
+}
+
+// OwnerFromTags returns the Owner recorded in a list of tags and whether there was one.
+func OwnerFromTags(tags []string) (Owner, bool) {
+	var owner Owner
+	repo, ok := tagValue(tags, repoTag)
+	if !ok {
+		return owner, false
+	}
+	owner.Repo = repo
+	owner.Path, _ = tagValue(tags, pathTag)
+	owner.Commit, _ = tagValue(tags, commitTag)
+	owner.Prefix, _ = tagValue(tags, prefixTag)
+	return owner, true
+}
+
+// OwnedBy returns true if tags record something as uploaded from a repository.
+func OwnedBy(tags []string, repo string) bool {
+	owner, ok := OwnerFromTags(tags)
+	return ok && owner.Repo == repo
+}
+
+// skippedUnowned tells the user about objects which were left alone because they were not uploaded from the current
+// repository.
+func skippedUnowned(skipped int, repo string) {
+	if skipped > 0 {
+		fmt.Printf("WARNING: Skipping %d objects which were not uploaded from %s, use --unowned to delete them as well\n",
+			skipped, repo)
+	}
+}
+
+type repository struct {
+	name   string
+	top    string
+	commit string
+}
+
+var (
+	repositories     = make(map[string]*repository)
+	repositoriesLock sync.Mutex
+)
+
+// repositoryOf returns the git repository a directory is in. Outside of a git repository the working directory is
+// used as the repository instead so there is always something to record.
+func repositoryOf(dir string) *repository {
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		abs = dir
+	}
+
+	repositoriesLock.Lock()
+	defer repositoriesLock.Unlock()
+	if repo, ok := repositories[abs]; ok {
+		return repo
+	}
+
+	git := func(args ...string) string {
+		output, err := exec.Command("git", append([]string{"-C", abs}, args...)...).Output()
+		if err != nil {
+			return ""
+		}
+		return strings.TrimSpace(string(output))
+	}
+	repo := &repository{top: git("rev-parse", "--show-toplevel")}
+	if repo.top != "" {
+		repo.name = RemoteWithoutCredentials(git("config", "--get", "remote.origin.url"))
+		if repo.name == "" {
+			repo.name = repo.top
+		}
+		repo.commit = git("rev-parse", "HEAD")
+	} else {
+		repo.top, _ = os.Getwd()
+		repo.name = repo.top
+	}
+	repositories[abs] = repo
+	return repo
+}
+
+// RemoteWithoutCredentials returns a git remote URL without any user name, password or token in it so they are not
+// recorded in tags anyone with access to the account can read. The user of an scp-style remote such as
+// git@github.com:example/templates.git is kept since it is not a credential, but a password after it is not.
+func RemoteWithoutCredentials(remote string) string {
+	if !strings.Contains(remote, "://") {
+		if at := strings.Index(remote, "@"); at >= 0 {
+			if colon := strings.Index(remote[:at], ":"); colon >= 0 {
+				return remote[:colon] + remote[at:]
+			}
+		}
+		return remote
+	}
+	u, err := url.Parse(remote)
+	if err != nil {
+		// never fall back to the remote as is since it could still contain credentials
+		if i, j := strings.Index(remote, "://"), strings.LastIndex(remote, "@"); j > i {
+			return remote[:i+3] + remote[j+1:]
+		}
+		return remote
+	}
+	u.User = nil
+	return u.String()
+}
+
+// CopyOwner returns the Owner recorded for something st copy uploads: the account it was copied from as the
+// repository and the HREF of the ServerTemplate copied as the path. No repository is ever named like this so account gc
+// and cleanup never consider copies to be owned and uploading over a copy from a repository needs --adopt.
+func CopyOwner(host string, accountId int, href string) Owner {
+	return Owner{Repo: fmt.Sprintf("account %d on %s", accountId, host), Path: href}
+}
+
+// ownerOf returns the Owner to record for something uploaded from a file.
+func ownerOf(file, prefix string) Owner {
+	if copiedFrom != nil {
+		owner := *copiedFrom
+		owner.Prefix = prefix
+		return owner
+	}
+	repo := repositoryOf(filepath.Dir(file))
+	path := file
+	if abs, err := filepath.Abs(file); err == nil {
+		if rel, err := filepath.Rel(repo.top, abs); err == nil {
+			path = rel
+		}
+	}
+	return Owner{Repo: repo.name, Path: filepath.ToSlash(path), Commit: repo.commit, Prefix: prefix}
+}
+
+// currentRepository returns the repository of the working directory, which is the one cleanup and gc consider
+// objects to be owned by.
+func currentRepository() string {
+	return repositoryOf(".").name
+}
+
+// claimOwnership records where something was uploaded from in its right_st machine tags. Existing objects which were
+// not uploaded from the same repository are left alone with a warning unless --adopt was given, replacing any
+// ownership tags they had.
+func claimOwnership(resourceType, name, href string, owner Owner, created bool) error {
+	client, _ := Config.Account.Client15()
+
+	existingTags, err := getTagsByHref(href)
+	if err != nil {
+		return err
+	}
+	if !created && !adoptExisting {
+		current, owned := OwnerFromTags(existingTags)
+		if !owned {
+			fmt.Printf("WARNING: %s '%s' was not uploaded by right_st, use --adopt to record it as uploaded from %s\n",
+				resourceTypeNames[resourceType], name, owner.Path)
+			return nil
+		}
+		if current.Repo != owner.Repo {
+			fmt.Printf("WARNING: %s '%s' was uploaded from %s, use --adopt to record it as uploaded from %s\n",
+				resourceTypeNames[resourceType], name, current.Repo, owner.Repo)
+			return nil
+		}
+	}
+
+	tags := owner.Tags()
+	var toDelete []string
+	for _, tag := range existingTags {
+		if isOwnerTag(tag) && !containsString(tags, tag) {
+			toDelete = append(toDelete, tag)
+		}
+	}
+	if len(toDelete) > 0 {
+		tagsLoc := client.TagLocator("/api/tags/multi_delete")
+		if err := tagsLoc.MultiDelete([]string{href}, toDelete); err != nil {
+			return err
+		}
+	}
+	tagsLoc := client.TagLocator("/api/tags/multi_add")
+	return tagsLoc.MultiAdd([]string{href}, tags)
+}
+
+// isOwnerTag returns true for the tags claimOwnership manages.
+func isOwnerTag(tag string) bool {
+	for _, predicate := range []string{repoTag, pathTag, commitTag, prefixTag} {
+		if strings.HasPrefix(tag, predicate+"=") {
+			return true
+		}
+	}
+	return false
+}
+
+// showOwner prints where something was uploaded from if it was uploaded by right_st.
+func showOwner(href string) {
+	tags, err := getTagsByHref(href)
+	if err != nil {
+		fmt.Printf("WARNING: Could not get tags for %s: %s\n", href, err.Error())
+		return
+	}
+	owner, ok := OwnerFromTags(tags)
+	if !ok {
+		fmt.Printf("Uploaded From: not uploaded by right_st\n")
+		return
+	}
+	fmt.Printf("Uploaded From:\n")
+	fmt.Printf("  Repository: %s\n", owner.Repo)
+	fmt.Printf("  Path: %s\n", owner.Path)
+	if owner.Commit != "" {
+		fmt.Printf("  Commit: %s\n", owner.Commit)
+	}
+	if owner.Prefix != "" {
+		fmt.Printf("  Prefix: %s\n", owner.Prefix)
+	}
+}
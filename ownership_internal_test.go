@@ -0,0 +1,36 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Ownership of a copied ServerTemplate", func() {
+	var dir string
+
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "right_st_copy")
+		Expect(err).NotTo(HaveOccurred())
+		owner := CopyOwner("us-3.rightscale.com", 12345, "/api/server_templates/401234003")
+		copiedFrom = &owner
+	})
+
+	AfterEach(func() {
+		copiedFrom = nil
+		os.RemoveAll(dir)
+	})
+
+	It("is recorded instead of the temporary directory it was downloaded to", func() {
+		owner := ownerOf(filepath.Join(dir, "My_App.yml"), "")
+		Expect(owner).To(Equal(Owner{
+			Repo: "account 12345 on us-3.rightscale.com",
+			Path: "/api/server_templates/401234003",
+		}))
+		Expect(owner.Repo).NotTo(Equal(currentRepository()))
+	})
+})
@@ -0,0 +1,885 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/rightscale/rsc/cm15"
+	"github.com/rightscale/rsc/rsapi"
+	"gopkg.in/yaml.v2"
+)
+
+// pullState keeps track of the files written and the conflicts found while pulling.
+type pullState struct {
+	force     bool
+	updated   int
+	conflicts []string
+}
+
+// stPull brings the changes made to the HEAD revisions of ServerTemplates and their RightScripts since they were
+// uploaded into the local files, keeping the comments and order of what did not change.
+func stPull(files []string, prefix string, force bool) {
+	pull := &pullState{force: force}
+	for _, file := range files {
+		if isDirectory(file) {
+			continue
+		}
+		st, err := loadServerTemplate(file)
+		if err != nil {
+			fatalError("%s: %s", file, err.Error())
+		}
+		if st == nil {
+			continue
+		}
+		if err := pull.serverTemplate(file, prefix); err != nil {
+			fatalError("Failed to pull ServerTemplate '%s': %s", file, err.Error())
+		}
+	}
+
+	if pull.updated == 0 && len(pull.conflicts) == 0 {
+		fmt.Println("Already up to date")
+	}
+	if len(pull.conflicts) > 0 {
+		fmt.Printf("%d files were not pulled since they have local changes which are not committed and were also changed remotely:\n",
+			len(pull.conflicts))
+		for _, conflict := range pull.conflicts {
+			fmt.Printf("  %s\n", conflict)
+		}
+		fmt.Println("Commit or stash the local changes and pull again or use --force to overwrite them")
+		exit(1)
+	}
+}
+
+// serverTemplate pulls a ServerTemplate YAML file along with the RightScripts, attachments and MultiCloudImage files
+// it refers to.
+func (pull *pullState) serverTemplate(file, prefix string) error {
+	client, _ := Config.Account.Client15()
+	dir := filepath.Dir(file)
+
+	stDef, err := loadServerTemplate(file)
+	if err != nil {
+		return err
+	}
+
+	stName := devName(stDef.Name, prefix)
+	st, err := getServerTemplateByName(stName)
+	if err != nil {
+		return err
+	}
+	if st == nil {
+		return fmt.Errorf("Could not find ServerTemplate '%s'", stName)
+	}
+	href := getLink(st.Links, "self")
+	fmt.Printf("Pulling ServerTemplate '%s' HREF %s into '%s'\n", stName, href, file)
+	conflicts := len(pull.conflicts)
+
+	tempDir, err := ioutil.TempDir("", "right_st")
+	if err != nil {
+		return err
+	}
+	defer onExit(func() { os.RemoveAll(tempDir) })()
+
+	// download every RightScript to its own directory so their attachments cannot clash
+	remoteScripts := make(map[string]*cm15.RightScript)
+	remoteFile := downloadServerTemplate(href, tempDir, true, true, true, ClauseAlertFormat, SingleLayout, eachRightScript(func(rsHref, stDir string) string {
+		rs, err := client.RightScriptLocator(rsHref).Show(rsapi.APIParams{})
+		if err != nil {
+			fatalError("Could not get RightScript %s: %s\n", rsHref, err.Error())
+		}
+		scriptDir := filepath.Join(tempDir, "right_scripts", strconv.Itoa(len(remoteScripts)))
+		if err := os.MkdirAll(scriptDir, 0755); err != nil {
+			fatalError("Error creating directory: %s", err.Error())
+		}
+		downloadedTo := rightScriptDownload(rsHref, scriptDir)
+		remoteScripts[downloadedTo] = rs
+		return downloadedTo
+	}))
+	f, err := os.Open(remoteFile)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	remote, err := ParseServerTemplate(f)
+	if err != nil {
+		return err
+	}
+
+	err = pull.updateServerTemplateFile(file, remote, prefix, ClauseAlertFormat,
+		func(stDef *ServerTemplate, raw yaml.MapSlice) (yaml.MapSlice, error) {
+			return pull.rightScripts(dir, prefix, stDef, raw, remote, tempDir, remoteScripts)
+		})
+	if err != nil {
+		return err
+	}
+
+	// What was pulled has been seen so the next upload should not report it as a conflict
+	if len(pull.conflicts) == conflicts {
+		fingerprint, err := serverTemplateFingerprint(st)
+		if err == nil {
+			err = recordFingerprint(href, fingerprint)
+		}
+		if err != nil {
+			return fmt.Errorf("Failed to record fingerprint of ServerTemplate '%s': %s", stName, err.Error())
+		}
+	}
+	return nil
+}
+
+// updateServerTemplateFile updates an existing ServerTemplate YAML file to the values of a downloaded ServerTemplate
+// while keeping its comments, key order and everything which did not change as it was. Inputs, MultiCloudImages and
+// Alerts which are in their own files are updated in those files. rightScripts returns the RightScripts section of the
+// updated YAML given the local ServerTemplate and its YAML.
+func (pull *pullState) updateServerTemplateFile(file string, remote *ServerTemplate, prefix, alertFormat string,
+	rightScripts func(stDef *ServerTemplate, raw yaml.MapSlice) (yaml.MapSlice, error)) error {
+	dir := filepath.Dir(file)
+
+	local, err := ioutil.ReadFile(file)
+	if err != nil {
+		return err
+	}
+	stDef, err := ParseServerTemplate(bytes.NewReader(local))
+	if err != nil {
+		return err
+	}
+	var raw yaml.MapSlice
+	if err := yaml.Unmarshal(local, &raw); err != nil {
+		return err
+	}
+
+	updated := yaml.MapSlice{}
+	for _, item := range raw {
+		updated = append(updated, item)
+	}
+
+	setYAMLKey(&updated, "Description", remote.Description)
+
+	//-------------------------------------
+	// Inputs
+	//-------------------------------------
+	var inputNames []string
+	for name := range remote.Inputs {
+		inputNames = append(inputNames, name)
+	}
+	sort.Strings(inputNames)
+	rawInputs := yamlKey(raw, "Inputs")
+	var (
+		inputsFile  string
+		inputsBytes []byte
+		rawFile     yaml.MapSlice
+	)
+	if stDef.InputsFile != "" {
+		inputsFile = filepath.Join(dir, stDef.InputsFile)
+		if inputsBytes, err = ioutil.ReadFile(inputsFile); err != nil {
+			return err
+		}
+		if err := yaml.Unmarshal(inputsBytes, &rawFile); err != nil {
+			return err
+		}
+		rawInputs = yamlKey(rawFile, "Inputs")
+	}
+	inputs := yaml.MapSlice{}
+	if rawInputs, ok := rawInputs.(yaml.MapSlice); ok {
+		for _, item := range rawInputs {
+			if value, ok := remote.Inputs[fmt.Sprint(item.Key)]; ok {
+				inputs = append(inputs, yaml.MapItem{Key: item.Key, Value: value.String()})
+			}
+		}
+	}
+	for _, name := range inputNames {
+		if yamlKey(inputs, name) == nil {
+			inputs = append(inputs, yaml.MapItem{Key: name, Value: remote.Inputs[name].String()})
+		}
+	}
+	if inputsFile != "" {
+		setYAMLKey(&rawFile, "Inputs", inputs)
+		if err := pull.mergeFile(inputsFile, inputsBytes, rawFile); err != nil {
+			return err
+		}
+	} else if len(inputs) > 0 || rawInputs != nil {
+		setYAMLKey(&updated, "Inputs", inputs)
+	}
+
+	//-------------------------------------
+	// RightScripts
+	//-------------------------------------
+	scripts, err := rightScripts(stDef, raw)
+	if err != nil {
+		return err
+	}
+	if len(scripts) > 0 || yamlKey(raw, "RightScripts") != nil {
+		setYAMLKey(&updated, "RightScripts", scripts)
+	}
+
+	//-------------------------------------
+	// MultiCloudImages
+	//-------------------------------------
+	mcis, err := pull.multiCloudImages(dir, prefix, stDef, raw, remote)
+	if err != nil {
+		return err
+	}
+	if len(mcis) > 0 || yamlKey(raw, "MultiCloudImages") != nil {
+		setYAMLKey(&updated, "MultiCloudImages", mcis)
+	}
+
+	//-------------------------------------
+	// Alerts
+	//-------------------------------------
+	alerts, err := pull.alerts(dir, stDef, raw, remote.Alerts, alertFormat)
+	if err != nil {
+		return err
+	}
+	if len(alerts) > 0 || yamlKey(raw, "Alerts") != nil {
+		setYAMLKey(&updated, "Alerts", alerts)
+	}
+
+	return pull.mergeFile(file, local, updated)
+}
+
+// mergeFile merges the values of a YAML mapping into a YAML file with MergeYAML.
+func (pull *pullState) mergeFile(file string, local []byte, updated yaml.MapSlice) error {
+	updatedBytes, err := yaml.Marshal(updated)
+	if err != nil {
+		return err
+	}
+	merged, err := MergeYAML(local, updatedBytes)
+	if err != nil {
+		return err
+	}
+	pull.file(file, merged, 0644)
+	return nil
+}
+
+// writeYAMLFile writes a value to a YAML file, updating the file in place if it is already there.
+func (pull *pullState) writeYAMLFile(file string, value interface{}) error {
+	updated, err := yamlValue(value)
+	if err != nil {
+		return err
+	}
+	local, err := ioutil.ReadFile(file)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return pull.mergeFile(file, local, yamlMapping(updated))
+}
+
+// downloadedRightScripts returns the RightScripts section of a ServerTemplate's YAML for a downloaded ServerTemplate,
+// keeping the entries for RightScripts which were downloaded to the same place as they were.
+func downloadedRightScripts(dir string, stDef *ServerTemplate, raw yaml.MapSlice, remote *ServerTemplate) (yaml.MapSlice, error) {
+	identity := func(rs *RightScript) string {
+		if rs.Type == LocalRightScript {
+			return "path:" + filepath.Clean(filepath.Join(dir, rs.Path))
+		}
+		return fmt.Sprintf("revision:%s:%d:%s", rs.Name, rs.Revision, rs.Publisher)
+	}
+	localEntries := make(map[string]interface{})
+	rawRightScripts, _ := yamlKey(raw, "RightScripts").(yaml.MapSlice)
+	for _, item := range rawRightScripts {
+		entries, _ := item.Value.([]interface{})
+		for i, rs := range stDef.RightScripts[fmt.Sprint(item.Key)] {
+			if i < len(entries) {
+				localEntries[identity(rs)] = entries[i]
+			}
+		}
+	}
+
+	rightScripts := yaml.MapSlice{}
+	for _, sequence := range rightScriptSequences(rawRightScripts, remote) {
+		entries := []interface{}{}
+		for _, rs := range remote.RightScripts[sequence] {
+			entry, ok := localEntries[identity(rs)]
+			if !ok {
+				var err error
+				if entry, err = yamlValue(rs); err != nil {
+					return nil, err
+				}
+			}
+			entries = append(entries, entry)
+		}
+		rightScripts = append(rightScripts, yaml.MapItem{Key: sequence, Value: entries})
+	}
+	return rightScripts, nil
+}
+
+// rightScriptSequences returns the sequences in the RightScripts section of an updated ServerTemplate YAML: the local
+// ones in their order followed by any others the remote ServerTemplate has.
+func rightScriptSequences(rawRightScripts yaml.MapSlice, remote *ServerTemplate) []string {
+	var sequences []string
+	for _, item := range rawRightScripts {
+		sequences = append(sequences, fmt.Sprint(item.Key))
+	}
+	for _, sequence := range sequenceTypes {
+		if _, ok := remote.RightScripts[sequence]; ok && yamlKey(rawRightScripts, sequence) == nil {
+			sequences = append(sequences, sequence)
+		}
+	}
+	return sequences
+}
+
+// rightScripts pulls the RightScripts attached to a ServerTemplate and returns the RightScripts section of its YAML.
+// Entries for RightScripts which are still attached are kept as they were.
+func (pull *pullState) rightScripts(dir, prefix string, stDef *ServerTemplate, raw yaml.MapSlice, remote *ServerTemplate,
+	tempDir string, remoteScripts map[string]*cm15.RightScript) (yaml.MapSlice, error) {
+	// key RightScripts by name and revision so local and remote ones can be matched up
+	identity := func(name string, revision int) string {
+		if revision == 0 {
+			return "head:" + name
+		}
+		return fmt.Sprintf("revision:%s:%d", name, revision)
+	}
+	type localScript struct {
+		entry interface{}
+		path  string
+	}
+	localScripts := make(map[string]localScript)
+	rawRightScripts, _ := yamlKey(raw, "RightScripts").(yaml.MapSlice)
+	var scriptDir string
+	for _, item := range rawRightScripts {
+		sequence := fmt.Sprint(item.Key)
+		entries, _ := item.Value.([]interface{})
+		for i, rs := range stDef.RightScripts[sequence] {
+			if i >= len(entries) {
+				break
+			}
+			if rs.Type == LocalRightScript {
+				scriptPath := filepath.Join(dir, rs.Path)
+				name, err := rightScriptName(scriptPath)
+				if err != nil {
+					return nil, err
+				}
+				localScripts[identity(devName(name, prefix), 0)] = localScript{entry: entries[i], path: scriptPath}
+				if scriptDir == "" {
+					scriptDir = filepath.Dir(scriptPath)
+				}
+			} else {
+				localScripts[identity(rs.Name, rs.Revision)] = localScript{entry: entries[i]}
+			}
+		}
+	}
+	if scriptDir == "" {
+		scriptDir = dir
+	}
+
+	pulled := make(map[string]interface{})
+	rightScripts := yaml.MapSlice{}
+	for _, sequence := range rightScriptSequences(rawRightScripts, remote) {
+		entries := []interface{}{}
+		for _, rs := range remote.RightScripts[sequence] {
+			var (
+				id       string
+				rsHref   string
+				revision int
+			)
+			remotePath := filepath.Join(tempDir, rs.Path)
+			if rs.Type == LocalRightScript {
+				script := remoteScripts[remotePath]
+				id = identity(script.Name, script.Revision)
+				rsHref = getLink(script.Links, "self")
+				revision = script.Revision
+			} else {
+				id = identity(rs.Name, rs.Revision)
+			}
+			if entry, ok := pulled[id]; ok {
+				entries = append(entries, entry)
+				continue
+			}
+
+			var entry interface{}
+			local, isLocal := localScripts[id]
+			switch {
+			case isLocal && local.path == "":
+				entry = local.entry
+			case isLocal:
+				entry = local.entry
+				pull.rightScript(local.path, remotePath, rsHref)
+			case rs.Type == PublishedRightScript:
+				entry = yaml.MapSlice{{Key: "Name", Value: rs.Name}, {Key: "Revision", Value: rs.Revision}, {Key: "Publisher", Value: rs.Publisher}}
+			case revision != 0:
+				entry = yaml.MapSlice{{Key: "Name", Value: remoteScripts[remotePath].Name}, {Key: "Revision", Value: revision}}
+			default:
+				// a RightScript which was added remotely
+				scriptPath := filepath.Join(scriptDir, filepath.Base(remotePath))
+				pull.rightScript(scriptPath, remotePath, rsHref)
+				relative, err := filepath.Rel(dir, scriptPath)
+				if err != nil {
+					return nil, err
+				}
+				entry = filepath.ToSlash(relative)
+			}
+			pulled[id] = entry
+			entries = append(entries, entry)
+		}
+		rightScripts = append(rightScripts, yaml.MapItem{Key: sequence, Value: entries})
+	}
+	return rightScripts, nil
+}
+
+// rightScript pulls a RightScript and its attachments, keeping the name and comment style of the local metadata and
+// the order of its inputs and attachments.
+func (pull *pullState) rightScript(localPath, remotePath, rsHref string) {
+	conflicts := len(pull.conflicts)
+
+	remote, err := ioutil.ReadFile(remotePath)
+	if err != nil {
+		fatalError("Could not read downloaded RightScript: %s", err.Error())
+	}
+	remoteMetadata, err := ParseRightScriptMetadata(bytes.NewReader(remote))
+	if err != nil || remoteMetadata == nil {
+		fmt.Printf("WARNING: Not pulling %s, the metadata of the RightScript is malformed\n", localPath)
+		return
+	}
+	var localMetadata *RightScriptMetadata
+	local, err := ioutil.ReadFile(localPath)
+	if err == nil {
+		if localMetadata, err = ParseRightScriptMetadata(bytes.NewReader(local)); err != nil {
+			fmt.Printf("WARNING: Metadata in %s is malformed: %s\n", localPath, err.Error())
+			localMetadata = nil
+		}
+	}
+	metadata := MergeRightScriptMetadata(localMetadata, remoteMetadata)
+
+	var block bytes.Buffer
+	if localMetadata != nil && sameRightScriptMetadata(localMetadata, metadata) {
+		_, localBlock, _ := splitRightScriptMetadata(local)
+		block.Write(localBlock)
+	} else {
+		metadata.WriteTo(&block)
+	}
+	before, _, after := splitRightScriptMetadata(remote)
+	content := append(append(append([]byte{}, before...), block.Bytes()...), after...)
+	pull.file(localPath, content, 0755)
+
+	for i, name := range metadata.Attachments {
+		attachment, err := ioutil.ReadFile(filepath.Join(filepath.Dir(remotePath), "attachments", remoteMetadata.Attachments[attachmentIndex(remoteMetadata.Attachments, name)]))
+		if err != nil {
+			fatalError("Could not read downloaded attachment: %s", err.Error())
+		}
+		pull.file(filepath.Join(filepath.Dir(localPath), "attachments", metadata.Attachments[i]), attachment, 0644)
+	}
+	if localMetadata != nil {
+		for _, name := range localMetadata.Attachments {
+			if attachmentIndex(metadata.Attachments, name) < 0 {
+				fmt.Printf("Attachment '%s' is no longer attached to RightScript '%s', not deleting it\n", name, metadata.Name)
+			}
+		}
+	}
+
+	if rsHref != "" && len(pull.conflicts) == conflicts {
+		fingerprint, err := rightScriptFingerprint(rsHref)
+		if err == nil {
+			err = recordFingerprint(rsHref, fingerprint)
+		}
+		if err != nil {
+			fatalError("Failed to record fingerprint of RightScript '%s': %s", metadata.Name, err.Error())
+		}
+	}
+}
+
+// MergeRightScriptMetadata returns the remote metadata of a RightScript with the name, comment style and order of
+// inputs and attachments of the local metadata, if there is any.
+func MergeRightScriptMetadata(local, remote *RightScriptMetadata) *RightScriptMetadata {
+	merged := *remote
+	if local == nil {
+		return &merged
+	}
+	merged.Name = local.Name
+	merged.Comment = local.Comment
+
+	merged.Inputs = InputMap{}
+	for _, localInput := range local.Inputs {
+		for _, input := range remote.Inputs {
+			if input.Name == localInput.Name {
+				merged.Inputs = append(merged.Inputs, input)
+			}
+		}
+	}
+	for _, input := range remote.Inputs {
+		found := false
+		for _, localInput := range local.Inputs {
+			found = found || input.Name == localInput.Name
+		}
+		if !found {
+			merged.Inputs = append(merged.Inputs, input)
+		}
+	}
+
+	// attachments are matched by base name since the local names may have directories in them
+	merged.Attachments = nil
+	for _, name := range local.Attachments {
+		if attachmentIndex(remote.Attachments, name) >= 0 {
+			merged.Attachments = append(merged.Attachments, name)
+		}
+	}
+	for _, name := range remote.Attachments {
+		if attachmentIndex(local.Attachments, name) < 0 {
+			merged.Attachments = append(merged.Attachments, name)
+		}
+	}
+	return &merged
+}
+
+// attachmentIndex returns the index of an attachment with the same base name in a list of attachments or -1.
+func attachmentIndex(attachments []string, name string) int {
+	for i, attachment := range attachments {
+		if path.Base(attachment) == path.Base(name) {
+			return i
+		}
+	}
+	return -1
+}
+
+// sameRightScriptMetadata returns whether two RightScript metadata have the same values.
+func sameRightScriptMetadata(a, b *RightScriptMetadata) bool {
+	aBytes, aErr := yaml.Marshal(a)
+	bBytes, bErr := yaml.Marshal(b)
+	return aErr == nil && bErr == nil && bytes.Equal(aBytes, bBytes)
+}
+
+// splitRightScriptMetadata splits a RightScript into what comes before its metadata comment, the metadata comment
+// itself and what comes after it. Without a metadata comment everything comes after it. The lines are split as they
+// are so nothing is lost from long lines or CRLF line endings.
+func splitRightScriptMetadata(source []byte) (before, metadata, after []byte) {
+	var buffers [3]bytes.Buffer
+	state := PreMetadata
+	for _, line := range bytes.SplitAfter(source, []byte("\n")) {
+		text := strings.TrimRight(string(line), "\r\n")
+		switch {
+		case state == PreMetadata && metadataStart.MatchString(text):
+			state = InMetadata
+		case state == InMetadata && metadataEnd.MatchString(text):
+			buffers[InMetadata].Write(line)
+			state = PostMetadata
+			continue
+		}
+		buffers[state].Write(line)
+	}
+	if state != PostMetadata {
+		return nil, nil, source
+	}
+	return buffers[PreMetadata].Bytes(), buffers[InMetadata].Bytes(), buffers[PostMetadata].Bytes()
+}
+
+// multiCloudImages pulls the MultiCloudImages of a ServerTemplate and returns the MultiCloudImages section of its
+// YAML. MultiCloudImages in their own files are updated there.
+func (pull *pullState) multiCloudImages(dir, prefix string, stDef *ServerTemplate, raw yaml.MapSlice,
+	remote *ServerTemplate) ([]interface{}, error) {
+	identity := func(mci *MultiCloudImage) string {
+		if mci.Revision == 0 && mci.Publisher == "" {
+			return "head:" + mci.Name
+		}
+		return fmt.Sprintf("revision:%s:%d:%s", mci.Name, mci.Revision, mci.Publisher)
+	}
+	rawMcis, _ := yamlKey(raw, "MultiCloudImages").([]interface{})
+	files := make([]string, len(stDef.MultiCloudImages))
+	for i, mci := range stDef.MultiCloudImages {
+		files[i] = mci.File
+	}
+	localMcis, err := ExpandMultiCloudImages(dir, stDef.MultiCloudImages)
+	if err != nil {
+		return nil, err
+	}
+	type localMci struct {
+		entry interface{}
+		mci   *MultiCloudImage
+		file  string
+	}
+	byIdentity := make(map[string]localMci)
+	for i, mci := range localMcis {
+		if i >= len(rawMcis) {
+			break
+		}
+		id := identity(mci)
+		if len(mci.Settings) > 0 {
+			id = "head:" + devName(mci.Name, prefix)
+		}
+		byIdentity[id] = localMci{entry: rawMcis[i], mci: mci, file: files[i]}
+	}
+
+	mcis := []interface{}{}
+	for _, mci := range remote.MultiCloudImages {
+		local, ok := byIdentity[identity(mci)]
+		// without settings on both sides there is nothing to compare
+		if ok && (len(local.mci.Settings) == 0 || len(mci.Settings) == 0 || sameMultiCloudImage(local.mci, mci)) {
+			mcis = append(mcis, local.entry)
+			continue
+		}
+		mci.Href = ""
+		if ok {
+			mci.Name = local.mci.Name
+		}
+		if ok && local.file != "" {
+			file := filepath.Join(dir, local.file)
+			localBytes, err := ioutil.ReadFile(file)
+			if err != nil {
+				return nil, err
+			}
+			updated, err := yamlValue(mci)
+			if err != nil {
+				return nil, err
+			}
+			if err := pull.mergeFile(file, localBytes, updated.(yaml.MapSlice)); err != nil {
+				return nil, err
+			}
+			mcis = append(mcis, local.entry)
+			continue
+		}
+		entry, err := yamlValue(mci)
+		if err != nil {
+			return nil, err
+		}
+		mcis = append(mcis, entry)
+	}
+	return mcis, nil
+}
+
+// sameMultiCloudImage returns whether two MultiCloudImages have the same description, tags and settings.
+func sameMultiCloudImage(a, b *MultiCloudImage) bool {
+	settings := func(mci *MultiCloudImage) []string {
+		var settings []string
+		for _, s := range mci.Settings {
+			settings = append(settings, strings.Join([]string{s.Cloud, s.InstanceType, s.Image, s.UserData}, "\x00"))
+		}
+		sort.Strings(settings)
+		return settings
+	}
+	tags := func(mci *MultiCloudImage) []string {
+		tags := withoutRightStTags(mci.Tags)
+		sort.Strings(tags)
+		return tags
+	}
+	return a.Description == b.Description && strings.Join(tags(a), "\x00") == strings.Join(tags(b), "\x00") &&
+		strings.Join(settings(a), "\x01") == strings.Join(settings(b), "\x01")
+}
+
+// alerts updates the Alerts of a ServerTemplate to the downloaded ones and returns the Alerts section of its YAML.
+// Alerts which did not change are kept as they were and ones which changed keep their format, with Alerts from Alert
+// files updated in those files. New Alerts are added to the ServerTemplate in alertFormat. Alerts are not removed from
+// Alert files since other ServerTemplates may use them.
+func (pull *pullState) alerts(dir string, stDef *ServerTemplate, raw yaml.MapSlice, remoteAlerts []*Alert,
+	alertFormat string) ([]interface{}, error) {
+	localAlerts, err := ExpandAlerts(dir, stDef.Alerts)
+	if err != nil {
+		return nil, err
+	}
+	resolved, _, errors := ResolveAlerts(localAlerts)
+	if len(errors) > 0 {
+		return nil, errors[0]
+	}
+	effective := make(map[*Alert]bool)
+	for _, alert := range resolved {
+		effective[alert] = true
+	}
+	remoteByName := make(map[string]*Alert)
+	for _, alert := range remoteAlerts {
+		remoteByName[normalizeAlertName(alert.Name)] = alert
+	}
+	seen := make(map[string]bool)
+
+	// update returns the entry for a local Alert or nil if it was removed
+	update := func(entry interface{}, local *Alert) (interface{}, error) {
+		seen[normalizeAlertName(local.Name)] = true
+		if !effective[local] {
+			// overridden by another Alert
+			return entry, nil
+		}
+		remote, ok := remoteByName[normalizeAlertName(local.Name)]
+		if !ok {
+			if local.source != "" {
+				fmt.Printf("WARNING: Alert '%s' was removed but is in Alert file %s which other ServerTemplates may use, remove it by hand\n",
+					local.Name, local.source)
+				return entry, nil
+			}
+			return nil, nil
+		}
+		if sameAlert(local, remote) {
+			return entry, nil
+		}
+		alert := remote
+		format := ClauseAlertFormat
+		if local.isStructured() {
+			format = StructuredAlertFormat
+		}
+		if spec, err := remote.Spec(); err == nil {
+			spec.Name, spec.Description = local.Name, remote.Description
+			alert = AlertFromSpec(spec, format)
+		}
+		alert.Override = local.Override
+		return yamlValue(alert)
+	}
+
+	// Alert files
+	var files []string
+	for _, alert := range localAlerts {
+		if alert.source != "" && !containsString(files, alert.source) {
+			files = append(files, alert.source)
+		}
+	}
+	for _, file := range files {
+		path := filepath.Join(dir, file)
+		local, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		var rawFile yaml.MapSlice
+		if err := yaml.Unmarshal(local, &rawFile); err != nil {
+			return nil, err
+		}
+		rawAlerts, _ := yamlKey(rawFile, "Alerts").([]interface{})
+		alerts := []interface{}{}
+		for _, entry := range rawAlerts {
+			if _, ok := entry.(string); !ok {
+				for _, alert := range localAlerts {
+					if alert.source == file && alert.Name == fmt.Sprint(yamlKey(yamlMapping(entry), "Name")) {
+						if entry, err = update(entry, alert); err != nil {
+							return nil, err
+						}
+						break
+					}
+				}
+			}
+			if entry != nil {
+				alerts = append(alerts, entry)
+			}
+		}
+		setYAMLKey(&rawFile, "Alerts", alerts)
+		if err := pull.mergeFile(path, local, rawFile); err != nil {
+			return nil, err
+		}
+	}
+
+	// Alerts in the ServerTemplate itself
+	rawAlerts, _ := yamlKey(raw, "Alerts").([]interface{})
+	alerts := []interface{}{}
+	for i, entry := range rawAlerts {
+		if i < len(stDef.Alerts) && stDef.Alerts[i].File == "" {
+			var err error
+			if entry, err = update(entry, stDef.Alerts[i]); err != nil {
+				return nil, err
+			}
+		}
+		if entry != nil {
+			alerts = append(alerts, entry)
+		}
+	}
+	for _, remote := range remoteAlerts {
+		if seen[normalizeAlertName(remote.Name)] {
+			continue
+		}
+		alert := remote
+		if spec, err := remote.Spec(); err == nil {
+			spec.Name, spec.Description = remote.Name, remote.Description
+			alert = AlertFromSpec(spec, alertFormat)
+		}
+		entry, err := yamlValue(alert)
+		if err != nil {
+			return nil, err
+		}
+		alerts = append(alerts, entry)
+	}
+	return alerts, nil
+}
+
+// sameAlert returns whether two Alerts have the same description and spec.
+func sameAlert(a, b *Alert) bool {
+	aSpec, aErr := a.Spec()
+	bSpec, bErr := b.Spec()
+	return aErr == nil && bErr == nil && a.Description == b.Description && alertSpecsEqual(aSpec, bSpec)
+}
+
+// file writes the pulled content of a file unless the file has local changes which are not committed to git. A file
+// which is unchanged from git but for the pulled changes is updated and a file which was only changed locally is left
+// alone. Anything else is a conflict.
+func (pull *pullState) file(file string, content []byte, mode os.FileMode) {
+	local, err := ioutil.ReadFile(file)
+	exists := err == nil
+	if exists && bytes.Equal(local, content) {
+		return
+	}
+	if exists && !pull.force {
+		committed, ok := gitCommitted(file)
+		if ok && bytes.Equal(committed, content) {
+			return
+		}
+		if !ok || !bytes.Equal(committed, local) {
+			fmt.Printf("CONFLICT: '%s' has local changes which are not committed and was also changed remotely\n", file)
+			pull.conflicts = append(pull.conflicts, file)
+			return
+		}
+	}
+
+	if exists {
+		if info, err := os.Stat(file); err == nil {
+			mode = info.Mode()
+		}
+		fmt.Printf("Updating '%s'\n", file)
+	} else {
+		fmt.Printf("Adding '%s'\n", file)
+	}
+	if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+		fatalError("Error creating directory: %s", err.Error())
+	}
+	if err := ioutil.WriteFile(file, content, mode); err != nil {
+		fatalError("Could not write file: %s", err.Error())
+	}
+	pull.updated++
+}
+
+// gitCommitted returns the content of a file as of the HEAD commit of its git repository or false if it is not in
+// one.
+func gitCommitted(file string) ([]byte, bool) {
+	output, err := exec.Command("git", "-C", filepath.Dir(file), "show", "HEAD:./"+filepath.Base(file)).Output()
+	if err != nil {
+		return nil, false
+	}
+	return output, true
+}
+
+// yamlValue converts a value to what it looks like when it is written to YAML and read back, keeping the order of
+// keys in mappings.
+func yamlValue(value interface{}) (interface{}, error) {
+	bytes, err := yaml.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+	var mapping yaml.MapSlice
+	if err := yaml.Unmarshal(bytes, &mapping); err == nil {
+		return mapping, nil
+	}
+	var other interface{}
+	err = yaml.Unmarshal(bytes, &other)
+	return other, err
+}
+
+// yamlMapping returns a YAML value as a mapping, which is empty if the value is not a mapping.
+func yamlMapping(value interface{}) yaml.MapSlice {
+	mapping, _ := value.(yaml.MapSlice)
+	return mapping
+}
+
+// yamlKey returns the value of a key in a YAML mapping or nil.
+func yamlKey(mapping yaml.MapSlice, key string) interface{} {
+	for _, item := range mapping {
+		if fmt.Sprint(item.Key) == key {
+			return item.Value
+		}
+	}
+	return nil
+}
+
+// setYAMLKey sets the value of a key in a YAML mapping, adding the key at the end if it is not there yet.
+func setYAMLKey(mapping *yaml.MapSlice, key string, value interface{}) {
+	for i, item := range *mapping {
+		if fmt.Sprint(item.Key) == key {
+			(*mapping)[i].Value = value
+			return
+		}
+	}
+	*mapping = append(*mapping, yaml.MapItem{Key: key, Value: value})
+}
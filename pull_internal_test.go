@@ -0,0 +1,285 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"gopkg.in/yaml.v2"
+)
+
+var _ = Describe("Updating a ServerTemplate file", func() {
+	var dir string
+
+	write := func(name, content string) {
+		Expect(os.MkdirAll(filepath.Dir(filepath.Join(dir, name)), 0755)).To(Succeed())
+		Expect(ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644)).To(Succeed())
+	}
+	read := func(name string) string {
+		content, err := ioutil.ReadFile(filepath.Join(dir, name))
+		Expect(err).NotTo(HaveOccurred())
+		return string(content)
+	}
+
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "right_st_update")
+		Expect(err).NotTo(HaveOccurred())
+
+		write("my_app.yml", `# My App
+Name: My App
+Description: The app
+Inputs:
+  PORT: text:80 # the default
+  USER: text:app
+RightScripts:
+  Boot:
+  - install.sh
+MultiCloudImages:
+- mcis/ubuntu.yml
+Alerts:
+# paged on
+- Name: cpu busy
+  Clause: If cpu-0/cpu-idle.value < 15 for 3 minutes Then escalate critical
+- alerts/common.yml
+`)
+		write("mcis/ubuntu.yml", `# kept up to date by hand
+Name: Ubuntu
+Settings:
+- Cloud: EC2 us-east-1
+  Instance Type: m3.medium
+  Image: ami-1
+`)
+		write("alerts/common.yml", `Alerts:
+# structured on purpose
+- Name: low memory
+  Metric: memory/memory-free
+  Value Type: value
+  Condition: <
+  Threshold: "1000000"
+  Duration: 5
+  Escalation: warning
+`)
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(dir)
+	})
+
+	update := func(remote *ServerTemplate) {
+		file := filepath.Join(dir, "my_app.yml")
+		pull := &pullState{force: true}
+		Expect(pull.updateServerTemplateFile(file, remote, "", ClauseAlertFormat,
+			func(local *ServerTemplate, raw yaml.MapSlice) (yaml.MapSlice, error) {
+				return downloadedRightScripts(dir, local, raw, remote)
+			})).To(Succeed())
+	}
+
+	remote := func() *ServerTemplate {
+		return &ServerTemplate{
+			Name:        "My App",
+			Description: "The app",
+			Inputs: map[string]*InputValue{
+				"PORT": {Type: "text", Value: "80"},
+				"USER": {Type: "text", Value: "app"},
+			},
+			RightScripts: map[string][]*RightScript{"Boot": {{Type: LocalRightScript, Path: "install.sh"}}},
+			MultiCloudImages: []*MultiCloudImage{{
+				Name:     "Ubuntu",
+				Settings: []*Setting{{Cloud: "EC2 us-east-1", InstanceType: "m3.medium", Image: "ami-1"}},
+			}},
+			Alerts: []*Alert{
+				{Name: "cpu busy", Clause: "If cpu-0/cpu-idle.value < 15 for 3 minutes Then escalate critical"},
+				{Name: "low memory", Clause: "If memory/memory-free.value < 1000000 for 5 minutes Then escalate warning"},
+			},
+		}
+	}
+
+	It("leaves files alone when nothing changed", func() {
+		before := read("my_app.yml")
+		update(remote())
+		Expect(read("my_app.yml")).To(Equal(before))
+	})
+
+	It("only changes the values which changed", func() {
+		st := remote()
+		st.Inputs["USER"].Value = "www"
+		st.Alerts[0].Clause = "If cpu-0/cpu-idle.value < 10 for 3 minutes Then escalate critical"
+		update(st)
+		Expect(read("my_app.yml")).To(Equal(`# My App
+Name: My App
+Description: The app
+Inputs:
+  PORT: text:80 # the default
+  USER: text:www
+RightScripts:
+  Boot:
+  - install.sh
+MultiCloudImages:
+- mcis/ubuntu.yml
+Alerts:
+# paged on
+- Name: cpu busy
+  Clause: If cpu-0/cpu-idle.value < 10 for 3 minutes Then escalate critical
+- alerts/common.yml
+`))
+	})
+
+	It("updates MultiCloudImages and Alerts in their own files", func() {
+		st := remote()
+		st.MultiCloudImages[0].Settings[0].Image = "ami-2"
+		st.Alerts[1].Clause = "If memory/memory-free.value < 2000000 for 5 minutes Then escalate warning"
+		st.Alerts = append(st.Alerts, &Alert{Name: "disk full", Clause: "If df-root/df_complex-free.value < 1000000 for 5 minutes Then escalate warning"})
+		update(st)
+		Expect(read("mcis/ubuntu.yml")).To(Equal(`# kept up to date by hand
+Name: Ubuntu
+Settings:
+- Cloud: EC2 us-east-1
+  Instance Type: m3.medium
+  Image: ami-2
+`))
+		Expect(read("alerts/common.yml")).To(Equal(`Alerts:
+# structured on purpose
+- Name: low memory
+  Metric: memory/memory-free
+  Value Type: value
+  Condition: <
+  Threshold: "2000000"
+  Duration: 5
+  Escalation: warning
+`))
+		Expect(read("my_app.yml")).To(HaveSuffix(`- alerts/common.yml
+- Name: disk full
+  Clause: If df-root/df_complex-free.value < 1000000 for 5 minutes Then escalate warning
+`))
+	})
+})
+
+var _ = Describe("Splitting a downloaded ServerTemplate", func() {
+	var dir string
+
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "right_st_split")
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(dir)
+	})
+
+	serverTemplate := func(name string) *ServerTemplate {
+		return &ServerTemplate{
+			Name:         name,
+			Description:  "The app",
+			Inputs:       map[string]*InputValue{"PORT": {Type: "text", Value: "80"}},
+			RightScripts: map[string][]*RightScript{"Boot": {{Type: LocalRightScript, Path: "install.sh"}}},
+			MultiCloudImages: []*MultiCloudImage{
+				{Name: "Ubuntu", Settings: []*Setting{{Cloud: "EC2 us-east-1", InstanceType: "m3.medium", Image: "ami-1"}}},
+				{Name: "CentOS", Revision: 4},
+			},
+			Alerts: []*Alert{{Name: "cpu busy", Clause: "If cpu-0/cpu-idle.value < 15 for 3 minutes Then escalate critical"}},
+		}
+	}
+
+	It("writes Inputs, MultiCloudImages and Alerts to their own files the ServerTemplate refers to", func() {
+		st, err := splitServerTemplate(dir, serverTemplate("My App"))
+		Expect(err).NotTo(HaveOccurred())
+		content, err := yaml.Marshal(st)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(string(content)).To(Equal(`Name: My App
+Description: The app
+Inputs: inputs/My_App.yml
+RightScripts:
+  Boot:
+  - install.sh
+MultiCloudImages:
+- multi_cloud_images/Ubuntu.yml
+- Name: CentOS
+  Revision: 4
+Alerts:
+- alerts/My_App.yml
+`))
+
+		parsed, err := ParseServerTemplate(bytes.NewReader(content))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(ExpandInputs(dir, parsed)).To(Succeed())
+		Expect(parsed.Inputs).To(Equal(map[string]*InputValue{"PORT": {Type: "text", Value: "80"}}))
+		mcis, err := ExpandMultiCloudImages(dir, parsed.MultiCloudImages)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(mcis[0].Settings).To(HaveLen(1))
+		alerts, err := ExpandAlerts(dir, parsed.Alerts)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(alerts).To(HaveLen(1))
+	})
+
+	It("writes a MultiCloudImage shared by ServerTemplates once", func() {
+		_, err := splitServerTemplate(dir, serverTemplate("My App"))
+		Expect(err).NotTo(HaveOccurred())
+		st, err := splitServerTemplate(dir, serverTemplate("My Other App"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(yamlKey(st, "MultiCloudImages")).To(ContainElement("multi_cloud_images/Ubuntu.yml"))
+		files, err := filepath.Glob(filepath.Join(dir, "multi_cloud_images", "*"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(files).To(HaveLen(1))
+	})
+
+	It("writes a different MultiCloudImage with the same file name to another file", func() {
+		other := serverTemplate("My Other App")
+		other.MultiCloudImages[0].Name = "Ubuntu?"
+		_, err := splitServerTemplate(dir, serverTemplate("My App"))
+		Expect(err).NotTo(HaveOccurred())
+		st, err := splitServerTemplate(dir, other)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(yamlKey(st, "MultiCloudImages")).To(ContainElement("multi_cloud_images/Ubuntu_2.yml"))
+	})
+
+	It("writes the same MultiCloudImage with different settings to another file", func() {
+		other := serverTemplate("My Other App")
+		other.MultiCloudImages[0].Settings[0].Image = "ami-2"
+		_, err := splitServerTemplate(dir, serverTemplate("My App"))
+		Expect(err).NotTo(HaveOccurred())
+		st, err := splitServerTemplate(dir, other)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(yamlKey(st, "MultiCloudImages")).To(ContainElement("multi_cloud_images/Ubuntu_2.yml"))
+		mci := loadMultiCloudImage(filepath.Join(dir, "multi_cloud_images", "Ubuntu.yml"))
+		Expect(mci).NotTo(BeNil())
+		Expect(mci.Settings[0].Image).To(Equal("ami-1"))
+	})
+})
+
+var _ = Describe("Splitting RightScript metadata", func() {
+	It("splits around the metadata comment", func() {
+		before, metadata, after := splitRightScriptMetadata([]byte("#!/bin/bash\n# ---\n# RightScript Name: Install\n# ...\necho hi\n"))
+		Expect(string(before)).To(Equal("#!/bin/bash\n"))
+		Expect(string(metadata)).To(Equal("# ---\n# RightScript Name: Install\n# ...\n"))
+		Expect(string(after)).To(Equal("echo hi\n"))
+	})
+
+	It("keeps lines longer than a scanner buffer", func() {
+		long := "echo " + string(bytes.Repeat([]byte("x"), 100*1024)) + "\n"
+		before, metadata, after := splitRightScriptMetadata([]byte("#!/bin/bash\n# ---\n# RightScript Name: Install\n# ...\n" + long))
+		Expect(string(before)).To(Equal("#!/bin/bash\n"))
+		Expect(string(metadata)).To(Equal("# ---\n# RightScript Name: Install\n# ...\n"))
+		Expect(string(after)).To(Equal(long))
+	})
+
+	It("keeps CRLF line endings", func() {
+		source := "#!/bin/bash\r\n# ---\r\n# RightScript Name: Install\r\n# ...\r\necho hi\r\n"
+		before, metadata, after := splitRightScriptMetadata([]byte(source))
+		Expect(string(before)).To(Equal("#!/bin/bash\r\n"))
+		Expect(string(metadata)).To(Equal("# ---\r\n# RightScript Name: Install\r\n# ...\r\n"))
+		Expect(string(after)).To(Equal("echo hi\r\n"))
+	})
+
+	It("puts everything after a missing metadata comment", func() {
+		source := []byte("#!/bin/bash\necho hi")
+		before, metadata, after := splitRightScriptMetadata(source)
+		Expect(before).To(BeNil())
+		Expect(metadata).To(BeNil())
+		Expect(after).To(Equal(source))
+	})
+})
@@ -0,0 +1,71 @@
+package main_test
+
+import (
+	. "github.com/rightscale/right_st"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("MergeRightScriptMetadata", func() {
+	local := &RightScriptMetadata{
+		Name:        "Install App",
+		Description: "Installs the app",
+		Inputs: InputMap{
+			{Name: "USER", Category: "App"},
+			{Name: "PORT", Category: "App"},
+			{Name: "HOST", Category: "App"},
+		},
+		Attachments: []string{"app/config.yml", "app.tar.gz"},
+		Comment:     "#",
+	}
+
+	It("uses the remote metadata when there is no local metadata", func() {
+		remote := &RightScriptMetadata{Name: "dev_Install App", Comment: "#"}
+		Expect(MergeRightScriptMetadata(nil, remote)).To(Equal(remote))
+	})
+
+	It("keeps the local name, comment style and order of inputs and attachments", func() {
+		remote := &RightScriptMetadata{
+			Name:        "dev_Install App",
+			Description: "Installs the app and its service",
+			Packages:    "curl",
+			Inputs: InputMap{
+				{Name: "HOST", Category: "App"},
+				{Name: "PORT", Category: "Network"},
+				{Name: "USER", Category: "App"},
+			},
+			Attachments: []string{"app.tar.gz", "config.yml"},
+			Comment:     "//",
+		}
+		Expect(MergeRightScriptMetadata(local, remote)).To(Equal(&RightScriptMetadata{
+			Name:        "Install App",
+			Description: "Installs the app and its service",
+			Packages:    "curl",
+			Inputs: InputMap{
+				{Name: "USER", Category: "App"},
+				{Name: "PORT", Category: "Network"},
+				{Name: "HOST", Category: "App"},
+			},
+			Attachments: []string{"app/config.yml", "app.tar.gz"},
+			Comment:     "#",
+		}))
+	})
+
+	It("drops removed inputs and attachments and appends new ones", func() {
+		remote := &RightScriptMetadata{
+			Name: "Install App",
+			Inputs: InputMap{
+				{Name: "VERSION", Category: "App"},
+				{Name: "PORT", Category: "App"},
+			},
+			Attachments: []string{"service.conf", "app.tar.gz"},
+		}
+		merged := MergeRightScriptMetadata(local, remote)
+		Expect(merged.Inputs).To(Equal(InputMap{
+			{Name: "PORT", Category: "App"},
+			{Name: "VERSION", Category: "App"},
+		}))
+		Expect(merged.Attachments).To(Equal([]string{"app.tar.gz", "service.conf"}))
+	})
+})
@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"fmt"
 	"io/ioutil"
+	"net/http"
 	"net/url"
 	"os"
 	"path"
@@ -12,6 +13,8 @@ import (
 	"regexp"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/kr/pretty"
 	"github.com/rightscale/rsc/cm15"
@@ -43,6 +46,10 @@ type RightScript struct {
 	Revision  int    // Needed for remote case
 	Publisher string // Needed for remote case
 	Metadata  RightScriptMetadata
+	checked   bool // Whether it was checked for changes since the last upload
+
+	fingerprint       Fingerprint // of the RightScript on disk, once computed
+	remoteFingerprint Fingerprint // of the HEAD revision, if checking for changes fetched it
 }
 
 var (
@@ -77,6 +84,7 @@ func rightScriptShow(href string) {
 	fmt.Printf("Name: %s\n", rightscript.Name)
 	fmt.Printf("HREF: /api/right_scripts/%s\n", rightscript.Id)
 	fmt.Printf("Revision: %5s\n", rev)
+	showOwner(href)
 	fmt.Printf("Inputs:\n")
 	for _, input := range rightscript.Inputs {
 		i := jsonMapToInput(input)
@@ -126,7 +134,14 @@ func rightScriptUpload(files []string, force bool, prefix string) {
 		scripts = append(scripts, script)
 	}
 
-	// Pass 2, upload
+	// Pass 2, check for changes since the last upload before changing anything
+	for _, script := range scripts {
+		if err := script.checkConflict(prefix); err != nil {
+			fatalError("%s", err.Error())
+		}
+	}
+
+	// Pass 3, upload
 	for _, script := range scripts {
 		err = script.Push(prefix)
 		if err != nil {
@@ -158,6 +173,127 @@ func GuessExtension(source string) string {
 }
 
 func rightScriptDownload(href, downloadTo string) string {
+	script, err := fetchRightScript(href, downloadTo)
+	if err != nil {
+		fatalError("%s", err.Error())
+	}
+	fmt.Printf("Downloading '%s' to '%s'\n", script.rightscript.Name, script.downloadTo)
+	if len(script.items) == 0 {
+		fmt.Println("No attachments to download")
+	} else {
+		fmt.Printf("Download %d attachments:\n", len(script.items))
+		err = downloadManager(script.items)
+		if err != nil {
+			fatalError("Failed to download all attachments: %s", err.Error())
+		}
+	}
+	return script.write()
+}
+
+// defaultConcurrency is how many RightScripts are fetched from the API, or upload steps run, at once unless
+// --concurrency says otherwise.
+const defaultConcurrency = 4
+
+// rightScriptDownloads downloads RightScripts like rightScriptDownload, each to the corresponding directory, fetching
+// up to concurrency of them from the API at once. Attachment locations are decided in the order the RightScripts are
+// given, the same as downloading them one at a time would, so where attachments with the same name end up does not
+// depend on which download finishes first.
+func rightScriptDownloads(hrefs, dirs []string, concurrency int) []string {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	scripts := make([]*fetchedRightScript, len(hrefs))
+	errs := make([]error, len(hrefs))
+	tokens := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+	for i := range hrefs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			tokens <- struct{}{}
+			defer func() { <-tokens }()
+			scripts[i], errs[i] = fetchRightScript(hrefs[i], dirs[i])
+		}(i)
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			fatalError("%s", err.Error())
+		}
+	}
+
+	// decide where each attachment goes, sharing a download between RightScripts with the same attachment
+	planned := make(map[string]*downloadItem)
+	var items []*downloadItem
+	shared := make(map[*downloadItem]*downloadItem)
+	for _, script := range scripts {
+		fmt.Printf("Downloading '%s' to '%s'\n", script.rightscript.Name, script.downloadTo)
+		for _, item := range script.items {
+			location, err := planAttachment(item, planned)
+			if err != nil {
+				fatalError("Failed to download all attachments: %s", err.Error())
+			}
+			if other, ok := planned[location]; ok {
+				shared[item] = other
+				continue
+			}
+			item.locations = []string{location}
+			planned[location] = item
+			items = append(items, item)
+		}
+	}
+	if len(items) == 0 {
+		fmt.Println("No attachments to download")
+	} else {
+		fmt.Printf("Download %d attachments:\n", len(items))
+		if err := downloadManager(items); err != nil {
+			fatalError("Failed to download all attachments: %s", err.Error())
+		}
+	}
+	for item, other := range shared {
+		item.downloadedTo = other.downloadedTo
+	}
+
+	downloadedTo := make([]string, len(scripts))
+	for i, script := range scripts {
+		downloadedTo[i] = script.write()
+	}
+	return downloadedTo
+}
+
+// planAttachment returns the first location of an attachment which is free or already has the same attachment, either
+// on disk or planned for an earlier RightScript.
+func planAttachment(item *downloadItem, planned map[string]*downloadItem) (string, error) {
+	for _, location := range item.locations {
+		if other, ok := planned[location]; ok {
+			if other.md5 == item.md5 {
+				return location, nil
+			}
+			continue
+		}
+		md5sum, err := fmd5sum(location)
+		if err != nil || md5sum == item.md5 {
+			return location, nil
+		}
+	}
+	return "", fmt.Errorf("File '%s' already exists with a different md5sum at locations [%s]",
+		filepath.Base(item.locations[0]), strings.Join(item.locations, ", "))
+}
+
+// fetchedRightScript is a RightScript fetched from the API whose attachments still need to be downloaded before it
+// is written to disk.
+type fetchedRightScript struct {
+	rightscript    *cm15.RightScript
+	source         []byte
+	attachments    []*cm15.RightScriptAttachment
+	items          []*downloadItem
+	downloadTo     string
+	attachmentsDir string
+}
+
+// fetchRightScript gets a RightScript, its source and its attachments from the API and works out where to download
+// them to.
+func fetchRightScript(href, downloadTo string) (*fetchedRightScript, error) {
 	client, _ := Config.Account.Client15()
 
 	attachmentsHref := fmt.Sprintf("%s/attachments", href)
@@ -166,11 +302,11 @@ func rightScriptDownload(href, downloadTo string) string {
 
 	rightscript, err := rightscriptLocator.Show(rsapi.APIParams{"view": "inputs_2_0"})
 	if err != nil {
-		fatalError("Could not find RightScript with href %s: %s", href, err.Error())
+		return nil, fmt.Errorf("Could not find RightScript with href %s: %s", href, err.Error())
 	}
 	source, err := getSource(rightscriptLocator)
 	if err != nil {
-		fatalError("Could get source for RightScript with href %s: %s", href, err.Error())
+		return nil, fmt.Errorf("Could get source for RightScript with href %s: %s", href, err.Error())
 	}
 	sourceMetadata, err := ParseRightScriptMetadata(bytes.NewReader(source))
 	if err != nil {
@@ -179,7 +315,7 @@ func rightScriptDownload(href, downloadTo string) string {
 
 	attachments, err := attachmentsLocator.Index(rsapi.APIParams{})
 	if err != nil {
-		fatalError("Could get attachments for RightScript from href %s: %s", attachmentsHref, err.Error())
+		return nil, fmt.Errorf("Could get attachments for RightScript from href %s: %s", attachmentsHref, err.Error())
 	}
 
 	guessedExtension := GuessExtension(string(source))
@@ -189,7 +325,6 @@ func rightScriptDownload(href, downloadTo string) string {
 	} else if isDirectory(downloadTo) {
 		downloadTo = filepath.Join(downloadTo, cleanFileName(rightscript.Name)+guessedExtension)
 	}
-	fmt.Printf("Downloading '%s' to '%s'\n", rightscript.Name, downloadTo)
 
 	for i, attachment := range attachments {
 		// API attachments are always just plain names without path information.
@@ -223,7 +358,7 @@ func rightScriptDownload(href, downloadTo string) string {
 
 		downloadUrl, err := url.Parse(attachment.DownloadUrl)
 		if err != nil {
-			fatalError("Could not parse URL of attachment: %s", err.Error())
+			return nil, fmt.Errorf("Could not parse URL of attachment: %s", err.Error())
 		}
 		downloadItem := downloadItem{
 			url:       *downloadUrl,
@@ -232,19 +367,24 @@ func rightScriptDownload(href, downloadTo string) string {
 		}
 		downloadItems = append(downloadItems, &downloadItem)
 	}
-	if len(downloadItems) == 0 {
-		fmt.Println("No attachments to download")
-	} else {
-		fmt.Printf("Download %d attachments:\n", len(downloadItems))
-		err = downloadManager(downloadItems)
-		if err != nil {
-			fatalError("Failed to download all attachments: %s", err.Error())
-		}
-		for _, d := range downloadItems {
-			for i, attachment := range attachments {
-				if filepath.Base(attachment.Filename) == filepath.Base(d.downloadedTo) {
-					attachments[i].Filename = strings.Replace(d.downloadedTo, pathPrepend, ``, 1)
-				}
+
+	return &fetchedRightScript{
+		rightscript:    rightscript,
+		source:         source,
+		attachments:    attachments,
+		items:          downloadItems,
+		downloadTo:     downloadTo,
+		attachmentsDir: pathPrepend,
+	}, nil
+}
+
+// write writes a fetched RightScript to disk with its metadata once its attachments have been downloaded.
+func (script *fetchedRightScript) write() string {
+	rightscript, source, attachments, pathPrepend := script.rightscript, script.source, script.attachments, script.attachmentsDir
+	for _, d := range script.items {
+		for i, attachment := range attachments {
+			if filepath.Base(attachment.Filename) == filepath.Base(d.downloadedTo) {
+				attachments[i].Filename = strings.Replace(d.downloadedTo, pathPrepend, ``, 1)
 			}
 		}
 	}
@@ -273,16 +413,16 @@ func rightScriptDownload(href, downloadTo string) string {
 		if bytes.Compare(scaffoldedSourceBytes, source) != 0 {
 			fmt.Println("Automatically inserted RightScript metadata.")
 		}
-		err = ioutil.WriteFile(downloadTo, scaffoldedSourceBytes, 0755)
+		err = ioutil.WriteFile(script.downloadTo, scaffoldedSourceBytes, 0755)
 	} else {
 		fmt.Printf("Downloaded script as is. An error occurred generating metadata to insert into the RightScript: %s", err.Error())
-		err = ioutil.WriteFile(downloadTo, source, 0755)
+		err = ioutil.WriteFile(script.downloadTo, source, 0755)
 	}
 	if err != nil {
 		fatalError("Could not create file: %s", err.Error())
 	}
 
-	return downloadTo
+	return script.downloadTo
 }
 
 // Convert a JSON response to InputMetadata struct
@@ -379,9 +519,7 @@ func deleteRightScript(file string, prefix string) error {
 	} else {
 		scriptName = metadata.Name
 	}
-	if prefix != "" {
-		scriptName = fmt.Sprintf("%s_%s", prefix, scriptName)
-	}
+	scriptName = devName(scriptName, prefix)
 	hrefs, err := paramToHrefs("right_scripts", scriptName, 0)
 	if err != nil {
 		return err
@@ -434,9 +572,10 @@ func getSource(loc *cm15.RightScriptLocator) (respBody []byte, err error) {
 // (such as here or the command line) it passes in rsapi.APIParams instead of a fixed type of
 // cm15.RightScriptAttachmentParams. BuildHTTPRequest has code to iterate over APIParams and
 // turn it into a a multipart mime doc if it sees a FileUpload type. But it doesn't have
-// code knowing about every concrete type to handle that.
-func uploadAttachment(loc *cm15.RightScriptAttachmentLocator,
-	file *rsapi.FileUpload, name string) error {
+// code knowing about every concrete type to handle that. The same goes for Update.
+// It returns whether a failed upload may succeed if it is tried again.
+func uploadAttachment(loc *cm15.RightScriptAttachmentLocator, action string,
+	file *rsapi.FileUpload, name string) (bool, error) {
 	var params rsapi.APIParams
 	var p rsapi.APIParams
 	APIVersion := "1.5"
@@ -449,24 +588,84 @@ func uploadAttachment(loc *cm15.RightScriptAttachmentLocator,
 	p = rsapi.APIParams{
 		"right_script_attachment": p_inner,
 	}
-	uri, err := loc.ActionPath("RightScriptAttachment", "create")
+	uri, err := loc.ActionPath("RightScriptAttachment", action)
 	if err != nil {
-		return err
+		return false, err
 	}
 	req, err := client.BuildHTTPRequest(uri.HTTPMethod, uri.Path, APIVersion, params, p)
 	if err != nil {
-		return err
+		return false, err
 	}
 	resp, err := client.PerformRequest(req)
 	if err != nil {
-		return err
+		return true, err
 	}
 	defer resp.Body.Close()
 	respBody, _ := ioutil.ReadAll(resp.Body)
 	if resp.StatusCode < 200 || resp.StatusCode > 299 {
-		return fmt.Errorf("invalid response %s: %s", resp.Status, string(respBody))
+		return resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests,
+			fmt.Errorf("invalid response %s: %s", resp.Status, string(respBody))
 	}
-	return nil
+	return false, nil
+}
+
+// maxAttachmentUploadTries is how many times an attachment upload is tried before giving up.
+const maxAttachmentUploadTries = 5
+
+// uploadAttachmentFile uploads a file as an attachment with the create or update action of an attachments locator,
+// trying again with increasing delays after errors which may go away. Since a create which failed may still have
+// created the attachment, it is only tried again once the attachments show it was not.
+func uploadAttachmentFile(loc *cm15.RightScriptAttachmentLocator, action, fullPath, name string) error {
+	var err error
+	for try := uint32(0); try < maxAttachmentUploadTries; try++ {
+		if try > 0 {
+			fmt.Printf("    Retrying upload of attachment '%s': %s\n", name, err.Error())
+			time.Sleep((1 << try) * time.Second / 2)
+			if action == "create" {
+				created, indexErr := attachmentCreated(loc, fullPath, name)
+				if indexErr != nil {
+					err = fmt.Errorf("could not check whether the attachment was created: %s", indexErr.Error())
+					continue
+				}
+				if created {
+					return nil
+				}
+			}
+		}
+		var f *os.File
+		f, err = os.Open(fullPath)
+		if err != nil {
+			return err
+		}
+		// FileUpload represents payload fields that correspond to multipart file uploads.
+		file := rsapi.FileUpload{Name: "right_script_attachment[content]", Reader: f, Filename: name}
+		var retry bool
+		retry, err = uploadAttachment(loc, action, &file, path.Base(name))
+		f.Close()
+		if err == nil || !retry {
+			return err
+		}
+	}
+	return err
+}
+
+// attachmentCreated returns true if an attachments locator already has an attachment with the name and contents of a
+// file.
+func attachmentCreated(loc *cm15.RightScriptAttachmentLocator, fullPath, name string) (bool, error) {
+	md5, err := fmd5sum(fullPath)
+	if err != nil {
+		return false, err
+	}
+	attachments, err := loc.Index(rsapi.APIParams{})
+	if err != nil {
+		return false, err
+	}
+	for _, a := range attachments {
+		if a.Filename == path.Base(name) && a.Digest == md5 {
+			return true, nil
+		}
+	}
+	return false, nil
 }
 
 func rightScriptIdByName(name string) (string, error) {
@@ -483,13 +682,9 @@ func rightScriptIdByName(name string) (string, error) {
 		// Recheck the name here, filter does a partial match and we need an exact one
 		if rs.Name == name && rs.Revision == 0 {
 			// Only consider our own RightScripts when uploading
-			submatches := lineage.FindStringSubmatch(rs.Lineage)
-			if submatches == nil {
-				panic(fmt.Errorf("Unexpected RightScript lineage format: %s", rs.Lineage))
-			}
-			accountId, err := strconv.Atoi(submatches[1])
+			accountId, err := rightScriptAccountId(rs)
 			if err != nil {
-				panic(err)
+				return "", err
 			}
 			if accountId != Config.Account.Id {
 				continue
@@ -504,6 +699,15 @@ func rightScriptIdByName(name string) (string, error) {
 	return foundId, nil
 }
 
+// rightScriptAccountId returns the ID of the account a RightScript was created in from its lineage.
+func rightScriptAccountId(rs *cm15.RightScript) (int, error) {
+	submatches := lineage.FindStringSubmatch(rs.Lineage)
+	if submatches == nil {
+		return 0, fmt.Errorf("Unexpected RightScript lineage format: %s", rs.Lineage)
+	}
+	return strconv.Atoi(submatches[1])
+}
+
 // Finds a RightScript in the local account
 // Params:
 //   name: name of RightScript to search for
@@ -640,19 +844,131 @@ func (r *RightScript) PushRemote() error {
 	return nil
 }
 
+// checkConflict returns an error if a local RightScript was changed since it was last uploaded.
+func (r *RightScript) checkConflict(prefix string) error {
+	if r.Type != LocalRightScript || r.checked {
+		return nil
+	}
+	scriptName := devName(r.Metadata.Name, prefix)
+	if _, ok := r.pushedUnchanged(scriptName); ok {
+		r.checked = true
+		return nil
+	}
+	foundId, err := rightScriptIdByName(scriptName)
+	if err != nil {
+		return err
+	}
+	if foundId != "" {
+		href := fmt.Sprintf("/api/right_scripts/%s", foundId)
+		err := checkFingerprint("right_scripts", scriptName, href, func() (Fingerprint, error) {
+			fingerprint, err := rightScriptFingerprint(href)
+			r.remoteFingerprint = fingerprint
+			return fingerprint, err
+		})
+		if err != nil {
+			return err
+		}
+	}
+	r.checked = true
+	return nil
+}
+
+// localFingerprint returns the fingerprint of the RightScript on disk.
+func (r *RightScript) localFingerprint() (Fingerprint, error) {
+	if r.fingerprint == nil {
+		fingerprint, err := localRightScriptFingerprint(r)
+		if err != nil {
+			return nil, err
+		}
+		r.fingerprint = fingerprint
+	}
+	return r.fingerprint, nil
+}
+
+// pushedUnchanged returns the HREF of the RightScript if the same contents were pushed recently and the fingerprint
+// recorded in RightScale still matches them, in which case the rest of the RightScript is not looked at again. The
+// catalog only saves comparing with the local files, the fingerprint tag is always checked so a push of different
+// contents from elsewhere is not missed. --force, --overwrite and --refresh-cache always look.
+func (r *RightScript) pushedUnchanged(scriptName string) (string, bool) {
+	if forceOverwrite {
+		return "", false
+	}
+	fingerprint, err := r.localFingerprint()
+	if err != nil {
+		// reading the files fails again when pushing
+		return "", false
+	}
+	href, ok := getCatalog().PushedRightScript(scriptName, fingerprint)
+	if !ok {
+		return "", false
+	}
+	tags, err := getTagsByHref(href)
+	if err != nil {
+		// the RightScript may be gone, looking it up again finds out
+		return "", false
+	}
+	value, ok := tagValue(tags, fingerprintTag)
+	if !ok {
+		return "", false
+	}
+	recorded, err := ParseFingerprint(value)
+	if err != nil || len(recorded.Changed(fingerprint)) != 0 {
+		return "", false
+	}
+	return href, true
+}
+
 func (r *RightScript) PushLocal(prefix string) error {
 	client, _ := Config.Account.Client15()
 
 	createLocator := client.RightScriptLocator("/api/right_scripts")
-	scriptName := r.Metadata.Name
-	if prefix != "" {
-		scriptName = fmt.Sprintf("%s_%s", prefix, r.Metadata.Name)
+	scriptName := devName(r.Metadata.Name, prefix)
+	if href, ok := r.pushedUnchanged(scriptName); ok {
+		fmt.Printf("  RightScript '%s' unchanged since it was last pushed with HREF %s\n", scriptName, href)
+		r.Href = href
+		return nil
+	}
+
+	if err := r.checkConflict(prefix); err != nil {
+		return err
+	}
+
+	local, err := r.localFingerprint()
+	if err != nil {
+		return err
 	}
 	foundId, err := rightScriptIdByName(scriptName)
 	if err != nil {
 		return err
 	}
 
+	// Compare with the HEAD revision so only what changed is updated
+	updateScript := true
+	if foundId != "" {
+		href := fmt.Sprintf("/api/right_scripts/%s", foundId)
+		remote := r.remoteFingerprint
+		if remote == nil {
+			remote, err = rightScriptFingerprint(href)
+			if err != nil {
+				return err
+			}
+		}
+		changed := remote.Changed(local)
+		if len(changed) == 0 {
+			fmt.Printf("  RightScript '%s' with HREF %s unchanged\n", scriptName, href)
+			r.Href = href
+			if err := claimOwnership("right_scripts", scriptName, r.Href, ownerOf(r.Path, prefix), false); err != nil {
+				return err
+			}
+			if err := recordFingerprint(r.Href, remote); err != nil {
+				return err
+			}
+			getCatalog().RecordPushedRightScript(scriptName, r.Href, remote)
+			return nil
+		}
+		updateScript = containsString(changed, "metadata") || containsString(changed, "source")
+	}
+
 	fileSrc, err := ioutil.ReadFile(r.Path)
 	if err != nil {
 		return err
@@ -678,21 +994,28 @@ func (r *RightScript) PushLocal(prefix string) error {
 	} else {
 		// Found existing, do an update
 		href := fmt.Sprintf("/api/right_scripts/%s", foundId)
-		fmt.Printf("  Updating existing RightScript named '%s' with HREF %s from %s\n", scriptName, href, r.Path)
-
-		params := cm15.RightScriptParam3{
-			Name:        scriptName,
-			Description: r.Metadata.Description,
-			Packages:    r.Metadata.Packages,
-			Source:      string(fileSrc),
-		}
 		rightscriptLocator = client.RightScriptLocator(href)
-		err = rightscriptLocator.Update(&params)
-		if err != nil {
-			return err
+		if updateScript {
+			fmt.Printf("  Updating existing RightScript named '%s' with HREF %s from %s\n", scriptName, href, r.Path)
+
+			params := cm15.RightScriptParam3{
+				Name:        scriptName,
+				Description: r.Metadata.Description,
+				Packages:    r.Metadata.Packages,
+				Source:      string(fileSrc),
+			}
+			err = rightscriptLocator.Update(&params)
+			if err != nil {
+				return err
+			}
+		} else {
+			fmt.Printf("  Updating attachments of existing RightScript named '%s' with HREF %s from %s\n", scriptName, href, r.Path)
 		}
 		r.Href = href
 	}
+	if err := claimOwnership("right_scripts", scriptName, r.Href, ownerOf(r.Path, prefix), foundId == ""); err != nil {
+		return err
+	}
 
 	attachmentsHref := fmt.Sprintf("%s/attachments", rightscriptLocator.Href)
 	attachmentsLocator := client.RightScriptAttachmentLocator(attachmentsHref)
@@ -701,64 +1024,171 @@ func (r *RightScript) PushLocal(prefix string) error {
 		return err
 	}
 
-	toUpload := make(map[string]string)                           // scripts we want to upload
-	onRightscript := make(map[string]*cm15.RightScriptAttachment) // scripts attached to the rightsript
+	var files []attachmentFile
 	for _, a := range r.Metadata.Attachments {
 		fullPath := filepath.Join(filepath.Dir(r.Path), "attachments", a)
 		md5, err := fmd5sum(fullPath)
 		if err != nil {
 			return err
 		}
-		// We use a compound key with the name+md5 here to work around a couple corner cases
-		//   - if the file is renamed, it'll be deleted and reuploaded
-		//   - if two files have the same md5 for whatever reason they won't clash
-		toUpload[path.Base(a)+"_"+md5] = a
+		files = append(files, attachmentFile{name: a, md5: md5})
 	}
-	for _, a := range attachments {
-		onRightscript[path.Base(a.Filename)+"_"+a.Digest] = a
+	changes := planAttachmentChanges(files, attachments)
+
+	for _, file := range changes.unchanged {
+		fmt.Printf("  Attachment '%s' of '%s' already uploaded with md5 %s\n", file.name, scriptName, file.md5)
 	}
 
-	// Two passes. First pass we delete RightScripts. This comes up when a file was
-	// removed from the RightScript, or when the contents of a file on disk changed.
-	// In the second case, the second pass will reupload the correct attachment.
-	for digestKey, a := range onRightscript {
-		if _, ok := toUpload[digestKey]; !ok {
-			loc := a.Locator(client)
+	// Renamed files with the same contents are renamed in place instead of being uploaded again
+	for _, rename := range changes.renames {
+		fmt.Printf("  Renaming attachment '%s' of '%s' to '%s'\n", rename.attachment.Filename, scriptName, rename.name)
+		err := rename.attachment.Locator(client).Update(&cm15.RightScriptAttachmentParam{Filename: rename.name})
+		if err != nil {
+			return fmt.Errorf("Could not rename attachment '%s' of '%s': %s", rename.attachment.Filename, scriptName, err.Error())
+		}
+	}
 
-			fmt.Printf("  Deleting attachment '%s' with HREF '%s'\n", a.Filename, loc.Href)
-			err := loc.Destroy()
+	// Upload new attachments and replace the contents of changed ones before deleting anything so a failed upload
+	// never leaves the RightScript without an attachment it needs. The uploads run concurrently.
+	var (
+		wg        sync.WaitGroup
+		lock      sync.Mutex
+		uploadErr error
+	)
+	for _, upload := range changes.uploads {
+		wg.Add(1)
+		go func(upload attachmentUpload) {
+			defer wg.Done()
+			// wait for upload slot
+			attachmentUploadTokens <- struct{}{}
+			defer func() { <-attachmentUploadTokens }()
+
+			fullPath := filepath.Join(filepath.Dir(r.Path), "attachments", upload.name)
+			var err error
+			if upload.replaces == nil {
+				fmt.Printf("  Uploading attachment '%s' of '%s' with md5 %s\n", upload.name, scriptName, upload.md5)
+				err = uploadAttachmentFile(attachmentsLocator, "create", fullPath, upload.name)
+			} else {
+				fmt.Printf("  Replacing attachment '%s' of '%s' with md5 %s\n", upload.name, scriptName, upload.md5)
+				err = uploadAttachmentFile(upload.replaces.Locator(client), "update", fullPath, upload.name)
+			}
 			if err != nil {
-				return err
+				lock.Lock()
+				defer lock.Unlock()
+				if uploadErr == nil {
+					uploadErr = fmt.Errorf("Could not upload attachment '%s' of '%s', no attachments were deleted: %s",
+						upload.name, scriptName, err.Error())
+				}
 			}
+		}(upload)
+	}
+	wg.Wait()
+	if uploadErr != nil {
+		return uploadErr
+	}
+
+	// Only now delete attachments which were removed from the RightScript
+	for _, a := range changes.obsolete {
+		loc := a.Locator(client)
+
+		fmt.Printf("  Deleting attachment '%s' of '%s' with HREF '%s'\n", a.Filename, scriptName, loc.Href)
+		err := loc.Destroy()
+		if err != nil {
+			return err
 		}
 	}
 
-	// Second pass, now upload any missing attachment and any attachments that were
-	// deleted because we changed file contents.
-	for digestKey, name := range toUpload {
-		digestKeyParts := strings.Split(digestKey, "_")
-		md5 := digestKeyParts[len(digestKeyParts)-1]
-		if _, ok := onRightscript[digestKey]; ok {
-			fmt.Printf("  Attachment '%s' already uploaded with md5 %s\n", name, md5)
-			// TBD -- update if a.Name != name?
-		} else {
-			fullPath := filepath.Join(filepath.Dir(r.Path), "attachments", name)
-			fmt.Printf("  Uploading attachment '%s' with md5 %s\n", name, md5)
-			f, err := os.Open(fullPath)
-			if err != nil {
-				return err
-			}
-			// FileUpload represents payload fields that correspond to multipart file uploads.
-			file := rsapi.FileUpload{Name: "right_script_attachment[content]", Reader: f, Filename: name}
-			//params := cm15.RightScriptAttachmentParam{Content: &file, Name: a}
-			err = uploadAttachment(attachmentsLocator, &file, path.Base(name))
-			if err != nil {
-				return err
+	fingerprint, err := rightScriptFingerprint(r.Href)
+	if err != nil {
+		return err
+	}
+	if err := recordFingerprint(r.Href, fingerprint); err != nil {
+		return err
+	}
+	getCatalog().RecordPushedRightScript(scriptName, r.Href, fingerprint)
+	return nil
+}
+
+// attachmentFile is an attachment of a RightScript on disk.
+type attachmentFile struct {
+	name string // path relative to the attachments directory
+	md5  string
+}
+
+// attachmentUpload is an attachment to upload, replacing the contents of an existing attachment with the same name if
+// there is one.
+type attachmentUpload struct {
+	attachmentFile
+	replaces *cm15.RightScriptAttachment
+}
+
+// attachmentRename is an existing attachment with the contents of a file on disk with a different name.
+type attachmentRename struct {
+	attachment *cm15.RightScriptAttachment
+	name       string
+}
+
+// attachmentChanges are the changes which make the attachments of a RightScript in RightScale the ones on disk.
+type attachmentChanges struct {
+	unchanged []attachmentFile
+	renames   []attachmentRename
+	uploads   []attachmentUpload
+	obsolete  []*cm15.RightScriptAttachment
+}
+
+// planAttachmentChanges decides how to change the attachments of a RightScript in RightScale to the ones on disk.
+// Attachments with the same name and contents are left alone. A file with the contents of an attachment which is no
+// longer wanted under its name is renamed, as long as no other attachment has the new name. Otherwise a file replaces
+// the contents of the attachment with the same name or is uploaded as a new one. The attachments left over are
+// obsolete.
+func planAttachmentChanges(files []attachmentFile, attachments []*cm15.RightScriptAttachment) attachmentChanges {
+	var changes attachmentChanges
+	used := make(map[*cm15.RightScriptAttachment]bool)
+	find := func(match func(a *cm15.RightScriptAttachment) bool) *cm15.RightScriptAttachment {
+		for _, a := range attachments {
+			if !used[a] && match(a) {
+				return a
 			}
 		}
+		return nil
+	}
+	wanted := make(map[string]bool)
+	for _, file := range files {
+		wanted[path.Base(file.name)] = true
 	}
 
-	return err
+	var changed []attachmentFile
+	for _, file := range files {
+		name := path.Base(file.name)
+		a := find(func(a *cm15.RightScriptAttachment) bool { return a.Filename == name && a.Digest == file.md5 })
+		if a == nil {
+			changed = append(changed, file)
+			continue
+		}
+		used[a] = true
+		changes.unchanged = append(changes.unchanged, file)
+	}
+	for _, file := range changed {
+		name := path.Base(file.name)
+		sameName := find(func(a *cm15.RightScriptAttachment) bool { return a.Filename == name })
+		if sameName == nil {
+			renamed := find(func(a *cm15.RightScriptAttachment) bool { return a.Digest == file.md5 && !wanted[a.Filename] })
+			if renamed != nil {
+				used[renamed] = true
+				changes.renames = append(changes.renames, attachmentRename{attachment: renamed, name: name})
+				continue
+			}
+		} else {
+			used[sameName] = true
+		}
+		changes.uploads = append(changes.uploads, attachmentUpload{attachmentFile: file, replaces: sameName})
+	}
+	for _, a := range attachments {
+		if !used[a] {
+			changes.obsolete = append(changes.obsolete, a)
+		}
+	}
+	return changes
 }
 
 // Validates that a file has valid metadata, including attachments.
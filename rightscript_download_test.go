@@ -0,0 +1,79 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Planning attachment downloads", func() {
+	var (
+		dir     string
+		planned map[string]*downloadItem
+	)
+
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "right_st_attachments")
+		Expect(err).NotTo(HaveOccurred())
+		planned = make(map[string]*downloadItem)
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(dir)
+	})
+
+	item := func(script, md5 string) *downloadItem {
+		return &downloadItem{
+			locations: []string{
+				filepath.Join(dir, "attachments", "config.xml"),
+				filepath.Join(dir, "attachments", script, "config.xml"),
+			},
+			md5: md5,
+		}
+	}
+	plan := func(item *downloadItem) string {
+		location, err := planAttachment(item, planned)
+		Expect(err).NotTo(HaveOccurred())
+		if _, ok := planned[location]; !ok {
+			planned[location] = item
+		}
+		return location
+	}
+
+	It("uses the first free location", func() {
+		Expect(plan(item("Install_App", "aaa"))).To(Equal(filepath.Join(dir, "attachments", "config.xml")))
+	})
+
+	It("shares a location planned for the same attachment", func() {
+		plan(item("Install_App", "aaa"))
+		Expect(plan(item("Configure_App", "aaa"))).To(Equal(filepath.Join(dir, "attachments", "config.xml")))
+	})
+
+	It("moves a different attachment with the same name to the next location", func() {
+		plan(item("Install_App", "aaa"))
+		Expect(plan(item("Configure_App", "bbb"))).To(Equal(filepath.Join(dir, "attachments", "Configure_App", "config.xml")))
+	})
+
+	It("uses a location on disk which already has the attachment", func() {
+		file := filepath.Join(dir, "attachments", "config.xml")
+		Expect(os.MkdirAll(filepath.Dir(file), 0755)).To(Succeed())
+		Expect(ioutil.WriteFile(file, []byte("<config/>"), 0644)).To(Succeed())
+		md5, err := md5sum(strings.NewReader("<config/>"))
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(plan(item("Install_App", md5))).To(Equal(file))
+		Expect(plan(item("Configure_App", "bbb"))).To(Equal(filepath.Join(dir, "attachments", "Configure_App", "config.xml")))
+	})
+
+	It("fails when every location is taken by a different attachment", func() {
+		plan(item("Install_App", "aaa"))
+		plan(item("Install_App", "bbb"))
+		_, err := planAttachment(item("Install_App", "ccc"), planned)
+		Expect(err).To(MatchError(ContainSubstring("already exists with a different md5sum")))
+	})
+})
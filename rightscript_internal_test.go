@@ -0,0 +1,210 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/rightscale/rsc/cm15"
+	"github.com/rightscale/rsc/httpclient"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("A RightScript pushed unchanged", func() {
+	var (
+		server      *httptest.Server
+		dir         string
+		account     *Account
+		insecure    bool
+		script      *RightScript
+		fingerprint Fingerprint
+		tags        []string
+		tagRequests int
+	)
+
+	BeforeEach(func() {
+		tags = nil
+		tagRequests = 0
+		server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path != "/api/tags/by_resource" {
+				http.NotFound(w, r)
+				return
+			}
+			tagRequests++
+			var names []string
+			for _, tag := range tags {
+				names = append(names, fmt.Sprintf(`{"name": %q}`, tag))
+			}
+			w.Header().Set("Content-Type", "application/json")
+			fmt.Fprintf(w, `[{"tags": [%s], "links": [{"rel": "resource", "href": "/api/right_scripts/1"}]}]`,
+				strings.Join(names, ", "))
+		}))
+		insecure = httpclient.Insecure
+		httpclient.Insecure = true
+		account = Config.Account
+		Config.Account = &Account{client15: cm15.New(strings.TrimPrefix(server.URL, "http://"), nil)}
+
+		var err error
+		dir, err = ioutil.TempDir("", "right_st_pushed")
+		Expect(err).NotTo(HaveOccurred())
+		file := filepath.Join(dir, "install.sh")
+		Expect(ioutil.WriteFile(file, []byte("#!/bin/bash\necho installing\n"), 0644)).To(Succeed())
+		script = &RightScript{Type: LocalRightScript, Path: file, Metadata: RightScriptMetadata{Name: "Install App"}}
+		fingerprint, err = script.localFingerprint()
+		Expect(err).NotTo(HaveOccurred())
+
+		resetCatalog()
+		catalogOnce.Do(func() {
+			catalog = NewCatalog(filepath.Join(dir, "catalog.json"), time.Hour)
+		})
+		catalog.RecordPushedRightScript("Install App", "/api/right_scripts/1", fingerprint)
+	})
+
+	AfterEach(func() {
+		resetCatalog()
+		Config.Account = account
+		httpclient.Insecure = insecure
+		server.Close()
+		os.RemoveAll(dir)
+	})
+
+	It("is skipped when the recorded fingerprint still matches", func() {
+		tags = []string{fmt.Sprintf("%s=%s", fingerprintTag, fingerprint)}
+		href, ok := script.pushedUnchanged("Install App")
+		Expect(ok).To(BeTrue())
+		Expect(href).To(Equal("/api/right_scripts/1"))
+		Expect(tagRequests).To(Equal(1))
+	})
+
+	It("is looked at again when different contents were pushed since", func() {
+		tags = []string{fmt.Sprintf("%s=%s", fingerprintTag, Fingerprint{"metadata": "aaa", "source": "bbb", "attachments": "ccc"})}
+		_, ok := script.pushedUnchanged("Install App")
+		Expect(ok).To(BeFalse())
+	})
+
+	It("is looked at again when no fingerprint is recorded", func() {
+		_, ok := script.pushedUnchanged("Install App")
+		Expect(ok).To(BeFalse())
+	})
+})
+
+var _ = Describe("Uploading an attachment", func() {
+	var (
+		server    *httptest.Server
+		dir       string
+		file      string
+		account   *Account
+		insecure  bool
+		creates   int
+		attached  bool
+		keepFirst bool
+	)
+
+	BeforeEach(func() {
+		creates = 0
+		attached = false
+		server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path != "/api/right_scripts/1/attachments" {
+				http.NotFound(w, r)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			switch r.Method {
+			case "GET":
+				if attached {
+					fmt.Fprint(w, `[{"filename": "app.tgz", "digest": "02d9c81326b39258a437b3732a5dbdfc"}]`)
+				} else {
+					fmt.Fprint(w, `[]`)
+				}
+			case "POST":
+				creates++
+				ioutil.ReadAll(r.Body)
+				if creates == 1 {
+					// the connection drops before the response makes it back
+					attached = keepFirst
+					conn, _, err := w.(http.Hijacker).Hijack()
+					Expect(err).NotTo(HaveOccurred())
+					conn.Close()
+					return
+				}
+				attached = true
+				w.WriteHeader(http.StatusCreated)
+			}
+		}))
+		insecure = httpclient.Insecure
+		httpclient.Insecure = true
+		account = Config.Account
+		Config.Account = &Account{client15: cm15.New(strings.TrimPrefix(server.URL, "http://"), nil)}
+
+		var err error
+		dir, err = ioutil.TempDir("", "right_st_attachment")
+		Expect(err).NotTo(HaveOccurred())
+		file = filepath.Join(dir, "app.tgz")
+		Expect(ioutil.WriteFile(file, []byte("app\n"), 0644)).To(Succeed())
+	})
+
+	AfterEach(func() {
+		Config.Account = account
+		httpclient.Insecure = insecure
+		server.Close()
+		os.RemoveAll(dir)
+	})
+
+	locator := func() *cm15.RightScriptAttachmentLocator {
+		client, _ := Config.Account.Client15()
+		return client.RightScriptAttachmentLocator("/api/right_scripts/1/attachments")
+	}
+
+	It("does not create an attachment again if a failed create created it", func() {
+		keepFirst = true
+		Expect(uploadAttachmentFile(locator(), "create", file, "app.tgz")).To(Succeed())
+		Expect(creates).To(Equal(1))
+	})
+
+	It("creates an attachment again if a failed create did not create it", func() {
+		keepFirst = false
+		Expect(uploadAttachmentFile(locator(), "create", file, "app.tgz")).To(Succeed())
+		Expect(creates).To(Equal(2))
+	})
+})
+
+var _ = Describe("Finding a RightScript by name", func() {
+	var (
+		server   *httptest.Server
+		account  *Account
+		insecure bool
+	)
+
+	BeforeEach(func() {
+		server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path != "/api/right_scripts" {
+				http.NotFound(w, r)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			fmt.Fprint(w, `[{"id": "1", "name": "Install App", "revision": 0, "lineage": "not a lineage"}]`)
+		}))
+		insecure = httpclient.Insecure
+		httpclient.Insecure = true
+		account = Config.Account
+		Config.Account = &Account{Id: 12345, client15: cm15.New(strings.TrimPrefix(server.URL, "http://"), nil)}
+	})
+
+	AfterEach(func() {
+		Config.Account = account
+		httpclient.Insecure = insecure
+		server.Close()
+	})
+
+	It("returns an error for a lineage it cannot read instead of panicking", func() {
+		_, err := rightScriptIdByName("Install App")
+		Expect(err).To(MatchError("Unexpected RightScript lineage format: not a lineage"))
+	})
+})
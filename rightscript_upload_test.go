@@ -0,0 +1,70 @@
+package main
+
+import (
+	"github.com/rightscale/rsc/cm15"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Planning attachment changes", func() {
+	attachment := func(name, md5 string) *cm15.RightScriptAttachment {
+		return &cm15.RightScriptAttachment{Filename: name, Digest: md5}
+	}
+	file := func(name, md5 string) attachmentFile {
+		return attachmentFile{name: name, md5: md5}
+	}
+
+	It("leaves attachments with the same name and contents alone", func() {
+		changes := planAttachmentChanges([]attachmentFile{file("conf/app.xml", "aaa")},
+			[]*cm15.RightScriptAttachment{attachment("app.xml", "aaa")})
+		Expect(changes).To(Equal(attachmentChanges{unchanged: []attachmentFile{file("conf/app.xml", "aaa")}}))
+	})
+
+	It("uploads new attachments and deletes obsolete ones after", func() {
+		obsolete := attachment("old.xml", "bbb")
+		changes := planAttachmentChanges([]attachmentFile{file("new.xml", "aaa")}, []*cm15.RightScriptAttachment{obsolete})
+		Expect(changes).To(Equal(attachmentChanges{
+			uploads:  []attachmentUpload{{attachmentFile: file("new.xml", "aaa")}},
+			obsolete: []*cm15.RightScriptAttachment{obsolete},
+		}))
+	})
+
+	It("replaces the contents of changed attachments", func() {
+		changed := attachment("app.xml", "bbb")
+		changes := planAttachmentChanges([]attachmentFile{file("app.xml", "aaa")}, []*cm15.RightScriptAttachment{changed})
+		Expect(changes).To(Equal(attachmentChanges{
+			uploads: []attachmentUpload{{attachmentFile: file("app.xml", "aaa"), replaces: changed}},
+		}))
+	})
+
+	It("renames attachments with the same contents", func() {
+		renamed := attachment("old.xml", "aaa")
+		changes := planAttachmentChanges([]attachmentFile{file("new.xml", "aaa")}, []*cm15.RightScriptAttachment{renamed})
+		Expect(changes).To(Equal(attachmentChanges{
+			renames: []attachmentRename{{attachment: renamed, name: "new.xml"}},
+		}))
+	})
+
+	It("does not rename an attachment which is still wanted under its name", func() {
+		first, second := attachment("first.xml", "bbb"), attachment("second.xml", "aaa")
+		changes := planAttachmentChanges([]attachmentFile{file("first.xml", "aaa"), file("second.xml", "bbb")},
+			[]*cm15.RightScriptAttachment{first, second})
+		Expect(changes).To(Equal(attachmentChanges{
+			uploads: []attachmentUpload{
+				{attachmentFile: file("first.xml", "aaa"), replaces: first},
+				{attachmentFile: file("second.xml", "bbb"), replaces: second},
+			},
+		}))
+	})
+
+	It("does not rename onto the name of another attachment", func() {
+		taken, renamable := attachment("app.xml", "bbb"), attachment("old.xml", "aaa")
+		changes := planAttachmentChanges([]attachmentFile{file("app.xml", "aaa")},
+			[]*cm15.RightScriptAttachment{taken, renamable})
+		Expect(changes).To(Equal(attachmentChanges{
+			uploads:  []attachmentUpload{{attachmentFile: file("app.xml", "aaa"), replaces: taken}},
+			obsolete: []*cm15.RightScriptAttachment{renamable},
+		}))
+	})
+})
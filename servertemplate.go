@@ -17,18 +17,89 @@ import (
 
 type ServerTemplate struct {
 	href             string
+	file             string
 	Name             string                    `yaml:"Name"`
 	Description      string                    `yaml:"Description"`
 	Inputs           map[string]*InputValue    `yaml:"Inputs"`
+	InputsFile       string                    `yaml:"-"`
 	RightScripts     map[string][]*RightScript `yaml:"RightScripts"`
 	MultiCloudImages []*MultiCloudImage        `yaml:"MultiCloudImages"`
 	Alerts           []*Alert                  `yaml:"Alerts"`
 }
 
+// Inputs is the format of an Inputs file a ServerTemplate can refer to instead of listing its inputs itself.
+type Inputs struct {
+	Inputs map[string]*InputValue `yaml:"Inputs"`
+}
+
+// inputsOrFile parses the Inputs of a ServerTemplate which are either the inputs or a reference to an Inputs file.
+type inputsOrFile struct {
+	inputs map[string]*InputValue
+	file   string
+}
+
+func (i *inputsOrFile) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	err := unmarshal(&i.file)
+	if err == nil {
+		return nil
+	}
+	i.file = ""
+	return unmarshal(&i.inputs)
+}
+
+func (st *ServerTemplate) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	// make a dummy struct that will parse the same as ServerTemplate so we can unmarshall it without having UnmarshalYAML infinitely recursing,
+	// named the same so errors about unknown fields still refer to ServerTemplate
+	type ServerTemplate struct {
+		Name             string                    `yaml:"Name"`
+		Description      string                    `yaml:"Description"`
+		Inputs           inputsOrFile              `yaml:"Inputs"`
+		RightScripts     map[string][]*RightScript `yaml:"RightScripts"`
+		MultiCloudImages []*MultiCloudImage        `yaml:"MultiCloudImages"`
+		Alerts           []*Alert                  `yaml:"Alerts"`
+	}
+	var mapST ServerTemplate
+	err := unmarshal(&mapST)
+	if err != nil {
+		return err
+	}
+	st.Name = mapST.Name
+	st.Description = mapST.Description
+	st.Inputs = mapST.Inputs.inputs
+	st.InputsFile = mapST.Inputs.file
+	st.RightScripts = mapST.RightScripts
+	st.MultiCloudImages = mapST.MultiCloudImages
+	st.Alerts = mapST.Alerts
+	return nil
+}
+
+// ExpandInputs reads the inputs of a ServerTemplate from its Inputs file if it refers to one.
+func ExpandInputs(dir string, st *ServerTemplate) error {
+	if st.InputsFile == "" {
+		return nil
+	}
+	bytes, err := ioutil.ReadFile(filepath.Join(dir, st.InputsFile))
+	if err != nil {
+		return err
+	}
+	var container Inputs
+	err = yaml.UnmarshalStrict(bytes, &container)
+	if err != nil {
+		return fmt.Errorf("%v: %v", st.InputsFile, err)
+	}
+	st.Inputs = container.Inputs
+	return nil
+}
+
 var sequenceTypes []string = []string{"Boot", "Operational", "Decommission"}
 
-func stUpload(files []string, prefix string) {
+const (
+	SingleLayout = "single"
+	SplitLayout  = "split"
+)
 
+func stUpload(files []string, prefix string, concurrency int) {
+	stDefs := make([]*ServerTemplate, 0, len(files))
 	for _, file := range files {
 		fmt.Printf("Validating %s\n", file)
 		st, errors := validateServerTemplate(file)
@@ -37,25 +108,42 @@ func stUpload(files []string, prefix string) {
 			for _, err := range errors {
 				fmt.Println(err)
 			}
-			os.Exit(1)
-		}
-		stName := st.Name
-		if prefix != "" {
-			stName = fmt.Sprintf("%s_%s", prefix, stName)
+			exit(1)
 		}
+		stName := devName(st.Name, prefix)
 		fmt.Printf("Validation successful, uploading as '%s'\n", stName)
 
 		if *debug {
 			fmt.Printf("ST: %#v\n", *st)
 		}
-		err := doServerTemplateUpload(st, prefix)
+		stDefs = append(stDefs, st)
+	}
 
-		if err != nil {
-			fatalError("Failed to upload ServerTemplate '%s': %s", file, err.Error())
-		}
+	upload, err := newServerTemplateUpload(stDefs, prefix)
+	if err != nil {
+		fatalError("%s", err.Error())
+	}
+	// create the API client before several uploads use it at once
+	Config.Account.Client15()
+	setAttachmentUploadConcurrency(concurrency)
+
+	// Refuse to overwrite changes made since the last upload before changing anything
+	if errs := upload.check(concurrency); len(errs) != 0 {
+		uploadFailed(errs)
+	}
+	if errs := upload.run(concurrency); len(errs) != 0 {
+		uploadFailed(errs)
 	}
 }
 
+// uploadFailed reports every error of an upload and exits.
+func uploadFailed(errs []error) {
+	for _, err := range errs {
+		fmt.Fprintf(os.Stderr, "ERROR: %s\n", err.Error())
+	}
+	exit(1)
+}
+
 func stDelete(files []string, prefix string) {
 	client, _ := Config.Account.Client15()
 
@@ -72,10 +160,7 @@ func stDelete(files []string, prefix string) {
 		}
 
 		// ServerTemplate first, then dependent parts
-		stName := st.Name
-		if prefix != "" {
-			stName = fmt.Sprintf("%s_%s", prefix, st.Name)
-		}
+		stName := devName(st.Name, prefix)
 		hrefs, err := paramToHrefs("server_templates", stName, 0)
 		if err != nil {
 			fatalError("Could not query for ServerTemplates to delete: %s", err.Error())
@@ -95,10 +180,7 @@ func stDelete(files []string, prefix string) {
 		// MultiCloudImages. Only delete ones managed by us and not simply ones we link to.
 		for _, mciDef := range st.MultiCloudImages {
 			if len(mciDef.Settings) > 0 {
-				mciName := mciDef.Name
-				if prefix != "" {
-					mciName = fmt.Sprintf("%s_%s", prefix, mciDef.Name)
-				}
+				mciName := devName(mciDef.Name, prefix)
 				err := deleteMultiCloudImage(mciName)
 				if err != nil {
 					fmt.Printf("Failed to delete MultiCloudImage %s: %s\n", mciName, err.Error())
@@ -125,92 +207,300 @@ func stDelete(files []string, prefix string) {
 
 }
 
-// Options:
-//   -- commit
-func doServerTemplateUpload(stDef *ServerTemplate, prefix string) error {
-	client, _ := Config.Account.Client15()
+// serverTemplateUpload uploads ServerTemplates along with their RightScripts and MultiCloudImages. Steps which do not
+// depend on each other run concurrently and RightScripts and MultiCloudImages used by more than one of the
+// ServerTemplates are only pushed once.
+type serverTemplateUpload struct {
+	prefix string
+	stDefs []*ServerTemplate
+	sts    []*cm15.ServerTemplate // the existing ServerTemplate for each of stDefs, if there is one
+
+	rightScripts      []*sharedRightScript
+	rightScriptsByKey map[string]*sharedRightScript
+	mcis              []*sharedMultiCloudImage
+	mcisByKey         map[string]*sharedMultiCloudImage
+}
+
+// sharedRightScript is a RightScript used by one or more of the ServerTemplates being uploaded. The first use is pushed
+// and its Href copied to the others.
+type sharedRightScript struct {
+	scripts []*RightScript
+	task    *uploadTask
+}
 
-	// Check if ST with same name (HEAD revisions only) exists. If it does, update the head
-	stName := stDef.Name
+// sharedMultiCloudImage is a MultiCloudImage used by one or more of the ServerTemplates being uploaded. The first use is
+// uploaded or imported and its Href copied to the others.
+type sharedMultiCloudImage struct {
+	mcis   []*MultiCloudImage
+	source string // the file a managed MultiCloudImage is defined in
+	task   *uploadTask
+}
 
-	if prefix != "" {
-		stName = fmt.Sprintf("%s_%s", prefix, stDef.Name)
+// newServerTemplateUpload prepares uploading validated ServerTemplates. It is an error for two ServerTemplates to be
+// uploaded as the same one or to use different RightScripts or MultiCloudImages which would be uploaded as the same
+// one.
+func newServerTemplateUpload(stDefs []*ServerTemplate, prefix string) (*serverTemplateUpload, error) {
+	upload := &serverTemplateUpload{
+		prefix:            prefix,
+		stDefs:            stDefs,
+		sts:               make([]*cm15.ServerTemplate, len(stDefs)),
+		rightScriptsByKey: make(map[string]*sharedRightScript),
+		mcisByKey:         make(map[string]*sharedMultiCloudImage),
 	}
-	st, err := getServerTemplateByName(stName)
+	files := make(map[string]string)
+	for _, stDef := range stDefs {
+		stName := devName(stDef.Name, prefix)
+		if file, ok := files[stName]; ok {
+			return nil, fmt.Errorf("ServerTemplate '%s' is uploaded from both %s and %s", stName, file, stDef.file)
+		}
+		files[stName] = stDef.file
 
-	if err != nil {
-		fatalError("Failed to query for ServerTemplate '%s': %s", stName, err.Error())
+		for _, sequenceType := range sequenceTypes {
+			for _, script := range stDef.RightScripts[sequenceType] {
+				if err := upload.addRightScript(script); err != nil {
+					return nil, err
+				}
+			}
+		}
+		for _, mciDef := range stDef.MultiCloudImages {
+			if err := upload.addMultiCloudImage(stDef, mciDef); err != nil {
+				return nil, err
+			}
+		}
 	}
+	return upload, nil
+}
+
+// rightScriptKey identifies the RightScript a RightScript definition is pushed as.
+func rightScriptKey(script *RightScript, prefix string) string {
+	if script.Type == LocalRightScript {
+		return "local:" + devName(script.Metadata.Name, prefix)
+	}
+	return fmt.Sprintf("published:%s:%d:%s", script.Name, script.Revision, script.Publisher)
+}
+
+// multiCloudImageKey identifies the MultiCloudImage a MultiCloudImage definition is uploaded or imported as. It is
+// empty for MultiCloudImages which were already found during validation.
+func multiCloudImageKey(mciDef *MultiCloudImage, prefix string) string {
+	if mciDef.Publisher != "" {
+		return fmt.Sprintf("published:%s:%d:%s", mciDef.Name, mciDef.Revision, mciDef.Publisher)
+	}
+	if len(mciDef.Settings) > 0 {
+		return "managed:" + devName(mciDef.Name, prefix)
+	}
+	return ""
+}
+
+func (upload *serverTemplateUpload) addRightScript(script *RightScript) error {
+	key := rightScriptKey(script, upload.prefix)
+	shared, ok := upload.rightScriptsByKey[key]
+	if !ok {
+		shared = &sharedRightScript{}
+		upload.rightScriptsByKey[key] = shared
+		upload.rightScripts = append(upload.rightScripts, shared)
+	} else if first := shared.scripts[0]; script.Type == LocalRightScript && filepath.Clean(first.Path) != filepath.Clean(script.Path) {
+		return fmt.Errorf("RightScript '%s' is uploaded from both %s and %s", devName(script.Metadata.Name, upload.prefix),
+			first.Path, script.Path)
+	}
+	shared.scripts = append(shared.scripts, script)
+	return nil
+}
+
+func (upload *serverTemplateUpload) addMultiCloudImage(stDef *ServerTemplate, mciDef *MultiCloudImage) error {
+	key := multiCloudImageKey(mciDef, upload.prefix)
+	if key == "" {
+		return nil
+	}
+	source := ""
+	if len(mciDef.Settings) > 0 {
+		source = mciDef.source
+		if source == "" {
+			source = stDef.file
+		}
+	}
+	shared, ok := upload.mcisByKey[key]
+	if !ok {
+		shared = &sharedMultiCloudImage{source: source}
+		upload.mcisByKey[key] = shared
+		upload.mcis = append(upload.mcis, shared)
+	} else if source != shared.source && !sameMultiCloudImage(shared.mcis[0], mciDef) {
+		return fmt.Errorf("MultiCloudImage '%s' is defined differently in %s and %s", devName(mciDef.Name, upload.prefix),
+			shared.source, source)
+	}
+	shared.mcis = append(shared.mcis, mciDef)
+	return nil
+}
+
+// check finds the existing ServerTemplates and refuses to overwrite changes made to them or their RightScripts since
+// they were last uploaded. It runs before anything is changed.
+func (upload *serverTemplateUpload) check(concurrency int) []error {
+	var graph uploadGraph
+	for i, stDef := range upload.stDefs {
+		i, stDef := i, stDef
+		graph.add(func() error {
+			stName := devName(stDef.Name, upload.prefix)
+			st, err := getServerTemplateByName(stName)
+			if err != nil {
+				return fmt.Errorf("Failed to query for ServerTemplate '%s': %s", stName, err.Error())
+			}
+			if st != nil {
+				err := checkFingerprint("server_templates", stName, getLink(st.Links, "self"), func() (Fingerprint, error) {
+					return serverTemplateFingerprint(st)
+				})
+				if err != nil {
+					return err
+				}
+			}
+			upload.sts[i] = st
+			return nil
+		})
+	}
+	for _, shared := range upload.rightScripts {
+		script := shared.scripts[0]
+		graph.add(func() error {
+			return script.checkConflict(upload.prefix)
+		})
+	}
+	return graph.run(concurrency)
+}
+
+// run uploads everything with up to concurrency API calls at once. RightScripts and MultiCloudImages are pushed
+// independently of each other while the steps of each ServerTemplate wait for what they need: the RightScripts are
+// bound once they are pushed, the Inputs set once they are bound and the MultiCloudImages attached, with the first one
+// as the default, once they are uploaded.
+func (upload *serverTemplateUpload) run(concurrency int) []error {
+	var graph uploadGraph
+	for _, shared := range upload.rightScripts {
+		shared := shared
+		shared.task = graph.add(func() error {
+			script := shared.scripts[0]
+			// Push() has the side effect of always populating script.Href which the RunnableBindings are made with
+			if err := script.Push(upload.prefix); err != nil {
+				return err
+			}
+			for _, other := range shared.scripts[1:] {
+				other.Href = script.Href
+			}
+			return nil
+		})
+	}
+	for _, shared := range upload.mcis {
+		shared := shared
+		shared.task = graph.add(func() error {
+			mciDef := shared.mcis[0]
+			var err error
+			if mciDef.Publisher != "" {
+				err = importMultiCloudImage(mciDef)
+			} else {
+				err = uploadMultiCloudImage(mciDef, shared.source, upload.prefix)
+			}
+			if err != nil {
+				return err
+			}
+			for _, other := range shared.mcis[1:] {
+				other.Href = mciDef.Href
+			}
+			return nil
+		})
+	}
+
+	for i, stDef := range upload.stDefs {
+		i, stDef := i, stDef
+		stTask := graph.add(func() error {
+			return upload.serverTemplate(i)
+		})
+
+		mciTasks := []*uploadTask{stTask}
+		for _, mciDef := range stDef.MultiCloudImages {
+			if shared, ok := upload.mcisByKey[multiCloudImageKey(mciDef, upload.prefix)]; ok {
+				mciTasks = append(mciTasks, shared.task)
+			}
+		}
+		mcis := graph.add(func() error {
+			if err := attachMultiCloudImages(stDef, upload.prefix); err != nil {
+				return fmt.Errorf("Synchronize MultiCloudImages of ServerTemplate '%s' failed: %s",
+					devName(stDef.Name, upload.prefix), err.Error())
+			}
+			return nil
+		}, mciTasks...)
+
+		scriptTasks := []*uploadTask{stTask}
+		for _, sequenceType := range sequenceTypes {
+			for _, script := range stDef.RightScripts[sequenceType] {
+				scriptTasks = append(scriptTasks, upload.rightScriptsByKey[rightScriptKey(script, upload.prefix)].task)
+			}
+		}
+		bindings := graph.add(func() error {
+			return upload.runnableBindings(i)
+		}, scriptTasks...)
+		inputs := graph.add(func() error {
+			return upload.inputs(i)
+		}, bindings)
+
+		alerts := graph.add(func() error {
+			if err := uploadAlerts(stDef); err != nil {
+				return fmt.Errorf("Synchronize Alerts of ServerTemplate '%s' failed: %s",
+					devName(stDef.Name, upload.prefix), err.Error())
+			}
+			return nil
+		}, stTask)
+
+		graph.add(func() error {
+			return upload.finish(i)
+		}, mcis, inputs, alerts)
+	}
+	return graph.run(concurrency)
+}
+
+// serverTemplate creates or updates the i'th ServerTemplate itself.
+func (upload *serverTemplateUpload) serverTemplate(i int) error {
+	client, _ := Config.Account.Client15()
 
-	// -----------------
-	// Synchronize ST
-	// -----------------
 	// st = ST cloud object. stDef = ST defined in YAML on disk
+	stDef, st := upload.stDefs[i], upload.sts[i]
+	stName := devName(stDef.Name, upload.prefix)
+	created := st == nil
 	stVerb := "Using"
-	if st == nil {
+	if created {
 		params := cm15.ServerTemplateParam{
 			Description: stDef.Description,
 			Name:        stName,
 		}
 		stLoc, err := client.ServerTemplateLocator("/api/server_templates").Create(&params)
 		if err != nil {
-			fatalError("Failed to create ServerTemplate '%s': %s", stName, err.Error())
+			return fmt.Errorf("Failed to create ServerTemplate '%s': %s", stName, err.Error())
 		}
 		st, err = stLoc.Show(rsapi.APIParams{})
 		if err != nil {
-			fatalError("Failed to refetch ServerTemplate '%s': %s", stLoc.Href, err.Error())
+			return fmt.Errorf("Failed to refetch ServerTemplate '%s': %s", stLoc.Href, err.Error())
 		}
 		stVerb = "Creating"
 	} else {
 		if st.Description != stDef.Description {
 			err := st.Locator(client).Update(&cm15.ServerTemplateParam{Description: stDef.Description})
 			if err != nil {
-				fatalError("Failed to update ServerTemplate '%s' description: %s", stName, err.Error())
+				return fmt.Errorf("Failed to update ServerTemplate '%s' description: %s", stName, err.Error())
 			}
 		}
 	}
 	stDef.href = getLink(st.Links, "self")
-	fmt.Printf("%s ServerTemplate with HREF %s\n", stVerb, stDef.href)
-
-	// -----------------
-	// Synchronize MCIs
-	// -----------------
-	// Get a list of MCIs on the existing ST.
-	fmt.Println("Updating MCIs:")
-	if err := uploadMultiCloudImages(stDef, prefix); err != nil {
-		fatalError("  Synchronize MultiCloudImages failed: %s", err.Error())
-	}
-	fmt.Println("  MCIs synced")
-
-	// -----------------
-	// Synchronize RightScripts
-	// -----------------
-	// By the time we get to here, we've done a good bit of error checking, so don't have to recheck much.
-	// We know the files on disk are all openable, the RightScripts on disk have valid metadata.
-	//   TBD: issue: we don't check for duplicate rightscript names till .Push(). move that check to validation section?
-	// Get RightScript object in RightScale. RightScript.Push() handles both cases below:
-	//		1. Doesn't exist: create
-	//		2. Exists: Update contents
-	fmt.Println("Updating or Creating RightScripts:")
-	hrefByName := make(map[string]string)
-	for _, sequenceType := range sequenceTypes {
-		for _, script := range stDef.RightScripts[sequenceType] {
-			if _, ok := hrefByName[script.Metadata.Name]; ok {
-				continue
-			}
-			// Push() has the side effort of always populating script.Href which we use below -- probably
-			// rework this to be a bit more upfront in the future.
-			err := script.Push(prefix)
-			hrefByName[script.Metadata.Name] = script.Href
-			if err != nil {
-				fatalError("  %s", err.Error())
-			}
-		}
+	upload.sts[i] = st
+	fmt.Printf("%s ServerTemplate '%s' with HREF %s\n", stVerb, stName, stDef.href)
+	if err := claimOwnership("server_templates", stName, stDef.href, ownerOf(stDef.file, upload.prefix), created); err != nil {
+		return fmt.Errorf("Failed to tag ServerTemplate '%s': %s", stName, err.Error())
 	}
-	fmt.Println("  RightScripts synced")
+	return nil
+}
+
+// runnableBindings makes the RightScripts of the i'th ServerTemplate the pushed ones in the right order.
+func (upload *serverTemplateUpload) runnableBindings(i int) error {
+	client, _ := Config.Account.Client15()
+
+	stDef, st := upload.stDefs[i], upload.sts[i]
+	stName := devName(stDef.Name, upload.prefix)
 
 	// Add new RightScripts to the sequence list. Don't worry about order for now, that'll be fixed up below
-	fmt.Println("Setting order of RightScripts:")
+	fmt.Printf("Setting order of RightScripts of ServerTemplate '%s':\n", stName)
 	rbLoc := client.RunnableBindingLocator(getLink(st.Links, "runnable_bindings"))
 	existingRbs, _ := rbLoc.Index(rsapi.APIParams{})
 	// Remove RightScripts that don't belong from the sequence list. We must remove first else we might get an
@@ -219,18 +509,17 @@ func doServerTemplateUpload(stDef *ServerTemplate, prefix string) error {
 		seenExistingRb := false
 		for _, sequenceType := range sequenceTypes {
 			for _, script := range stDef.RightScripts[sequenceType] {
-				scriptHref := hrefByName[script.Metadata.Name]
 				rbHref := getLink(rb.Links, "right_script")
-				if rb.Sequence == strings.ToLower(sequenceType) && rbHref == scriptHref {
+				if rb.Sequence == strings.ToLower(sequenceType) && rbHref == script.Href {
 					seenExistingRb = true
 				}
 			}
 		}
 		if !seenExistingRb {
-			fmt.Printf("  Removing %s from ServerTemplate %s bundle\n", getLink(rb.Links, "right_script"), rb.Sequence)
+			fmt.Printf("  Removing %s from ServerTemplate '%s' %s bundle\n", getLink(rb.Links, "right_script"), stName, rb.Sequence)
 			err := rb.Locator(client).Destroy()
 			if err != nil {
-				fatalError("  Could not destroy RunnableBinding %s: %s", getLink(rb.Links, "right_script"), err.Error())
+				return fmt.Errorf("Could not destroy RunnableBinding %s: %s", getLink(rb.Links, "right_script"), err.Error())
 			}
 		}
 	}
@@ -238,22 +527,21 @@ func doServerTemplateUpload(stDef *ServerTemplate, prefix string) error {
 	for _, sequenceType := range sequenceTypes {
 		for _, script := range stDef.RightScripts[sequenceType] {
 			seenScript := false
-			scriptHref := hrefByName[script.Metadata.Name]
 			for _, rb := range existingRbs {
 				rbHref := getLink(rb.Links, "right_script")
-				if rb.Sequence == strings.ToLower(sequenceType) && rbHref == scriptHref {
+				if rb.Sequence == strings.ToLower(sequenceType) && rbHref == script.Href {
 					seenScript = true
 				}
 			}
 			if !seenScript {
 				params := cm15.RunnableBindingParam{
-					RightScriptHref: scriptHref,
+					RightScriptHref: script.Href,
 					Sequence:        strings.ToLower(sequenceType),
 				}
-				fmt.Printf("  Adding %s to %s bundle\n", scriptHref, strings.ToLower(sequenceType))
+				fmt.Printf("  Adding %s to ServerTemplate '%s' %s bundle\n", script.Href, stName, strings.ToLower(sequenceType))
 				_, err := rbLoc.Create(&params)
 				if err != nil {
-					fatalError("  Could not create %s RunnableBinding for HREF %s: %s", sequenceType, scriptHref, err.Error())
+					return fmt.Errorf("Could not create %s RunnableBinding for HREF %s: %s", sequenceType, script.Href, err.Error())
 				}
 			}
 		}
@@ -270,10 +558,10 @@ func doServerTemplateUpload(stDef *ServerTemplate, prefix string) error {
 	bindings := []*cm15.RunnableBindings{}
 	for _, sequenceType := range sequenceTypes {
 		for i, script := range stDef.RightScripts[sequenceType] {
-			key := strings.ToLower(sequenceType) + "_" + hrefByName[script.Metadata.Name]
+			key := strings.ToLower(sequenceType) + "_" + script.Href
 			rb, ok := rbLookup[key]
 			if !ok {
-				fatalError("  Could not lookup RunnableBinding %s", key)
+				return fmt.Errorf("Could not lookup RunnableBinding %s", key)
 			}
 			b := cm15.RunnableBindings{
 				Id:       rb.Id,
@@ -284,23 +572,29 @@ func doServerTemplateUpload(stDef *ServerTemplate, prefix string) error {
 		}
 	}
 	if len(bindings) > 0 {
-		err = rbLoc.MultiUpdate(bindings)
+		err := rbLoc.MultiUpdate(bindings)
 		if err != nil {
-			fatalError("  MultiUpdate to set RunnableBinding order failed: %s", err.Error())
+			return fmt.Errorf("MultiUpdate to set RunnableBinding order failed: %s", err.Error())
 		}
-		fmt.Println("  RightScript order set")
+		fmt.Printf("  RightScript order of ServerTemplate '%s' set\n", stName)
 	} else {
-		fmt.Println("  No RightScripts to order")
+		fmt.Printf("  No RightScripts to order for ServerTemplate '%s'\n", stName)
 	}
+	return nil
+}
+
+// inputs sets the Inputs of the i'th ServerTemplate, inheriting the ones which are not set.
+func (upload *serverTemplateUpload) inputs(i int) error {
+	client, _ := Config.Account.Client15()
 
-	// -----------------
-	// Set Inputs
-	// -----------------
-	fmt.Println("Setting Inputs")
+	stDef := upload.stDefs[i]
+	stName := devName(stDef.Name, upload.prefix)
+
+	fmt.Printf("Setting Inputs of ServerTemplate '%s'\n", stName)
 	inputsLoc := client.InputLocator(stDef.href + "/inputs")
 	oldInputs, err := inputsLoc.Index(rsapi.APIParams{"view": "inputs_2_0"})
 	if err != nil {
-		fatalError("  Failed to Index inputs: %s", err.Error())
+		return fmt.Errorf("Failed to Index inputs: %s", err.Error())
 	}
 	inputParams := make(map[string]interface{})
 	for _, input := range oldInputs {
@@ -312,24 +606,36 @@ func doServerTemplateUpload(stDef *ServerTemplate, prefix string) error {
 	if len(inputParams) > 0 {
 		err = inputsLoc.MultiUpdate(inputParams)
 		if err != nil {
-			fatalError("  Failed to MultiUpdate inputs: %s", err.Error())
+			return fmt.Errorf("Failed to MultiUpdate inputs: %s", err.Error())
 		}
-		fmt.Println("  Inputs set")
+		fmt.Printf("  Inputs of ServerTemplate '%s' set\n", stName)
 	} else {
-		fmt.Println("  No inputs to set")
+		fmt.Printf("  No inputs to set for ServerTemplate '%s'\n", stName)
 	}
+	return nil
+}
 
-	// -----------------
-	// Synchronize Alerts
-	// -----------------
-	fmt.Println("Synchronizing Alerts")
-	if err := uploadAlerts(stDef); err != nil {
-		fatalError("  Synchronize alerts failed: %s", err.Error())
+// finish records the fingerprint of the i'th ServerTemplate once everything else was uploaded.
+func (upload *serverTemplateUpload) finish(i int) error {
+	client, _ := Config.Account.Client15()
+
+	stDef := upload.stDefs[i]
+	stName := devName(stDef.Name, upload.prefix)
+
+	// refetch since the description may have been updated
+	st, err := client.ServerTemplateLocator(stDef.href).Show(rsapi.APIParams{})
+	if err != nil {
+		return fmt.Errorf("Failed to refetch ServerTemplate '%s': %s", stDef.href, err.Error())
+	}
+	fingerprint, err := serverTemplateFingerprint(st)
+	if err == nil {
+		err = recordFingerprint(stDef.href, fingerprint)
+	}
+	if err != nil {
+		return fmt.Errorf("Failed to record fingerprint of ServerTemplate '%s': %s", stName, err.Error())
 	}
 
 	fmt.Printf("Successfully uploaded ServerTemplate %s with HREF %s\n", st.Name, stDef.href)
-
-	// If the user requested a commit on changes, commit the ST. This will commit all RightScripts as well.
 	return nil
 }
 
@@ -373,6 +679,7 @@ func stShow(href string) {
 	fmt.Printf("Name: %s\n", st.Name)
 	fmt.Printf("HREF: %s\n", stHref)
 	fmt.Printf("Revision: %s\n", rev)
+	showOwner(stHref)
 	fmt.Printf("Description: \n%s\n", st.Description)
 	fmt.Printf("MultiCloudImages: (href, rev, name) \n")
 	for _, item := range mcis {
@@ -415,7 +722,42 @@ func stShow(href string) {
 	}
 }
 
-func stDownload(href, downloadTo string, usePublished bool, downloadMciSettings bool, scriptPath string) {
+func stDownload(href, downloadTo string, usePublished bool, downloadMciSettings bool, referencePublishedMcis bool, scriptPath string, alertFormat string, layout string, concurrency int) string {
+	return downloadServerTemplate(href, downloadTo, usePublished, downloadMciSettings, referencePublishedMcis, alertFormat, layout, func(rsHrefs []string, stDir string) []string {
+		if scriptPath != "" {
+			// Create scripts directory
+			err := os.MkdirAll(filepath.Join(stDir, scriptPath), 0755)
+			if err != nil {
+				fatalError("Error creating directory: %s", err.Error())
+			}
+		}
+		dirs := make([]string, len(rsHrefs))
+		for i := range dirs {
+			dirs[i] = filepath.Join(stDir, scriptPath)
+		}
+		return rightScriptDownloads(rsHrefs, dirs, concurrency)
+	})
+}
+
+// eachRightScript downloads RightScripts one at a time with a function which downloads a single RightScript.
+func eachRightScript(downloadScript func(rsHref, stDir string) string) func(rsHrefs []string, stDir string) []string {
+	return func(rsHrefs []string, stDir string) []string {
+		downloadedTo := make([]string, len(rsHrefs))
+		for i, rsHref := range rsHrefs {
+			downloadedTo[i] = downloadScript(rsHref, stDir)
+		}
+		return downloadedTo
+	}
+}
+
+// downloadServerTemplate downloads a ServerTemplate to a YAML file. The attached RightScripts which are not linked to
+// as publications are downloaded with downloadScripts, which is given the hrefs of the RightScripts and the directory
+// of the ServerTemplate YAML file and returns the paths the RightScripts were downloaded to. With the split layout the
+// MultiCloudImages, Alerts and Inputs are written to their own files. With downloadMciSettings and
+// referencePublishedMcis, MultiCloudImages published in the MultiCloud Marketplace are still referenced by their
+// publication instead of having their settings downloaded.
+func downloadServerTemplate(href, downloadTo string, usePublished, downloadMciSettings, referencePublishedMcis bool, alertFormat, layout string,
+	downloadScripts func(rsHrefs []string, stDir string) []string) string {
 	client, _ := Config.Account.Client15()
 
 	stLocator := client.ServerTemplateLocator(href)
@@ -435,7 +777,7 @@ func stDownload(href, downloadTo string, usePublished bool, downloadMciSettings
 	//-------------------------------------
 	// MultiCloudImages
 	//-------------------------------------
-	mcis, err := downloadMultiCloudImages(st, downloadMciSettings)
+	mcis, err := downloadMultiCloudImages(st, downloadMciSettings, referencePublishedMcis)
 	if err != nil {
 		fatalError("Could not get MCIs from API: %s", err.Error())
 	}
@@ -468,6 +810,7 @@ func stDownload(href, downloadTo string, usePublished bool, downloadMciSettings
 		rightScripts[sequenceType] = make([]*RightScript, count)
 	}
 	fmt.Printf("Downloading %d attached RightScripts:\n", len(seenRightscript))
+	var scriptHrefs []string
 	for _, rb := range rbs {
 		rsHref := getLink(rb.Links, "right_script")
 		if rsHref == "" {
@@ -512,26 +855,22 @@ func stDownload(href, downloadTo string, usePublished bool, downloadMciSettings
 		rightScripts[sequence][positionBySequence[sequence][rb.Position]] = &newScript
 
 		if newScript.Type == LocalRightScript {
-			if scriptPath == "" {
-				downloadedTo := rightScriptDownload(rsHref, filepath.Dir(downloadTo))
-				newScript.Path = strings.TrimPrefix(downloadedTo, filepath.Dir(downloadTo)+string(filepath.Separator))
-			} else {
-				// Create scripts directory
-				err := os.MkdirAll(filepath.Join(filepath.Dir(downloadTo), scriptPath), 0755)
-				if err != nil {
-					fatalError("Error creating directory: %s", err.Error())
-				}
-				downloadedTo := rightScriptDownload(rsHref, filepath.Join(filepath.Dir(downloadTo), scriptPath))
-				newScript.Path = strings.TrimPrefix(downloadedTo, filepath.Dir(downloadTo)+string(filepath.Separator))
-			}
+			scriptHrefs = append(scriptHrefs, rsHref)
 		}
 		seenRightscript[rsHref] = &newScript
 	}
+	for i, downloadedTo := range downloadScripts(scriptHrefs, filepath.Dir(downloadTo)) {
+		script := seenRightscript[scriptHrefs[i]]
+		script.Path, err = filepath.Rel(filepath.Dir(downloadTo), downloadedTo)
+		if err != nil {
+			script.Path = downloadedTo
+		}
+	}
 
 	//-------------------------------------
 	// Alerts
 	//-------------------------------------
-	alerts, err := downloadAlerts(st)
+	alerts, err := downloadAlerts(st, alertFormat)
 	if err != nil {
 		fatalError("Could not get Alerts from API: %s", err.Error())
 	}
@@ -571,22 +910,157 @@ func stDownload(href, downloadTo string, usePublished bool, downloadMciSettings
 		RightScripts:     rightScripts,
 		Alerts:           alerts,
 	}
-	bytes, err := yaml.Marshal(&stDef)
+	// Update an existing YAML file for the same ServerTemplate in place so re-downloading only changes what changed
+	if existing, err := loadServerTemplate(downloadTo); err == nil && existing != nil && existing.Name == stDef.Name {
+		if layout == SplitLayout {
+			fmt.Printf("WARNING: '%s' already exists and is updated in place keeping its layout, --layout split only applies to new files\n",
+				downloadTo)
+		}
+		update := &pullState{force: true}
+		err := update.updateServerTemplateFile(downloadTo, &stDef, "", alertFormat,
+			func(local *ServerTemplate, raw yaml.MapSlice) (yaml.MapSlice, error) {
+				return downloadedRightScripts(filepath.Dir(downloadTo), local, raw, &stDef)
+			})
+		if err != nil {
+			fatalError("Could not update '%s': %s", downloadTo, err.Error())
+		}
+	} else {
+		var content interface{} = &stDef
+		if layout == SplitLayout {
+			if content, err = splitServerTemplate(filepath.Dir(downloadTo), &stDef); err != nil {
+				fatalError("Could not split ServerTemplate into files: %s", err.Error())
+			}
+		}
+		bytes, err := yaml.Marshal(content)
+		if err != nil {
+			fatalError("Creating yaml failed: %s", err.Error())
+		}
+		err = ioutil.WriteFile(downloadTo, bytes, 0644)
+		if err != nil {
+			fatalError("Could not create file: %s", err.Error())
+		}
+	}
+	fmt.Printf("Finished downloading '%s' to '%s'\n", st.Name, downloadTo)
+	return downloadTo
+}
+
+// splitServerTemplate writes the Inputs, the MultiCloudImages with settings and the Alerts of a downloaded
+// ServerTemplate to their own files relative to the directory of its YAML file and returns the YAML of the
+// ServerTemplate referring to them. A MultiCloudImage file which is already there for the same MultiCloudImage, such
+// as one written for another ServerTemplate downloaded to the same directory, is referred to instead of being written
+// again.
+func splitServerTemplate(dir string, stDef *ServerTemplate) (yaml.MapSlice, error) {
+	split := &pullState{force: true}
+	st := yaml.MapSlice{{Key: "Name", Value: stDef.Name}, {Key: "Description", Value: stDef.Description}}
+
+	if len(stDef.Inputs) > 0 {
+		file := filepath.Join("inputs", cleanFileName(stDef.Name)+".yml")
+		if err := split.writeYAMLFile(filepath.Join(dir, file), &Inputs{Inputs: stDef.Inputs}); err != nil {
+			return nil, err
+		}
+		st = append(st, yaml.MapItem{Key: "Inputs", Value: filepath.ToSlash(file)})
+	} else {
+		st = append(st, yaml.MapItem{Key: "Inputs", Value: stDef.Inputs})
+	}
+
+	st = append(st, yaml.MapItem{Key: "RightScripts", Value: stDef.RightScripts})
+
+	mcis := make([]interface{}, 0, len(stDef.MultiCloudImages))
+	for _, mci := range stDef.MultiCloudImages {
+		// MultiCloudImages which are only referred to by name stay in the ServerTemplate
+		if len(mci.Settings) == 0 {
+			mcis = append(mcis, mci)
+			continue
+		}
+		file := multiCloudImageFile(dir, mci)
+		if err := split.writeYAMLFile(filepath.Join(dir, file), mci); err != nil {
+			return nil, err
+		}
+		mcis = append(mcis, filepath.ToSlash(file))
+	}
+	st = append(st, yaml.MapItem{Key: "MultiCloudImages", Value: mcis})
+
+	if len(stDef.Alerts) > 0 {
+		file := filepath.Join("alerts", cleanFileName(stDef.Name)+".yml")
+		if err := split.writeYAMLFile(filepath.Join(dir, file), &Alerts{Alerts: stDef.Alerts}); err != nil {
+			return nil, err
+		}
+		st = append(st, yaml.MapItem{Key: "Alerts", Value: []string{filepath.ToSlash(file)}})
+	} else {
+		st = append(st, yaml.MapItem{Key: "Alerts", Value: stDef.Alerts})
+	}
+	return st, nil
+}
+
+// multiCloudImageFile returns the file relative to dir to write a MultiCloudImage to, which is named after it unless
+// that file is taken by a different MultiCloudImage. A file is only shared with the same MultiCloudImage with the same
+// settings, otherwise a ServerTemplate downloaded before would end up referring to settings which are not its own.
+func multiCloudImageFile(dir string, mci *MultiCloudImage) string {
+	base := cleanFileName(mci.Name)
+	for i := 1; ; i++ {
+		file := filepath.Join("multi_cloud_images", base+".yml")
+		if i > 1 {
+			file = filepath.Join("multi_cloud_images", fmt.Sprintf("%s_%d.yml", base, i))
+		}
+		if _, err := os.Stat(filepath.Join(dir, file)); os.IsNotExist(err) {
+			return file
+		}
+		if existing := loadMultiCloudImage(filepath.Join(dir, file)); existing != nil && existing.Name == mci.Name &&
+			sameMultiCloudImage(existing, mci) {
+			return file
+		}
+	}
+}
+
+// stCopy copies a ServerTemplate from one account to another by downloading it from the first account to a temporary
+// directory and uploading it to the second. Published RightScripts and MCIs are kept as references to their
+// publications, which are imported into the target account when uploading, and other MCIs are recreated from their
+// settings in the target account by cloud name. What is uploaded is recorded as copied from the ServerTemplate in the
+// first account rather than from the temporary directory.
+func stCopy(nameOrHref, fromAccount, toAccount string, concurrency int) {
+	if strings.EqualFold(fromAccount, toAccount) {
+		fatalError("Cannot copy a ServerTemplate to the same account it is in: %s", fromAccount)
+	}
+
+	tempDir, err := ioutil.TempDir("", "right_st_copy")
 	if err != nil {
-		fatalError("Creating yaml failed: %s", err.Error())
+		fatalError("Could not create temporary directory: %s", err.Error())
 	}
-	err = ioutil.WriteFile(downloadTo, bytes, 0644)
+	// fatalError exits without running deferred calls so remove the temporary directory then too
+	defer onExit(func() { os.RemoveAll(tempDir) })()
+
+	if err := Config.switchAccount(fromAccount); err != nil {
+		fatalError("%s", err.Error())
+	}
+	href, err := paramToHref("server_templates", nameOrHref, 0, false)
 	if err != nil {
-		fatalError("Could not create file: %s", err.Error())
+		fatalError("%s", err.Error())
 	}
-	fmt.Printf("Finished downloading '%s' to '%s'\n", st.Name, downloadTo)
+	fmt.Printf("Copying ServerTemplate %s from account %s to account %s\n", href, fromAccount, toAccount)
+	file := stDownload(href, tempDir, true, true, true, "", ClauseAlertFormat, SingleLayout, concurrency)
+	owner := CopyOwner(Config.Account.Host, Config.Account.Id, href)
 
+	if err := Config.switchAccount(toAccount); err != nil {
+		fatalError("%s", err.Error())
+	}
+	copiedFrom = &owner
+	defer func() { copiedFrom = nil }()
+	stUpload([]string{file}, "", concurrency)
 }
 
-func stValidate(files []string) {
+func stValidate(files []string, escalationFiles []string) {
+	escalationNames, err := escalationNamesFromFiles(escalationFiles)
+	if err != nil {
+		fatalError("%s", err.Error())
+	}
 	err_encountered := false
 	for _, file := range files {
-		_, errors := validateServerTemplate(file)
+		st, errors := validateServerTemplate(file)
+		if st != nil && escalationNames != nil {
+			for _, name := range UndefinedEscalations(st.Alerts, escalationNames) {
+				fmt.Printf("WARNING: %s: Alerts refer to escalation '%s' which is not defined in the escalations files\n", file, name)
+			}
+		}
 		if len(errors) != 0 {
 			fmt.Println("Encountered the following errors with the ServerTemplate:")
 			err_encountered = true
@@ -598,7 +1072,7 @@ func stValidate(files []string) {
 		}
 	}
 	if err_encountered {
-		os.Exit(1)
+		exit(1)
 	}
 }
 
@@ -616,6 +1090,7 @@ func validateServerTemplate(file string) (*ServerTemplate, []error) {
 	if err != nil {
 		return nil, []error{err}
 	}
+	st.file = file
 	st.MultiCloudImages, err = ExpandMultiCloudImages(root, st.MultiCloudImages)
 	if err != nil {
 		return nil, []error{err}
@@ -624,8 +1099,18 @@ func validateServerTemplate(file string) (*ServerTemplate, []error) {
 	if err != nil {
 		return nil, []error{err}
 	}
+	if err := ExpandInputs(root, st); err != nil {
+		return nil, []error{err}
+	}
 
-	var errors []error
+	var (
+		errors   []error
+		warnings []string
+	)
+	st.Alerts, warnings, errors = ResolveAlerts(st.Alerts)
+	for _, warning := range warnings {
+		fmt.Printf("WARNING: %s: %s\n", file, warning)
+	}
 
 	//-------------------------------------
 	// MultiCloudImages
@@ -640,7 +1125,9 @@ func validateServerTemplate(file string) (*ServerTemplate, []error) {
 	for sequence, scripts := range st.RightScripts {
 		for i, rs := range scripts {
 			if rs.Type == PublishedRightScript {
-				if rs.Publisher != "" {
+				if getCatalog().Offline {
+					fmt.Printf("WARNING: Not validating RightScript '%s' Revision %s in offline mode\n", rs.Name, formatRev(rs.Revision))
+				} else if rs.Publisher != "" {
 					pub, err := findPublication("RightScript", rs.Name, rs.Revision, map[string]string{`Publisher`: rs.Publisher})
 
 					if err != nil {
@@ -680,10 +1167,17 @@ func validateServerTemplate(file string) (*ServerTemplate, []error) {
 	//-------------------------------------
 	// Alerts
 	//-------------------------------------
-	for i, alert := range st.Alerts {
-		err := validateAlert(alert)
+	if len(st.Alerts) > 0 {
+		metrics, err := AlertMetrics()
 		if err != nil {
-			errors = append(errors, fmt.Errorf("Alert %d error: %s", i, err.Error()))
+			errors = append(errors, err)
+		} else {
+			for i, alert := range st.Alerts {
+				err := validateAlert(alert, metrics)
+				if err != nil {
+					errors = append(errors, fmt.Errorf("Alert %d error: %s", i, err.Error()))
+				}
+			}
 		}
 	}
 
@@ -2,6 +2,9 @@ package main_test
 
 import (
 	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"strings"
 
 	. "github.com/onsi/ginkgo"
@@ -139,5 +142,29 @@ Some Bogus Field: Some bogus value
 				}))
 			})
 		})
+
+		Context("With Inputs in an Inputs file", func() {
+			It("should parse the reference and expand it", func() {
+				dir, err := ioutil.TempDir("", "right_st_inputs")
+				Expect(err).NotTo(HaveOccurred())
+				defer os.RemoveAll(dir)
+				Expect(os.Mkdir(filepath.Join(dir, "inputs"), 0755)).To(Succeed())
+				Expect(ioutil.WriteFile(filepath.Join(dir, "inputs", "test_st.yml"), []byte(`Inputs:
+  SERVER_HOSTNAME: text:test.local
+`), 0644)).To(Succeed())
+
+				st, err := ParseServerTemplate(strings.NewReader(`---
+Name: Test ST
+Description: Test ST Description
+Inputs: inputs/test_st.yml
+`))
+				Expect(err).To(Succeed())
+				Expect(st.InputsFile).To(Equal("inputs/test_st.yml"))
+				Expect(st.Inputs).To(BeNil())
+
+				Expect(ExpandInputs(dir, st)).To(Succeed())
+				Expect(st.Inputs).To(Equal(map[string]*InputValue{"SERVER_HOSTNAME": &InputValue{Type: "text", Value: "test.local"}}))
+			})
+		})
 	})
 })
@@ -0,0 +1,82 @@
+// Manager that runs the steps of an upload concurrently in dependency order
+
+package main
+
+import (
+	"errors"
+	"sync"
+)
+
+// uploadTask is one step of an upload, such as pushing a RightScript or setting the Inputs of a ServerTemplate. It runs
+// once every task it depends on succeeded and is skipped if any of them failed.
+type uploadTask struct {
+	run  func() error
+	deps []*uploadTask
+	done chan struct{} // closed once the task succeeded, failed or was skipped
+	err  error
+}
+
+// attachmentUploadTokens limits how many attachments are uploaded at once across all the RightScripts being pushed.
+var attachmentUploadTokens = make(chan struct{}, defaultConcurrency)
+
+// setAttachmentUploadConcurrency sizes attachmentUploadTokens from --concurrency. It must be called before any upload
+// starts.
+func setAttachmentUploadConcurrency(concurrency int) {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	attachmentUploadTokens = make(chan struct{}, concurrency)
+}
+
+// errUploadSkipped is the error of a task which did not run because a task it depends on failed.
+var errUploadSkipped = errors.New("skipped since a step it depends on failed")
+
+// uploadGraph is a set of upload tasks. Tasks can only depend on tasks added before them so there are no cycles.
+type uploadGraph struct {
+	tasks []*uploadTask
+}
+
+// add adds a task which runs after the tasks in deps.
+func (graph *uploadGraph) add(run func() error, deps ...*uploadTask) *uploadTask {
+	task := &uploadTask{run: run, deps: deps, done: make(chan struct{})}
+	graph.tasks = append(graph.tasks, task)
+	return task
+}
+
+// run runs the tasks with up to concurrency of them running at once and returns the errors of the tasks which failed
+// in the order the tasks were added. Tasks which do not depend on a failed task still run, so everything which can be
+// uploaded is and all the problems are reported at once.
+func (graph *uploadGraph) run(concurrency int) []error {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	tokens := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+	for _, task := range graph.tasks {
+		wg.Add(1)
+		go func(task *uploadTask) {
+			defer wg.Done()
+			defer close(task.done)
+			for _, dep := range task.deps {
+				<-dep.done
+				if dep.err != nil {
+					task.err = errUploadSkipped
+					return
+				}
+			}
+			// wait for an upload slot
+			tokens <- struct{}{}
+			defer func() { <-tokens }()
+			task.err = task.run()
+		}(task)
+	}
+	wg.Wait()
+
+	var errs []error
+	for _, task := range graph.tasks {
+		if task.err != nil && task.err != errUploadSkipped {
+			errs = append(errs, task.err)
+		}
+	}
+	return errs
+}
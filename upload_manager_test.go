@@ -0,0 +1,154 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Running upload tasks", func() {
+	var (
+		graph uploadGraph
+		lock  sync.Mutex
+		ran   []string
+	)
+
+	BeforeEach(func() {
+		graph = uploadGraph{}
+		ran = nil
+	})
+
+	step := func(name string, err error) func() error {
+		return func() error {
+			time.Sleep(time.Millisecond)
+			lock.Lock()
+			defer lock.Unlock()
+			ran = append(ran, name)
+			return err
+		}
+	}
+
+	It("runs tasks after the tasks they depend on", func() {
+		scripts := graph.add(step("scripts", nil))
+		mcis := graph.add(step("mcis", nil))
+		bindings := graph.add(step("bindings", nil), scripts)
+		inputs := graph.add(step("inputs", nil), bindings)
+		graph.add(step("finish", nil), inputs, mcis)
+
+		Expect(graph.run(4)).To(BeEmpty())
+		Expect(ran).To(HaveLen(5))
+		Expect(ran[len(ran)-1]).To(Equal("finish"))
+		indexOf := func(name string) int {
+			for i, r := range ran {
+				if r == name {
+					return i
+				}
+			}
+			return -1
+		}
+		Expect(indexOf("scripts")).To(BeNumerically("<", indexOf("bindings")))
+		Expect(indexOf("bindings")).To(BeNumerically("<", indexOf("inputs")))
+	})
+
+	It("skips tasks depending on a failed task and runs the others", func() {
+		scripts := graph.add(step("scripts", fmt.Errorf("push failed")))
+		graph.add(step("bindings", nil), scripts)
+		graph.add(step("alerts", fmt.Errorf("alerts failed")))
+		graph.add(step("mcis", nil))
+
+		errs := graph.run(2)
+		Expect(errs).To(Equal([]error{fmt.Errorf("push failed"), fmt.Errorf("alerts failed")}))
+		Expect(ran).To(ConsistOf("scripts", "alerts", "mcis"))
+	})
+
+	It("runs at most concurrency tasks at once", func() {
+		var running, most int
+		for i := 0; i < 10; i++ {
+			graph.add(func() error {
+				lock.Lock()
+				running++
+				if running > most {
+					most = running
+				}
+				lock.Unlock()
+				time.Sleep(5 * time.Millisecond)
+				lock.Lock()
+				running--
+				lock.Unlock()
+				return nil
+			})
+		}
+
+		Expect(graph.run(3)).To(BeEmpty())
+		Expect(most).To(BeNumerically("<=", 3))
+	})
+
+	It("uploads as many attachments at once as the concurrency", func() {
+		defer setAttachmentUploadConcurrency(defaultConcurrency)
+		setAttachmentUploadConcurrency(8)
+		Expect(cap(attachmentUploadTokens)).To(Equal(8))
+		setAttachmentUploadConcurrency(0)
+		Expect(cap(attachmentUploadTokens)).To(Equal(1))
+	})
+})
+
+var _ = Describe("Preparing a ServerTemplate upload", func() {
+	localScript := func(name, path string) *RightScript {
+		return &RightScript{Type: LocalRightScript, Path: path, Metadata: RightScriptMetadata{Name: name}}
+	}
+	managedMci := func(name, image string) *MultiCloudImage {
+		return &MultiCloudImage{Name: name, Settings: []*Setting{{Cloud: "EC2 us-east-1", InstanceType: "m3.medium", Image: image}}}
+	}
+	serverTemplate := func(name, file string, scripts []*RightScript, mcis ...*MultiCloudImage) *ServerTemplate {
+		return &ServerTemplate{
+			Name:             name,
+			file:             file,
+			RightScripts:     map[string][]*RightScript{"Boot": scripts},
+			MultiCloudImages: mcis,
+		}
+	}
+
+	It("shares RightScripts and MultiCloudImages used by several ServerTemplates", func() {
+		upload, err := newServerTemplateUpload([]*ServerTemplate{
+			serverTemplate("App", "app.yml", []*RightScript{localScript("Install", "scripts/install.sh")},
+				managedMci("Ubuntu", "ami-1")),
+			serverTemplate("Web", "web.yml", []*RightScript{
+				localScript("Install", "scripts/../scripts/install.sh"),
+				{Type: PublishedRightScript, Name: "Install", Revision: 3, Metadata: RightScriptMetadata{Name: "Install"}},
+			}, managedMci("Ubuntu", "ami-1"), &MultiCloudImage{Name: "CentOS", Revision: 4, Publisher: "RightScale"}),
+		}, "")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(upload.rightScripts).To(HaveLen(2))
+		Expect(upload.rightScripts[0].scripts).To(HaveLen(2))
+		Expect(upload.mcis).To(HaveLen(2))
+		Expect(upload.mcis[0].mcis).To(HaveLen(2))
+		Expect(upload.mcis[0].source).To(Equal("app.yml"))
+	})
+
+	It("refuses two ServerTemplates uploaded as the same one", func() {
+		_, err := newServerTemplateUpload([]*ServerTemplate{
+			serverTemplate("App", "app.yml", nil),
+			serverTemplate("App", "copy/app.yml", nil),
+		}, "")
+		Expect(err).To(MatchError("ServerTemplate 'App' is uploaded from both app.yml and copy/app.yml"))
+	})
+
+	It("refuses different RightScripts uploaded as the same one", func() {
+		_, err := newServerTemplateUpload([]*ServerTemplate{
+			serverTemplate("App", "app.yml", []*RightScript{localScript("Install", "app/install.sh")}),
+			serverTemplate("Web", "web.yml", []*RightScript{localScript("Install", "web/install.sh")}),
+		}, "")
+		Expect(err).To(MatchError("RightScript 'Install' is uploaded from both app/install.sh and web/install.sh"))
+	})
+
+	It("refuses a MultiCloudImage defined differently by ServerTemplates", func() {
+		_, err := newServerTemplateUpload([]*ServerTemplate{
+			serverTemplate("App", "app.yml", nil, managedMci("Ubuntu", "ami-1")),
+			serverTemplate("Web", "web.yml", nil, managedMci("Ubuntu", "ami-2")),
+		}, "")
+		Expect(err).To(MatchError("MultiCloudImage 'Ubuntu' is defined differently in app.yml and web.yml"))
+	})
+})
@@ -0,0 +1,279 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+	yaml3 "gopkg.in/yaml.v3"
+)
+
+// MergeYAML updates a YAML document to the values of another while leaving the text of everything which did not change
+// alone so comments, key order and formatting are kept. Block mappings are merged key by key; any other value which
+// changed, such as a sequence, is rewritten as a whole in the same style right_st writes YAML in. The updated document
+// must be a mapping.
+func MergeYAML(local, updated []byte) ([]byte, error) {
+	var updatedValue yaml.MapSlice
+	if err := yaml.Unmarshal(updated, &updatedValue); err != nil {
+		return nil, err
+	}
+	var document yaml3.Node
+	if err := yaml3.Unmarshal(local, &document); err != nil {
+		return nil, err
+	}
+	if document.Kind != yaml3.DocumentNode || len(document.Content) == 0 || !isBlockMapping(document.Content[0]) {
+		// nothing worth keeping
+		return yaml.Marshal(updatedValue)
+	}
+
+	text := string(local)
+	if !strings.HasSuffix(text, "\n") {
+		text += "\n"
+	}
+	lines := strings.SplitAfter(text, "\n")
+	lines = lines[:len(lines)-1] // SplitAfter leaves an empty string after the final newline
+
+	merge := &yamlMerge{lines: lines}
+	if err := merge.mapping(document.Content[0], updatedValue, merge.trimEnd(len(lines), 0, 0)); err != nil {
+		return nil, err
+	}
+
+	// apply the edits from the end so the line numbers of the rest stay valid, and edits at the same line in reverse so
+	// text inserted there ends up in order
+	for i, j := 0, len(merge.edits)-1; i < j; i, j = i+1, j-1 {
+		merge.edits[i], merge.edits[j] = merge.edits[j], merge.edits[i]
+	}
+	sort.SliceStable(merge.edits, func(i, j int) bool { return merge.edits[i].start > merge.edits[j].start })
+	for _, edit := range merge.edits {
+		replaced := append([]string{}, lines[:edit.start]...)
+		if edit.text != "" {
+			replaced = append(replaced, edit.text)
+		}
+		lines = append(replaced, lines[edit.end:]...)
+	}
+	return []byte(strings.Join(lines, "")), nil
+}
+
+type yamlMerge struct {
+	lines []string
+	edits []yamlEdit
+}
+
+// yamlEdit replaces the lines from start up to end with text.
+type yamlEdit struct {
+	start, end int
+	text       string
+}
+
+// mapping merges the keys of a block mapping node which ends before line end with updated values.
+func (merge *yamlMerge) mapping(node *yaml3.Node, updated yaml.MapSlice, end int) error {
+	indent := node.Content[0].Column - 1
+	updatedIndex := make(map[string]int)
+	for i, item := range updated {
+		updatedIndex[fmt.Sprint(item.Key)] = i
+	}
+
+	localKeys := make(map[string]bool)
+	blockEnd := end
+	for i := 0; i < len(node.Content); i += 2 {
+		key, value := node.Content[i], node.Content[i+1]
+		localKeys[key.Value] = true
+		start := key.Line - 1
+		blockEnd = end
+		if i+2 < len(node.Content) {
+			blockEnd = merge.trimEnd(node.Content[i+2].Line-1, start+1, indent)
+		}
+
+		index, ok := updatedIndex[key.Value]
+		if !ok {
+			merge.edits = append(merge.edits, yamlEdit{start: merge.headStart(start, indent), end: blockEnd})
+			continue
+		}
+		updatedValue := updated[index].Value
+		var localValue interface{}
+		if err := value.Decode(&localValue); err != nil {
+			return err
+		}
+		if reflect.DeepEqual(normalizeYAML(localValue), normalizeYAML(updatedValue)) {
+			continue
+		}
+		if updatedMapping, ok := updatedValue.(yaml.MapSlice); ok && isBlockMapping(value) && value.Line > key.Line {
+			if err := merge.mapping(value, updatedMapping, blockEnd); err != nil {
+				return err
+			}
+			continue
+		}
+		if updatedSequence, ok := updatedValue.([]interface{}); ok && isBlockSequence(value) && value.Line > key.Line &&
+			len(value.Content) == len(updatedSequence) {
+			if err := merge.sequence(value, updatedSequence, blockEnd); err != nil {
+				return err
+			}
+			continue
+		}
+		text, err := renderYAML(updated[index], indent)
+		if err != nil {
+			return err
+		}
+		merge.edits = append(merge.edits, yamlEdit{start: start, end: blockEnd, text: text})
+	}
+
+	var added []string
+	for _, item := range updated {
+		if localKeys[fmt.Sprint(item.Key)] {
+			continue
+		}
+		text, err := renderYAML(item, indent)
+		if err != nil {
+			return err
+		}
+		added = append(added, text)
+	}
+	if len(added) > 0 {
+		merge.edits = append(merge.edits, yamlEdit{start: blockEnd, end: blockEnd, text: strings.Join(added, "")})
+	}
+	return nil
+}
+
+// sequence merges the items of a block sequence node which ends before line end with updated items, one for each.
+// Mappings whose first key stays the same are merged key by key and other items which changed are rewritten.
+func (merge *yamlMerge) sequence(node *yaml3.Node, updated []interface{}, end int) error {
+	for i, item := range node.Content {
+		start := item.Line - 1
+		indent := lineIndent(merge.lines[start])
+		itemEnd := end
+		if i+1 < len(node.Content) {
+			itemEnd = merge.trimEnd(node.Content[i+1].Line-1, start+1, indent)
+		}
+
+		var localValue interface{}
+		if err := item.Decode(&localValue); err != nil {
+			return err
+		}
+		if reflect.DeepEqual(normalizeYAML(localValue), normalizeYAML(updated[i])) {
+			continue
+		}
+		// the first key shares its line with the dash of the item so it has to stay for the item to be merged
+		if updatedMapping, ok := updated[i].(yaml.MapSlice); ok && isBlockMapping(item) && len(updatedMapping) > 0 {
+			var firstValue interface{}
+			if err := item.Content[1].Decode(&firstValue); err != nil {
+				return err
+			}
+			if fmt.Sprint(updatedMapping[0].Key) == item.Content[0].Value &&
+				reflect.DeepEqual(normalizeYAML(firstValue), normalizeYAML(updatedMapping[0].Value)) {
+				if err := merge.mapping(item, updatedMapping, itemEnd); err != nil {
+					return err
+				}
+				continue
+			}
+		}
+		text, err := renderYAMLItem(updated[i], indent)
+		if err != nil {
+			return err
+		}
+		merge.edits = append(merge.edits, yamlEdit{start: start, end: itemEnd, text: text})
+	}
+	return nil
+}
+
+// trimEnd moves the end of a block back over blank lines and the comments which belong to whatever follows it.
+func (merge *yamlMerge) trimEnd(end, start, indent int) int {
+	for end > start {
+		line := merge.lines[end-1]
+		trimmed := strings.TrimSpace(line)
+		if trimmed != "" && !(strings.HasPrefix(trimmed, "#") && lineIndent(line) <= indent) {
+			break
+		}
+		end--
+	}
+	return end
+}
+
+// headStart moves the start of a block back over the comments directly above it.
+func (merge *yamlMerge) headStart(start, indent int) int {
+	for start > 0 {
+		line := merge.lines[start-1]
+		if !strings.HasPrefix(strings.TrimSpace(line), "#") || lineIndent(line) != indent {
+			break
+		}
+		start--
+	}
+	return start
+}
+
+func lineIndent(line string) int {
+	return len(line) - len(strings.TrimLeft(line, " "))
+}
+
+func isBlockMapping(node *yaml3.Node) bool {
+	return node.Kind == yaml3.MappingNode && node.Style&yaml3.FlowStyle == 0 && len(node.Content) > 0
+}
+
+func isBlockSequence(node *yaml3.Node) bool {
+	return node.Kind == yaml3.SequenceNode && node.Style&yaml3.FlowStyle == 0 && len(node.Content) > 0
+}
+
+// renderYAML writes a key and its value indented to the level of the mapping it is in.
+func renderYAML(item yaml.MapItem, indent int) (string, error) {
+	bytes, err := yaml.Marshal(yaml.MapSlice{item})
+	if err != nil {
+		return "", err
+	}
+	return indentYAML(string(bytes), indent), nil
+}
+
+func indentYAML(text string, indent int) string {
+	if indent == 0 {
+		return text
+	}
+	lines := strings.SplitAfter(text, "\n")
+	for i, line := range lines {
+		if strings.TrimSpace(line) != "" {
+			lines[i] = strings.Repeat(" ", indent) + line
+		}
+	}
+	return strings.Join(lines, "")
+}
+
+// renderYAMLItem writes an item of a sequence indented to the level of the sequence it is in.
+func renderYAMLItem(item interface{}, indent int) (string, error) {
+	bytes, err := yaml.Marshal([]interface{}{item})
+	if err != nil {
+		return "", err
+	}
+	return indentYAML(string(bytes), indent), nil
+}
+
+// normalizeYAML converts the values decoded by the two YAML packages to the same types so they can be compared.
+// Mappings are compared regardless of key order.
+func normalizeYAML(value interface{}) interface{} {
+	switch value := value.(type) {
+	case yaml.MapSlice:
+		normalized := make(map[string]interface{}, len(value))
+		for _, item := range value {
+			normalized[fmt.Sprint(item.Key)] = normalizeYAML(item.Value)
+		}
+		return normalized
+	case map[interface{}]interface{}:
+		normalized := make(map[string]interface{}, len(value))
+		for k, v := range value {
+			normalized[fmt.Sprint(k)] = normalizeYAML(v)
+		}
+		return normalized
+	case map[string]interface{}:
+		normalized := make(map[string]interface{}, len(value))
+		for k, v := range value {
+			normalized[k] = normalizeYAML(v)
+		}
+		return normalized
+	case []interface{}:
+		normalized := make([]interface{}, len(value))
+		for i, v := range value {
+			normalized[i] = normalizeYAML(v)
+		}
+		return normalized
+	default:
+		return value
+	}
+}
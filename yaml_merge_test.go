@@ -0,0 +1,176 @@
+package main_test
+
+import (
+	. "github.com/rightscale/right_st"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("MergeYAML", func() {
+	local := `# My ServerTemplate
+Name: My App
+Description: The app # one line
+Inputs:
+  # where to listen
+  PORT: text:80
+  USER: text:app
+RightScripts:
+  Boot:
+  - install.sh   # installs it
+  - configure.sh
+# trailing comment
+`
+
+	merge := func(updated string) string {
+		merged, err := MergeYAML([]byte(local), []byte(updated))
+		Expect(err).NotTo(HaveOccurred())
+		return string(merged)
+	}
+
+	It("leaves a document with the same values alone", func() {
+		Expect(merge(`
+Name: My App
+Description: The app
+RightScripts:
+  Boot: [install.sh, configure.sh]
+Inputs: {USER: "text:app", PORT: "text:80"}
+`)).To(Equal(local))
+	})
+
+	It("only rewrites the values which changed", func() {
+		Expect(merge(`
+Name: My App
+Description: The app
+Inputs:
+  PORT: text:8080
+  USER: text:app
+RightScripts:
+  Boot: [install.sh, configure.sh]
+`)).To(Equal(`# My ServerTemplate
+Name: My App
+Description: The app # one line
+Inputs:
+  # where to listen
+  PORT: text:8080
+  USER: text:app
+RightScripts:
+  Boot:
+  - install.sh   # installs it
+  - configure.sh
+# trailing comment
+`))
+	})
+
+	It("merges sequences of the same length item by item", func() {
+		Expect(merge(`
+Name: My App
+Description: The app
+Inputs:
+  PORT: text:80
+  USER: text:app
+RightScripts:
+  Boot: [install.sh, start.sh]
+`)).To(Equal(`# My ServerTemplate
+Name: My App
+Description: The app # one line
+Inputs:
+  # where to listen
+  PORT: text:80
+  USER: text:app
+RightScripts:
+  Boot:
+  - install.sh   # installs it
+  - start.sh
+# trailing comment
+`))
+	})
+
+	It("rewrites sequences which changed length as a whole", func() {
+		Expect(merge(`
+Name: My App
+Description: The app
+Inputs:
+  PORT: text:80
+  USER: text:app
+RightScripts:
+  Boot: [install.sh, configure.sh, start.sh]
+`)).To(Equal(`# My ServerTemplate
+Name: My App
+Description: The app # one line
+Inputs:
+  # where to listen
+  PORT: text:80
+  USER: text:app
+RightScripts:
+  Boot:
+  - install.sh
+  - configure.sh
+  - start.sh
+# trailing comment
+`))
+	})
+
+	It("merges mappings in sequences which keep their first key", func() {
+		local := `Alerts:
+# paged on
+- Name: cpu busy # the usual one
+  Clause: If cpu-0/cpu-idle.value < 15 for 3 minutes Then escalate critical
+- Name: low memory
+  Clause: If memory/memory-free.value < 1000000 for 5 minutes Then escalate warning
+`
+		merged, err := MergeYAML([]byte(local), []byte(`
+Alerts:
+- Name: cpu busy
+  Clause: If cpu-0/cpu-idle.value < 10 for 3 minutes Then escalate critical
+- Name: disk full
+  Clause: If df-root/df_complex-free.value < 1000000 for 5 minutes Then escalate warning
+`))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(string(merged)).To(Equal(`Alerts:
+# paged on
+- Name: cpu busy # the usual one
+  Clause: If cpu-0/cpu-idle.value < 10 for 3 minutes Then escalate critical
+- Name: disk full
+  Clause: If df-root/df_complex-free.value < 1000000 for 5 minutes Then escalate warning
+`))
+	})
+
+	It("removes and adds keys", func() {
+		Expect(merge(`
+Name: My App
+Description: The app
+Inputs:
+  USER: text:app
+  GROUP: text:app
+RightScripts:
+  Boot: [install.sh, configure.sh]
+  Decommission: [stop.sh]
+Alerts:
+- Name: cpu busy
+  Clause: If cpu-0/cpu-idle.value < 15 for 3 minutes Then escalate critical
+`)).To(Equal(`# My ServerTemplate
+Name: My App
+Description: The app # one line
+Inputs:
+  USER: text:app
+  GROUP: text:app
+RightScripts:
+  Boot:
+  - install.sh   # installs it
+  - configure.sh
+  Decommission:
+  - stop.sh
+Alerts:
+- Name: cpu busy
+  Clause: If cpu-0/cpu-idle.value < 15 for 3 minutes Then escalate critical
+# trailing comment
+`))
+	})
+
+	It("rewrites a document which is not a mapping", func() {
+		merged, err := MergeYAML([]byte("- a\n"), []byte("Name: b\n"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(string(merged)).To(Equal("Name: b\n"))
+	})
+})